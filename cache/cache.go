@@ -1,58 +1,178 @@
 package cache
 
 import (
+	"net"
+	"os"
+	"time"
+
 	"github.com/ben-han-cn/g53"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/ecs"
 	"github.com/ben-han-cn/vanguard/httpcmd"
+	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/metrics"
 	view "github.com/ben-han-cn/vanguard/viewselector"
 )
 
+const (
+	defaultPrefetchRate   = 10
+	defaultStaleTtl       = 86400
+	defaultStaleAnswerTtl = 30
+	//milliseconds, rfc8767 suggests 1.8 seconds
+	defaultStaleClientTimeout = 1800
+)
+
 type Cache struct {
 	core.DefaultHandler
-	cache map[string]*ViewCache
+	cache              map[string]*ViewCache
+	serveStale         bool
+	staleAnswerTtl     g53.RRTTL
+	staleClientTimeout time.Duration
+	staleEDE           bool
+	snapshotFile       string
 }
 
 func NewCache(conf *config.VanguardConf) core.DNSQueryHandler {
 	c := &Cache{}
 	c.ReloadConfig(conf)
-	httpcmd.RegisterHandler(c, []httpcmd.Command{&CleanCache{}, &CleanViewCache{}, &CleanDomainCache{}, &CleanRRsetsCache{}, &GetDomainCache{}, &GetMessageCache{}})
+	if c.snapshotFile != "" {
+		if count, err := c.LoadSnapshot(c.snapshotFile); err == nil {
+			logger.GetLogger().Info("load %d messages from cache snapshot %s", count, c.snapshotFile)
+		} else if os.IsNotExist(err) == false {
+			logger.GetLogger().Error("load cache snapshot %s failed:%s", c.snapshotFile, err.Error())
+		}
+	}
+	httpcmd.RegisterHandler(c, []httpcmd.Command{&CleanCache{}, &CleanViewCache{}, &CleanDomainCache{}, &CleanRRsetsCache{}, &GetDomainCache{}, &GetMessageCache{}, &DumpCache{}})
 	return c
 }
 
+func (c *Cache) Shutdown() {
+	if c.snapshotFile == "" {
+		return
+	}
+
+	if count, err := c.DumpSnapshot(c.snapshotFile); err == nil {
+		logger.GetLogger().Info("dump %d messages to cache snapshot %s", count, c.snapshotFile)
+	} else {
+		logger.GetLogger().Error("dump cache snapshot %s failed:%s", c.snapshotFile, err.Error())
+	}
+}
+
 func (c *Cache) ReloadConfig(conf *config.VanguardConf) {
+	views := []string{view.DefaultView}
+	for name, _ := range view.GetViewAndIds() {
+		if name != view.DefaultView {
+			views = append(views, name)
+		}
+	}
+
 	cache := make(map[string]*ViewCache)
-	for view, _ := range view.GetViewAndIds() {
-		if _, exist := c.cache[view]; exist {
-			cache[view] = c.cache[view]
-			cache[view].ResetCapacity(int(conf.Cache.MaxCacheSize))
+	for _, name := range views {
+		vc := cacheConfInView(&conf.Cache, name)
+		if viewCache, exist := c.cache[name]; exist {
+			viewCache.ResetCapacity(int(vc.MaxCacheSize))
+			cache[name] = viewCache
 		} else {
-			cache[view] = newViewCache(int(conf.Cache.MaxCacheSize))
+			cache[name] = newViewCache(name, int(vc.MaxCacheSize))
 		}
+		cache[name].SetMemoryLimit(vc.MaxMemory)
+		cache[name].SetShortAnswer(*vc.ShortAnswer)
+		cache[name].SetTtlLimit(TtlLimit{
+			Min:         vc.MinTtl,
+			MaxPositive: vc.PositiveTtl,
+			MaxNegative: vc.NegativeTtl,
+		})
 	}
 
-	if defaultCache, exist := cache[view.DefaultView]; !exist {
-		cache[view.DefaultView] = newViewCache(int(conf.Cache.MaxCacheSize))
-	} else {
-		defaultCache.ResetCapacity(int(conf.Cache.MaxCacheSize))
+	var prefetchRate uint32
+	if conf.Cache.Prefetch {
+		prefetchRate = conf.Cache.PrefetchRate
+		if prefetchRate == 0 || prefetchRate > 100 {
+			prefetchRate = defaultPrefetchRate
+		}
+	}
+	var staleWindow time.Duration
+	c.serveStale = conf.Cache.ServeStale
+	if c.serveStale {
+		staleWindow = time.Duration(conf.Cache.StaleTtl) * time.Second
+		if staleWindow == 0 {
+			staleWindow = defaultStaleTtl * time.Second
+		}
+		c.staleAnswerTtl = g53.RRTTL(conf.Cache.StaleAnswerTtl)
+		if c.staleAnswerTtl == 0 {
+			c.staleAnswerTtl = defaultStaleAnswerTtl
+		}
+		c.staleClientTimeout = time.Duration(conf.Cache.StaleClientTimeout) * time.Millisecond
+		if c.staleClientTimeout == 0 {
+			c.staleClientTimeout = defaultStaleClientTimeout * time.Millisecond
+		}
+		c.staleEDE = conf.Cache.StaleEDE
+	}
+
+	for _, viewCache := range cache {
+		viewCache.SetPrefetchRate(prefetchRate)
+		viewCache.SetStaleWindow(staleWindow)
 	}
 
+	c.snapshotFile = conf.Cache.SnapshotFile
 	c.cache = cache
 }
 
+func cacheConfInView(conf *config.CacheConf, view string) config.CacheInView {
+	vc := config.CacheInView{
+		View:         view,
+		MaxCacheSize: conf.MaxCacheSize,
+		MaxMemory:    conf.MaxMemory,
+		MinTtl:       conf.MinTtl,
+		PositiveTtl:  conf.PositiveTtl,
+		NegativeTtl:  conf.NegativeTtl,
+		ShortAnswer:  &conf.ShortAnswer,
+	}
+	for _, c := range conf.ViewCaches {
+		if c.View != view {
+			continue
+		}
+		if c.MaxCacheSize != 0 {
+			vc.MaxCacheSize = c.MaxCacheSize
+		}
+		if c.MaxMemory != 0 {
+			vc.MaxMemory = c.MaxMemory
+		}
+		if c.MinTtl != 0 {
+			vc.MinTtl = c.MinTtl
+		}
+		if c.PositiveTtl != 0 {
+			vc.PositiveTtl = c.PositiveTtl
+		}
+		if c.NegativeTtl != 0 {
+			vc.NegativeTtl = c.NegativeTtl
+		}
+		if c.ShortAnswer != nil {
+			vc.ShortAnswer = c.ShortAnswer
+		}
+	}
+	return vc
+}
+
 func (c *Cache) HandleQuery(ctx *core.Context) {
 	client := &ctx.Client
-	msg, found := c.get(client)
+	defer c.shortenResponse(client)
+	msg, prefetch, found := c.get(client)
 	client.CacheHit = found
 
 	if found {
 		metrics.RecordCacheHit(client.View)
+		if prefetch {
+			go c.prefetch(*client)
+		}
 		response := *msg
 		response.Header.Id = client.Request.Header.Id
 		response.Header.SetFlag(g53.FLAG_AA, false)
 		response.Question = client.Request.Question
 		client.Response = &response
+	} else if c.serveStale {
+		c.resolveOrServeStale(ctx)
 	} else {
 		core.PassToNext(c, ctx)
 		if client.Response != nil && client.CacheAnswer {
@@ -61,16 +181,126 @@ func (c *Cache) HandleQuery(ctx *core.Context) {
 	}
 }
 
+func (c *Cache) resolveOrServeStale(ctx *core.Context) {
+	//rfc8767, stale answer is served if resolving doesn't finish before
+	//the client response timer fires, the resolving goes on to refresh
+	//the cache
+	client := &ctx.Client
+	view := client.View
+	request := *client.Request
+	resolveCtx := core.NewContext().Clone(client)
+	resolveCtx.Client.Request = &request
+	done := make(chan struct{})
+	go func() {
+		core.PassToNext(c, resolveCtx)
+		if resp := resolveCtx.Client.Response; isResolveFailed(resp) == false && resolveCtx.Client.CacheAnswer {
+			c.AddMessage(view, resp)
+		}
+		close(done)
+	}()
+
+	timer := time.NewTimer(c.staleClientTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		if c.answerFromStale(client) {
+			return
+		}
+		<-done
+	}
+
+	if isResolveFailed(resolveCtx.Client.Response) && c.answerFromStale(client) {
+		return
+	}
+	client.Response = resolveCtx.Client.Response
+	client.CacheAnswer = resolveCtx.Client.CacheAnswer
+}
+
+func (c *Cache) shortenResponse(client *core.Client) {
+	if client.Response == nil {
+		return
+	}
+
+	if viewCache, ok := c.cache[client.View]; ok && viewCache.shortAnswer {
+		edns := client.Request.Edns
+		client.Response = minimizeResponse(client.Response, edns != nil && edns.DnssecAware)
+	}
+}
+
+func isResolveFailed(resp *g53.Message) bool {
+	return resp == nil || resp.Header.Rcode == g53.R_SERVFAIL
+}
+
+func (c *Cache) answerFromStale(client *core.Client) bool {
+	messageCache, ok := c.cache[client.View]
+	if ok == false {
+		return false
+	}
+
+	response, found := messageCache.GetStale(client.Request, c.staleAnswerTtl)
+	if found == false {
+		return false
+	}
+
+	response.Header.Id = client.Request.Header.Id
+	response.Header.SetFlag(g53.FLAG_AA, false)
+	if c.staleEDE && client.Request.Edns != nil {
+		response.Edns = &g53.EDNS{
+			UdpSize:     client.Request.Edns.UdpSize,
+			DnssecAware: client.Request.Edns.DnssecAware,
+			Options:     []g53.Option{&ExtendedError{InfoCode: EDEStaleAnswer}},
+		}
+	}
+	client.Response = response
+	client.CacheHit = true
+	return true
+}
+
 func (c *Cache) AddMessage(view string, msg *g53.Message) {
 	if messageCache, ok := c.cache[view]; ok {
 		messageCache.Add(msg)
+		metrics.RecordCacheMemory(view, messageCache.MemoryUsage())
 	}
 }
 
-func (c *Cache) get(client *core.Client) (*g53.Message, bool) {
+func (c *Cache) get(client *core.Client) (*g53.Message, bool, bool) {
 	if messageCache, ok := c.cache[client.View]; ok {
-		return messageCache.Get(client.Request)
+		var ip net.IP
+		if client.Addr != nil {
+			ip = client.IP()
+		}
+		return messageCache.Get(client.Request, ip)
 	} else {
-		return nil, false
+		return nil, false, false
+	}
+}
+
+func (c *Cache) prefetch(client core.Client) {
+	//the refresh walks the rest of the chain like a cache miss, so the
+	//resolver query limit coalesces it with queries for the same key
+	request := client.Request
+	udpSize, dnssec := 512, false
+	if request.Edns != nil {
+		udpSize, dnssec = int(request.Edns.UdpSize), request.Edns.DnssecAware
+	}
+	query := g53.MakeQuery(request.Question.Name, request.Question.Type, udpSize, dnssec)
+	query.Header.SetFlag(g53.FLAG_RD, request.Header.GetFlag(g53.FLAG_RD))
+	query.Header.SetFlag(g53.FLAG_CD, request.Header.GetFlag(g53.FLAG_CD))
+	if subnet := ecs.FromEdns(request.Edns); subnet != nil {
+		ecs.SetEdns(query.Edns, subnet)
+	}
+
+	ctx := core.NewContext()
+	ctx.Client = client
+	ctx.Client.Request = query
+	ctx.Client.Response = nil
+	ctx.Client.CacheHit = false
+	ctx.Client.CacheAnswer = true
+	ctx.Client.CreateTime = time.Now()
+	core.PassToNext(c, ctx)
+	if ctx.Client.Response != nil && ctx.Client.CacheAnswer {
+		c.AddMessage(client.View, ctx.Client.Response)
+		metrics.RecordCachePrefetch(client.View)
 	}
 }
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/dnssec"
+)
+
+type countResolver struct {
+	core.DefaultHandler
+	count int32
+	ttl   int
+	fail  bool
+	delay time.Duration
+}
+
+func (r *countResolver) HandleQuery(ctx *core.Context) {
+	count := atomic.AddInt32(&r.count, 1)
+	client := &ctx.Client
+	time.Sleep(r.delay)
+	if r.fail {
+		return
+	}
+	ttl := r.ttl
+	if ttl == 0 {
+		ttl = 10 * int(count)
+	}
+	client.Response = buildMessage(client.Request.Question.Name.String(false), []string{"1.1.1.1"}, ttl)
+	client.Response.Header.Id = client.Request.Header.Id
+}
+
+func cacheQuery(c *Cache, name string) *g53.Message {
+	ctx := core.NewContext()
+	ctx.Reset()
+	ctx.Client.Addr, _ = net.ResolveUDPAddr("udp", "127.0.0.1:5555")
+	ctx.Client.Request = g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false)
+	c.HandleQuery(ctx)
+	return ctx.Client.Response
+}
+
+func TestCachePrefetch(t *testing.T) {
+	c := &Cache{cache: map[string]*ViewCache{"default": newViewCache("default", 10)}}
+	c.cache["default"].SetPrefetchRate(100)
+	resolver := &countResolver{}
+	core.BuildQueryChain(c, resolver)
+
+	query := func() *g53.Message {
+		return cacheQuery(c, "test.example.com.")
+	}
+
+	query()
+	query()
+	ut.Equal(t, atomic.LoadInt32(&resolver.count), int32(1))
+	query()
+	for i := 0; i < 100 && atomic.LoadInt32(&resolver.count) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ut.Equal(t, atomic.LoadInt32(&resolver.count), int32(2))
+
+	time.Sleep(10 * time.Millisecond)
+	resp := query()
+	ut.Assert(t, resp.Sections[g53.AnswerSection][0].Ttl > 10, "entry should be refreshed")
+	ut.Equal(t, atomic.LoadInt32(&resolver.count), int32(2))
+}
+
+func TestCacheServeStale(t *testing.T) {
+	c := &Cache{
+		cache:              map[string]*ViewCache{"default": newViewCache("default", 10)},
+		serveStale:         true,
+		staleAnswerTtl:     30,
+		staleClientTimeout: time.Second,
+		staleEDE:           true,
+	}
+	c.cache["default"].SetStaleWindow(time.Minute)
+	resolver := &countResolver{ttl: 1}
+	core.BuildQueryChain(c, resolver)
+
+	cacheQuery(c, "test.example.com.")
+	time.Sleep(1100 * time.Millisecond)
+	resolver.fail = true
+	resp := cacheQuery(c, "test.example.com.")
+	ut.Equal(t, atomic.LoadInt32(&resolver.count), int32(2))
+	ut.Assert(t, resp != nil, "stale answer should be served")
+	ut.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, g53.RRTTL(30))
+	ut.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+	ut.Equal(t, resp.Edns.Options[0].(*ExtendedError).InfoCode, uint16(EDEStaleAnswer))
+
+	ut.Assert(t, cacheQuery(c, "nx.example.com.") == nil, "name never cached has no stale answer")
+
+	c.cache["default"].SetStaleWindow(0)
+	ut.Assert(t, cacheQuery(c, "test.example.com.") == nil, "stale answer is disabled")
+}
+
+func TestCacheServeStaleOnClientTimeout(t *testing.T) {
+	c := &Cache{
+		cache:              map[string]*ViewCache{"default": newViewCache("default", 10)},
+		serveStale:         true,
+		staleAnswerTtl:     30,
+		staleClientTimeout: 50 * time.Millisecond,
+	}
+	c.cache["default"].SetStaleWindow(time.Minute)
+	resolver := &countResolver{ttl: 1}
+	core.BuildQueryChain(c, resolver)
+
+	cacheQuery(c, "test.example.com.")
+	time.Sleep(1100 * time.Millisecond)
+	resolver.ttl = 300
+	resolver.delay = 300 * time.Millisecond
+	start := time.Now()
+	resp := cacheQuery(c, "test.example.com.")
+	ut.Assert(t, time.Since(start) < resolver.delay, "stale answer should be served before resolving finishes")
+	ut.Assert(t, resp != nil, "stale answer should be served")
+	ut.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, g53.RRTTL(30))
+
+	time.Sleep(resolver.delay)
+	resp = cacheQuery(c, "test.example.com.")
+	ut.Assert(t, resp.Sections[g53.AnswerSection][0].Ttl > 30, "cache should be refreshed by the resolving")
+	ut.Equal(t, atomic.LoadInt32(&resolver.count), int32(2))
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	c := &Cache{cache: map[string]*ViewCache{"default": newViewCache("default", 10), "v1": newViewCache("v1", 10)}}
+	c.cache["default"].Add(buildMessage("www.example.com.", []string{"1.1.1.1", "2.2.2.2"}, 300))
+	c.cache["v1"].Add(buildMessage("www.example.com.", []string{"3.3.3.3"}, 300))
+	c.cache["v1"].Add(buildNegativeMessage("nx.example.com.", g53.R_NXDOMAIN, "example.com. 3600 IN SOA ns.example.com. root.example.com. 1 3600 600 86400 300"))
+
+	dir, _ := ioutil.TempDir("", "vanguard-cache")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot")
+	count, err := c.DumpSnapshot(file)
+	ut.Assert(t, err == nil, "dump snapshot failed %v", err)
+	ut.Equal(t, count, 3)
+
+	restored := &Cache{cache: map[string]*ViewCache{"default": newViewCache("default", 10), "v1": newViewCache("v1", 10)}}
+	count, err = restored.LoadSnapshot(file)
+	ut.Assert(t, err == nil, "load snapshot failed %v", err)
+	ut.Equal(t, count, 3)
+
+	get := func(view, name string) *g53.Message {
+		req := g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false)
+		msg, _, _ := restored.cache[view].Get(req, nil)
+		return msg
+	}
+	answer := get("default", "www.example.com.").Sections[g53.AnswerSection][0]
+	ut.Equal(t, len(answer.Rdatas), 2)
+	ut.Assert(t, answer.Ttl > 290 && answer.Ttl <= 300, "remaining ttl should be kept")
+	ut.Equal(t, get("v1", "www.example.com.").Sections[g53.AnswerSection][0].Rdatas[0].String(), "3.3.3.3")
+	ut.Equal(t, get("v1", "a.nx.example.com.").Header.Rcode, g53.R_NXDOMAIN)
+}
+
+func TestCacheShortAnswer(t *testing.T) {
+	c := &Cache{cache: map[string]*ViewCache{"default": newViewCache("default", 10)}}
+	c.cache["default"].SetShortAnswer(true)
+	resolver := &countResolver{ttl: 300}
+	core.BuildQueryChain(c, resolver)
+
+	withAuthority := func(msg *g53.Message) *g53.Message {
+		ns, _ := g53.RRsetFromString("example.com. 300 IN NS ns.example.com.")
+		glue, _ := g53.RRsetFromString("ns.example.com. 300 IN A 2.2.2.2")
+		msg.Sections[g53.AuthSection] = g53.Section{ns}
+		msg.Sections[g53.AdditionalSection] = g53.Section{glue}
+		msg.RecalculateSectionRRCount()
+		return msg
+	}
+
+	full := withAuthority(buildMessage("www.example.com.", []string{"1.1.1.1"}, 300))
+	short := minimizeResponse(full, false)
+	ut.Equal(t, len(short.Sections[g53.AuthSection]), 0)
+	ut.Equal(t, len(short.Sections[g53.AdditionalSection]), 0)
+	ut.Equal(t, short.Header.NSCount, uint16(0))
+	ut.Equal(t, len(full.Sections[g53.AuthSection]), 1)
+
+	nsec, _ := dnssec.RRsetFromString("a.example.com. 300 IN NSEC z.example.com. A RRSIG NSEC")
+	full.Sections[g53.AuthSection] = append(full.Sections[g53.AuthSection], nsec)
+	ut.Equal(t, len(minimizeResponse(full, true).Sections[g53.AuthSection]), 1)
+
+	negative := buildNegativeMessage("nx.example.com.", g53.R_NXDOMAIN, "example.com. 3600 IN SOA ns.example.com. root.example.com. 1 3600 600 86400 300")
+	ut.Equal(t, len(minimizeResponse(negative, false).Sections[g53.AuthSection]), 1)
+
+	c.AddMessage("default", withAuthority(buildMessage("cached.example.com.", []string{"1.1.1.1"}, 300)))
+	resp := cacheQuery(c, "cached.example.com.")
+	ut.Equal(t, len(resp.Sections[g53.AuthSection]), 0)
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+
+	c.cache["default"].SetShortAnswer(false)
+	resp = cacheQuery(c, "cached.example.com.")
+	ut.Equal(t, len(resp.Sections[g53.AuthSection]), 1)
+}
@@ -59,6 +59,13 @@ func (g *GetMessageCache) String() string {
 	return fmt.Sprintf("name: get rrsets from cache and params:{name:%s, type:%s, view:%s}", g.Name, g.Type, g.View)
 }
 
+type DumpCache struct {
+}
+
+func (d *DumpCache) String() string {
+	return "name: dump cache to snapshot file"
+}
+
 func (cache *Cache) HandleCmd(cmd httpcmd.Command) (interface{}, *httpcmd.Error) {
 	switch c := cmd.(type) {
 	case *CleanCache:
@@ -73,6 +80,8 @@ func (cache *Cache) HandleCmd(cmd httpcmd.Command) (interface{}, *httpcmd.Error)
 		return cache.getDomainCache(c.Name, c.Type)
 	case *GetMessageCache:
 		return cache.getMessageCacheInView(c.View, c.Name, c.Type)
+	case *DumpCache:
+		return cache.dumpCache()
 	default:
 		panic("shouldn't be here")
 	}
@@ -103,6 +112,18 @@ func (c *Cache) cleanRRsetsCache(view string, name string, types []g53.RRType) (
 	return nil, nil
 }
 
+func (c *Cache) dumpCache() (interface{}, *httpcmd.Error) {
+	if c.snapshotFile == "" {
+		return nil, ErrNoSnapshotFile
+	}
+
+	count, err := c.DumpSnapshot(c.snapshotFile)
+	if err != nil {
+		return nil, ErrDumpSnapshotFail.AddDetail(err.Error())
+	}
+	return count, nil
+}
+
 type RRInCache struct {
 	Name  string `json:"name"`
 	Type  string `json:"type"`
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/ben-han-cn/g53"
+)
+
+const (
+	EDNS_EDE       = 15 //extended dns error, rfc8914
+	EDEStaleAnswer = 3
+)
+
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+func (e *ExtendedError) Rend(render *g53.MsgRender) {
+	render.WriteUint16(EDNS_EDE)
+	render.WriteUint16(uint16(2 + len(e.ExtraText)))
+	render.WriteUint16(e.InfoCode)
+	render.WriteData([]byte(e.ExtraText))
+}
+
+func (e *ExtendedError) String() string {
+	return fmt.Sprintf("; EDE: %d (%s)\n", e.InfoCode, e.ExtraText)
+}
@@ -0,0 +1,10 @@
+package cache
+
+import (
+	"github.com/ben-han-cn/vanguard/httpcmd"
+)
+
+var (
+	ErrNoSnapshotFile   = httpcmd.NewError(httpcmd.CacheErrCodeStart, "cache snapshot file isn't configured")
+	ErrDumpSnapshotFail = httpcmd.NewError(httpcmd.CacheErrCodeStart+1, "dump cache snapshot failed")
+)
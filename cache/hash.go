@@ -4,16 +4,39 @@ import (
 	"unsafe"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
 	"github.com/cespare/xxhash"
 )
 
 func HashQuery(name *g53.Name, typ g53.RRType) (uint64, uint64) {
+	return hashQuery(name, typ, nil)
+}
+
+func HashSubnetQuery(name *g53.Name, typ g53.RRType, subnet *ecs.ClientSubnet) (uint64, uint64) {
+	return hashQuery(name, typ, subnetKey(subnet))
+}
+
+func subnetKey(subnet *ecs.ClientSubnet) []byte {
+	//network the answer applies to, which is the address masked by scope
+	if subnet == nil {
+		return nil
+	}
+	ip := subnet.Mask(subnet.IP, subnet.ScopePrefix)
+	key := make([]byte, 2+len(ip))
+	key[0] = byte(subnet.Family)
+	key[1] = subnet.ScopePrefix
+	copy(key[2:], ip)
+	return key
+}
+
+func hashQuery(name *g53.Name, typ g53.RRType, subnet []byte) (uint64, uint64) {
 	l := len(name.Bytes()) + 1 + 2
-	raw := make([]byte, l)
+	raw := make([]byte, l+len(subnet))
 	copy(raw, name.Bytes())
 	raw[l-3] = '+'
 	raw[l-1] = byte(uint16(typ) & 0xff)
 	raw[l-2] = byte(uint16(typ) >> 8)
+	copy(raw[l:], subnet)
 	return MemHash(raw), xxhash.Sum64(raw)
 }
 
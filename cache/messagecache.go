@@ -4,13 +4,22 @@ import (
 	"container/list"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
+	"github.com/ben-han-cn/vanguard/metrics"
 )
 
 const (
 	RRsetVsMessageRatio = 5
+	PrefetchMinHits     = 2
+	MaxShardCount       = 64
+	MinShardCapacity    = 1024
+
+	messageEntryOverhead = 128
+	rrsetHashSize        = 16
 )
 
 type RRsetHash struct {
@@ -19,52 +28,270 @@ type RRsetHash struct {
 }
 
 type MessageEntry struct {
+	name            *g53.Name
+	typ             g53.RRType
 	keyHash         uint64
 	conflictHash    uint64
 	rcode           g53.Rcode
+	secure          bool
 	answerCount     uint16
 	authorityCount  uint16
 	additionalCount uint16
 	rrsets          []RRsetHash
 	expireTime      time.Time
+	ttl             time.Duration
+	hits            uint32
+	prefetching     bool
+	size            int64
 }
 
 func (e *MessageEntry) IsExpire() bool {
 	return e.expireTime.Before(time.Now())
 }
 
+func (e *MessageEntry) needPrefetch(rate uint32) bool {
+	if rate == 0 || e.prefetching || e.hits < PrefetchMinHits {
+		return false
+	}
+	return time.Until(e.expireTime) <= e.ttl*time.Duration(rate)/100
+}
+
+type TtlLimit struct {
+	Min         uint32
+	MaxPositive uint32
+	MaxNegative uint32
+}
+
+func (l TtlLimit) clamp(ttl uint32, max uint32) uint32 {
+	if max != 0 && ttl > max {
+		ttl = max
+	}
+	if ttl < l.Min {
+		ttl = l.Min
+	}
+	return ttl
+}
+
 type MessageCache struct {
-	cap        int
-	data       map[uint64]*list.Element
-	ll         *list.List
-	mu         sync.Mutex
+	shards     []*messageShard
 	rrsetCache *RRsetCache
+	memory     *memoryBudget
+	evictNext  uint32
 }
 
 func newMessageCache(cap int) *MessageCache {
-	return &MessageCache{
+	//small cache keeps one shard so lru is exact
+	shardCount := MaxShardCount
+	for shardCount > 1 && cap/shardCount < MinShardCapacity {
+		shardCount /= 2
+	}
+	return newShardedMessageCache(cap, shardCount)
+}
+
+func newShardedMessageCache(cap int, shardCount int) *MessageCache {
+	c := &MessageCache{
+		shards:     make([]*messageShard, shardCount),
+		rrsetCache: newRRsetCache(cap*RRsetVsMessageRatio, shardCount),
+	}
+	for i := range c.shards {
+		c.shards[i] = newMessageShard(shardCapacity(cap, shardCount), c.rrsetCache)
+	}
+	c.setView("", &memoryBudget{})
+	return c
+}
+
+func shardCapacity(cap int, shardCount int) int {
+	return (cap + shardCount - 1) / shardCount
+}
+
+func (c *MessageCache) shard(keyHash uint64) *messageShard {
+	return c.shards[keyHash%uint64(len(c.shards))]
+}
+
+func (c *MessageCache) Len() int {
+	size := 0
+	for _, shard := range c.shards {
+		size += shard.size()
+	}
+	return size
+}
+
+func (c *MessageCache) SetPrefetchRate(rate uint32) {
+	for _, shard := range c.shards {
+		shard.setPrefetchRate(rate)
+	}
+}
+
+func (c *MessageCache) SetStaleWindow(window time.Duration) {
+	for _, shard := range c.shards {
+		shard.setStaleWindow(window)
+	}
+}
+
+func (c *MessageCache) SetTtlLimit(limit TtlLimit) {
+	for _, shard := range c.shards {
+		shard.setTtlLimit(limit)
+	}
+}
+
+func (c *MessageCache) setView(view string, memory *memoryBudget) {
+	c.memory = memory
+	for _, shard := range c.shards {
+		shard.view = view
+		shard.memory = memory
+	}
+	c.rrsetCache.setView(view, memory)
+}
+
+func (c *MessageCache) evictByMemory() {
+	//evict the least recently used entries of each shard in turn, so
+	//memory is reclaimed from the whole cache, not only the shard which
+	//inserts the entry
+	idle := 0
+	for c.memory.exceeded() && idle < len(c.shards) {
+		i := atomic.AddUint32(&c.evictNext, 1)
+		evicted := c.shards[i%uint32(len(c.shards))].evictOldest()
+		rrsetShards := c.rrsetCache.shards
+		if rrsetShards[i%uint32(len(rrsetShards))].evictOldest() {
+			evicted = true
+		}
+		if evicted {
+			idle = 0
+		} else {
+			idle += 1
+		}
+	}
+}
+
+func (c *MessageCache) ResetCapacity(cap int) {
+	for _, shard := range c.shards {
+		shard.resetCapacity(shardCapacity(cap, len(c.shards)))
+	}
+	c.rrsetCache.resetCapacity(cap * RRsetVsMessageRatio)
+}
+
+func (c *MessageCache) Get(req *g53.Message) (*g53.Message, bool) {
+	msg, _, found := c.Lookup(req)
+	return msg, found
+}
+
+func (c *MessageCache) Lookup(req *g53.Message) (*g53.Message, bool, bool) {
+	//besides the message, report whether the entry is popular and close
+	//to expire, only the first caller is asked to refresh it
+	keyHash, conflictHash := HashQuery(req.Question.Name, req.Question.Type)
+	return c.shard(keyHash).lookup(req, keyHash, conflictHash)
+}
+
+func (c *MessageCache) GetStale(req *g53.Message, ttl g53.RRTTL) (*g53.Message, bool) {
+	keyHash, conflictHash := HashQuery(req.Question.Name, req.Question.Type)
+	return c.shard(keyHash).getStale(req, keyHash, conflictHash, ttl)
+}
+
+func (c *MessageCache) Add(msg *g53.Message) {
+	keyHash, _ := HashQuery(msg.Question.Name, msg.Question.Type)
+	c.shard(keyHash).addMessage(msg, nil)
+	c.evictByMemory()
+}
+
+func (c *MessageCache) AddSubnet(msg *g53.Message, subnet *ecs.ClientSubnet) {
+	//rrsets are keyed with the subnet too, so answers for different
+	//networks don't overwrite each other
+	keyHash, _ := HashSubnetQuery(msg.Question.Name, msg.Question.Type, subnet)
+	c.shard(keyHash).addMessage(msg, subnetKey(subnet))
+	c.evictByMemory()
+}
+
+func (c *MessageCache) LookupSubnet(req *g53.Message, subnet *ecs.ClientSubnet) (*g53.Message, bool, bool) {
+	keyHash, conflictHash := HashSubnetQuery(req.Question.Name, req.Question.Type, subnet)
+	return c.shard(keyHash).lookup(req, keyHash, conflictHash)
+}
+
+func (c *MessageCache) AddNXDomain(msg *g53.Message) {
+	//nxdomain is stored regardless of the query type, so it could
+	//answer queries for any name below it as rfc8020 allows
+	keyHash, _ := HashQuery(msg.Question.Name, g53.RR_ANY)
+	c.shard(keyHash).addNXDomain(msg)
+	c.evictByMemory()
+}
+
+func (c *MessageCache) GetNXDomain(req *g53.Message) (*g53.Message, bool) {
+	name := req.Question.Name
+	for name.LabelCount() > 1 {
+		keyHash, conflictHash := HashQuery(name, g53.RR_ANY)
+		if msg, _, found := c.shard(keyHash).lookup(req, keyHash, conflictHash); found {
+			return msg, true
+		}
+		name, _ = name.Parent(1)
+	}
+	return nil, false
+}
+
+func (c *MessageCache) Messages() []*g53.Message {
+	var msgs []*g53.Message
+	for _, shard := range c.shards {
+		msgs = append(msgs, shard.messages()...)
+	}
+	return msgs
+}
+
+func (c *MessageCache) Remove(name *g53.Name, typ g53.RRType) bool {
+	keyHash, conflictHash := HashQuery(name, typ)
+	return c.shard(keyHash).removeMessage(keyHash, conflictHash)
+}
+
+type messageShard struct {
+	cap          int
+	data         map[uint64]*list.Element
+	ll           *list.List
+	mu           sync.Mutex
+	rrsetCache   *RRsetCache
+	prefetchRate uint32
+	staleWindow  time.Duration
+	ttlLimit     TtlLimit
+	view         string
+	memory       *memoryBudget
+}
+
+func newMessageShard(cap int, rrsetCache *RRsetCache) *messageShard {
+	return &messageShard{
 		ll:         list.New(),
 		data:       make(map[uint64]*list.Element),
 		cap:        cap,
-		rrsetCache: newRRsetCache(cap * RRsetVsMessageRatio),
+		rrsetCache: rrsetCache,
 	}
 }
 
-func (c *MessageCache) Len() int {
+func (c *messageShard) size() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	return len(c.data)
 }
 
-func (c *MessageCache) Get(req *g53.Message) (*g53.Message, bool) {
+func (c *messageShard) setPrefetchRate(rate uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetchRate = rate
+}
+
+func (c *messageShard) setStaleWindow(window time.Duration) {
 	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleWindow = window
+}
 
-	keyHash, conflictHash := HashQuery(req.Question.Name, req.Question.Type)
+func (c *messageShard) setTtlLimit(limit TtlLimit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttlLimit = limit
+}
+
+func (c *messageShard) lookup(req *g53.Message, keyHash, conflictHash uint64) (*g53.Message, bool, bool) {
+	c.mu.Lock()
 	me, found := c.get(keyHash, conflictHash)
 	if !found {
 		c.mu.Unlock()
-		return nil, false
+		return nil, false, false
 	}
 
 	var rrsets []*g53.RRset
@@ -74,15 +301,64 @@ func (c *MessageCache) Get(req *g53.Message) (*g53.Message, bool) {
 		for i, hash := range me.rrsets {
 			rrset, found := c.rrsetCache.get(hash.keyHash, hash.conflictHash)
 			if !found {
-				c.remove(keyHash, conflictHash)
+				//keep the entry which may still be served as stale,
+				//unless its rrset is evicted
+				if c.staleWindow == 0 || c.hasStaleRRset(hash) == false {
+					c.remove(keyHash, conflictHash)
+				}
 				c.mu.Unlock()
-				return nil, false
+				return nil, false, false
 			}
 			rrsets[i] = rrset
 		}
 	}
+	me.hits += 1
+	prefetch := me.needPrefetch(c.prefetchRate)
+	if prefetch {
+		me.prefetching = true
+	}
 	c.mu.Unlock()
+	return makeResponse(req, me, rrsets), prefetch, true
+}
 
+func (c *messageShard) getStale(req *g53.Message, keyHash, conflictHash uint64, ttl g53.RRTTL) (*g53.Message, bool) {
+	c.mu.Lock()
+	if c.staleWindow == 0 {
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	elem, hit := c.data[keyHash]
+	if !hit {
+		c.mu.Unlock()
+		return nil, false
+	}
+	me := elem.Value.(*MessageEntry)
+	if me.conflictHash != conflictHash || me.expireTime.Add(c.staleWindow).Before(time.Now()) {
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	rrsets := make([]*g53.RRset, len(me.rrsets))
+	for i, hash := range me.rrsets {
+		rrset, found := c.rrsetCache.getStale(hash.keyHash, hash.conflictHash, c.staleWindow, ttl)
+		if !found {
+			c.remove(keyHash, conflictHash)
+			c.mu.Unlock()
+			return nil, false
+		}
+		rrsets[i] = rrset
+	}
+	c.mu.Unlock()
+	return makeResponse(req, me, rrsets), true
+}
+
+func (c *messageShard) hasStaleRRset(hash RRsetHash) bool {
+	_, found := c.rrsetCache.getStale(hash.keyHash, hash.conflictHash, c.staleWindow, 0)
+	return found
+}
+
+func makeResponse(req *g53.Message, me *MessageEntry, rrsets []*g53.RRset) *g53.Message {
 	resp := req.MakeResponse()
 	j := 0
 	for i := uint16(0); i < me.answerCount; i++ {
@@ -98,11 +374,38 @@ func (c *MessageCache) Get(req *g53.Message) (*g53.Message, bool) {
 		j++
 	}
 	resp.Header.Rcode = me.rcode
+	resp.Header.SetFlag(g53.FLAG_AD, me.secure)
 	resp.RecalculateSectionRRCount()
-	return resp, true
+	return resp
+}
+
+func (c *messageShard) messages() []*g53.Message {
+	//messages which aren't expired, from the least recently used one
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := make([]*g53.Message, 0, c.ll.Len())
+	for elem := c.ll.Back(); elem != nil; elem = elem.Prev() {
+		me := elem.Value.(*MessageEntry)
+		if me.IsExpire() {
+			continue
+		}
+
+		rrsets := make([]*g53.RRset, 0, len(me.rrsets))
+		for _, hash := range me.rrsets {
+			if rrset, found := c.rrsetCache.get(hash.keyHash, hash.conflictHash); found {
+				rrsets = append(rrsets, rrset)
+			}
+		}
+		if len(rrsets) == len(me.rrsets) {
+			req := g53.MakeQuery(me.name, me.typ, 0, false)
+			msgs = append(msgs, makeResponse(req, me, rrsets))
+		}
+	}
+	return msgs
 }
 
-func (c *MessageCache) get(keyHash, conflictHash uint64) (*MessageEntry, bool) {
+func (c *messageShard) get(keyHash, conflictHash uint64) (*MessageEntry, bool) {
 	if elem, hit := c.data[keyHash]; hit {
 		e := elem.Value.(*MessageEntry)
 		if e.conflictHash == conflictHash && !e.IsExpire() {
@@ -113,27 +416,42 @@ func (c *MessageCache) get(keyHash, conflictHash uint64) (*MessageEntry, bool) {
 	return nil, false
 }
 
-func (c *MessageCache) Add(msg *g53.Message) {
+func (c *messageShard) addMessage(msg *g53.Message, subnet []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if msg.Header.ANCount == 0 {
-		e, res := negativeMessageToEntry(msg)
+		if e, res, ok := negativeMessageToEntry(msg, c.ttlLimit, subnet); ok {
+			c.add(e)
+			c.rrsetCache.add(res)
+		}
+	} else {
+		e, res := positiveMsgToEntry(msg, c.ttlLimit, subnet)
 		c.add(e)
 		c.rrsetCache.add(res)
-	} else {
-		e, res := positiveMsgToEntry(msg)
+	}
+}
+
+func (c *messageShard) addNXDomain(msg *g53.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, res, ok := negativeMessageToEntry(msg, c.ttlLimit, nil); ok {
+		e.keyHash, e.conflictHash = HashQuery(msg.Question.Name, g53.RR_ANY)
 		c.add(e)
 		c.rrsetCache.add(res)
 	}
 }
 
-func positiveMsgToEntry(msg *g53.Message) (MessageEntry, []RRsetEntry) {
-	keyHash, conflictHash := HashQuery(msg.Question.Name, msg.Question.Type)
+func positiveMsgToEntry(msg *g53.Message, limit TtlLimit, subnet []byte) (MessageEntry, []RRsetEntry) {
+	keyHash, conflictHash := hashQuery(msg.Question.Name, msg.Question.Type, subnet)
 	me := MessageEntry{
+		name:         msg.Question.Name,
+		typ:          msg.Question.Type,
 		keyHash:      keyHash,
 		conflictHash: conflictHash,
 		rcode:        msg.Header.Rcode,
+		secure:       msg.Header.GetFlag(g53.FLAG_AD),
 	}
 
 	rrCount := msg.Header.ANCount + msg.Header.NSCount + msg.Header.ARCount
@@ -150,14 +468,15 @@ func positiveMsgToEntry(msg *g53.Message) (MessageEntry, []RRsetEntry) {
 				me.additionalCount += 1
 			}
 
-			keyHash, conflictHash := HashQuery(rrset.Name, rrset.Type)
+			keyHash, conflictHash := hashQuery(rrset.Name, rrset.Type, subnet)
 			rrsets = append(rrsets, RRsetHash{
 				keyHash:      keyHash,
 				conflictHash: conflictHash,
 			})
 
-			if msgTtl > rrset.Ttl {
-				msgTtl = rrset.Ttl
+			ttl := g53.RRTTL(limit.clamp(uint32(rrset.Ttl), limit.MaxPositive))
+			if msgTtl > ttl {
+				msgTtl = ttl
 			}
 
 			rrsetEntries = append(rrsetEntries, RRsetEntry{
@@ -165,33 +484,50 @@ func positiveMsgToEntry(msg *g53.Message) (MessageEntry, []RRsetEntry) {
 				conflictHash: conflictHash,
 				rrset:        rrset,
 				trustLevel:   getRRsetTrustLevel(msg, sec),
-				expireTime:   time.Now().Add(time.Second * time.Duration(rrset.Ttl)),
+				expireTime:   time.Now().Add(time.Second * time.Duration(ttl)),
 			})
 		}
 	}
 	me.rrsets = rrsets
-	me.expireTime = time.Now().Add(time.Second * time.Duration(msgTtl))
+	me.ttl = time.Second * time.Duration(msgTtl)
+	me.expireTime = time.Now().Add(me.ttl)
 	return me, rrsetEntries
 }
 
-func negativeMessageToEntry(msg *g53.Message) (MessageEntry, []RRsetEntry) {
-	keyHash, conflictHash := HashQuery(msg.Question.Name, msg.Question.Type)
+func negativeMessageToEntry(msg *g53.Message, limit TtlLimit, subnet []byte) (MessageEntry, []RRsetEntry, bool) {
+	//rfc2308, negative answer without soa isn't cached, the negative ttl
+	//is the minimum of soa ttl and soa minimum field
+	auths := msg.GetSection(g53.AuthSection)
+	negativeTtl, hasSOA := uint32(0), false
+	for _, rrset := range auths {
+		if rrset.Type == g53.RR_SOA && len(rrset.Rdatas) == 1 {
+			negativeTtl, hasSOA = rrset.Rdatas[0].(*g53.SOA).Minimum, true
+			if negativeTtl > uint32(rrset.Ttl) {
+				negativeTtl = uint32(rrset.Ttl)
+			}
+			break
+		}
+	}
+	if hasSOA == false {
+		return MessageEntry{}, nil, false
+	}
+
+	keyHash, conflictHash := hashQuery(msg.Question.Name, msg.Question.Type, subnet)
 	me := MessageEntry{
-		keyHash:         keyHash,
-		conflictHash:    conflictHash,
-		rcode:           msg.Header.Rcode,
-		answerCount:     0,
-		additionalCount: 0,
+		name:           msg.Question.Name,
+		typ:            msg.Question.Type,
+		keyHash:        keyHash,
+		conflictHash:   conflictHash,
+		rcode:          msg.Header.Rcode,
+		secure:         msg.Header.GetFlag(g53.FLAG_AD),
+		authorityCount: uint16(len(auths)),
 	}
 
-	msgTtl := uint32(math.MaxUint32)
-	auths := msg.GetSection(g53.AuthSection)
-	rrsetEntries := make([]RRsetEntry, 0, 1)
-	rrsets := make([]RRsetHash, 0, 1)
-	if len(auths) == 1 && auths[0].Type == g53.RR_SOA && len(auths[0].Rdatas) == 1 {
-		me.authorityCount = 1
-		soa := auths[0]
-		keyHash, conflictHash := HashQuery(soa.Name, soa.Type)
+	msgTtl := limit.clamp(negativeTtl, limit.MaxNegative)
+	rrsetEntries := make([]RRsetEntry, 0, len(auths))
+	rrsets := make([]RRsetHash, 0, len(auths))
+	for _, rrset := range auths {
+		keyHash, conflictHash := hashQuery(rrset.Name, rrset.Type, subnet)
 		rrsets = append(rrsets, RRsetHash{
 			keyHash:      keyHash,
 			conflictHash: conflictHash,
@@ -200,22 +536,15 @@ func negativeMessageToEntry(msg *g53.Message) (MessageEntry, []RRsetEntry) {
 		rrsetEntries = append(rrsetEntries, RRsetEntry{
 			keyHash:      keyHash,
 			conflictHash: conflictHash,
-			rrset:        soa,
+			rrset:        rrset,
 			trustLevel:   getRRsetTrustLevel(msg, g53.AuthSection),
-			expireTime:   time.Now().Add(time.Second * time.Duration(soa.Ttl)),
+			expireTime:   time.Now().Add(time.Second * time.Duration(msgTtl)),
 		})
-
-		rdata, _ := soa.Rdatas[0].(*g53.SOA)
-		if msgTtl > rdata.Minimum {
-			msgTtl = rdata.Minimum
-		}
-		if msgTtl > uint32(soa.Ttl) {
-			msgTtl = uint32(soa.Ttl)
-		}
 	}
 	me.rrsets = rrsets
-	me.expireTime = time.Now().Add(time.Second * time.Duration(msgTtl))
-	return me, rrsetEntries
+	me.ttl = time.Second * time.Duration(msgTtl)
+	me.expireTime = time.Now().Add(me.ttl)
+	return me, rrsetEntries, true
 }
 
 func getRRsetTrustLevel(msg *g53.Message, sec g53.SectionType) TrustLevel {
@@ -244,25 +573,30 @@ func getRRsetTrustLevel(msg *g53.Message, sec g53.SectionType) TrustLevel {
 	}
 }
 
-func (c *MessageCache) add(e MessageEntry) {
+func (c *messageShard) add(e MessageEntry) {
+	e.size = messageEntryOverhead + int64(len(e.rrsets))*rrsetHashSize
 	if elem, ok := c.data[e.keyHash]; ok {
+		c.memory.add(e.size - elem.Value.(*MessageEntry).size)
 		c.ll.MoveToFront(elem)
 		elem.Value = &e
 	} else if c.ll.Len() < c.cap {
+		c.memory.add(e.size)
 		elem := c.ll.PushFront(&e)
 		c.data[e.keyHash] = elem
 	} else {
 		//reuse last elem
 		elem := c.ll.Back()
 		oe := elem.Value.(*MessageEntry)
+		c.memory.add(e.size - oe.size)
 		delete(c.data, oe.keyHash)
 		*oe = e
 		c.data[e.keyHash] = elem
 		c.ll.MoveToFront(elem)
+		metrics.RecordCacheEviction(c.view, metrics.EvictBySize)
 	}
 }
 
-func (c *MessageCache) ResetCapacity(cap int) {
+func (c *messageShard) resetCapacity(cap int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -275,24 +609,38 @@ func (c *MessageCache) ResetCapacity(cap int) {
 		elem := c.ll.Back()
 		oe := elem.Value.(*MessageEntry)
 		c.remove(oe.keyHash, oe.conflictHash)
+		metrics.RecordCacheEviction(c.view, metrics.EvictBySize)
 		rc -= 1
 	}
 
 	c.cap = cap
-	c.rrsetCache.cap = RRsetVsMessageRatio * cap
 }
 
-func (c *MessageCache) Remove(name *g53.Name, typ g53.RRType) bool {
-	keyHash, conflictHash := HashQuery(name, typ)
+func (c *messageShard) evictOldest() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem := c.ll.Back()
+	if elem == nil {
+		return false
+	}
+	oe := elem.Value.(*MessageEntry)
+	c.remove(oe.keyHash, oe.conflictHash)
+	metrics.RecordCacheEviction(c.view, metrics.EvictByMemory)
+	return true
+}
+
+func (c *messageShard) removeMessage(keyHash, conflictHash uint64) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.remove(keyHash, conflictHash)
 }
 
-func (c *MessageCache) remove(keyHash, conflictHash uint64) bool {
+func (c *messageShard) remove(keyHash, conflictHash uint64) bool {
 	if elem, hit := c.data[keyHash]; hit {
 		e := elem.Value.(*MessageEntry)
 		if e.conflictHash == conflictHash {
+			c.memory.add(-e.size)
 			delete(c.data, keyHash)
 			c.ll.Remove(elem)
 			return true
@@ -1,11 +1,14 @@
 package cache
 
 import (
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	ut "github.com/ben-han-cn/cement/unittest"
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
 	"github.com/ben-han-cn/vanguard/logger"
 )
 
@@ -101,3 +104,282 @@ func TestMessageCache(t *testing.T) {
 	ut.Assert(t, found == false, "message should be cleaned")
 	ut.Equal(t, cache.Len(), 2)
 }
+
+func TestMessageCachePrefetch(t *testing.T) {
+	cache := newMessageCache(3)
+	qname, _ := g53.NameFromString("test.example.com.")
+	request := g53.MakeQuery(qname, g53.RR_A, 512, false)
+
+	cache.Add(buildMessage("test.example.com.", []string{"1.1.1.1"}, 30))
+	_, prefetch, found := cache.Lookup(request)
+	ut.Assert(t, found && prefetch == false, "prefetch is disabled")
+
+	cache.SetPrefetchRate(10)
+	_, prefetch, _ = cache.Lookup(request)
+	ut.Assert(t, prefetch == false, "entry far from expire shouldn't be prefetched")
+
+	cache.SetPrefetchRate(100)
+	_, prefetch, _ = cache.Lookup(request)
+	ut.Assert(t, prefetch, "popular entry should be prefetched")
+	_, prefetch, _ = cache.Lookup(request)
+	ut.Assert(t, prefetch == false, "entry is prefetched only once")
+
+	cache.Add(buildMessage("test.example.com.", []string{"1.1.1.1"}, 30))
+	_, prefetch, _ = cache.Lookup(request)
+	ut.Assert(t, prefetch == false, "refreshed entry isn't popular yet")
+}
+
+func buildNegativeMessage(name string, rcode g53.Rcode, soa string) *g53.Message {
+	msg := g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false).MakeResponse()
+	msg.Header.Rcode = rcode
+	if soa != "" {
+		rrset, _ := g53.RRsetFromString(soa)
+		msg.AddRRset(g53.AuthSection, rrset)
+	}
+	msg.RecalculateSectionRRCount()
+	return msg
+}
+
+func TestMessageCacheTtlLimit(t *testing.T) {
+	cache := newMessageCache(10)
+	cache.SetTtlLimit(TtlLimit{Min: 60, MaxPositive: 100, MaxNegative: 30})
+	query := func(name string) *g53.Message {
+		msg, _ := cache.Get(g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false))
+		return msg
+	}
+
+	cache.Add(buildMessage("long.example.com.", []string{"1.1.1.1"}, 3600))
+	ut.Assert(t, query("long.example.com.").Sections[g53.AnswerSection][0].Ttl <= 100, "ttl should be capped")
+	cache.Add(buildMessage("short.example.com.", []string{"1.1.1.1"}, 1))
+	ut.Assert(t, query("short.example.com.").Sections[g53.AnswerSection][0].Ttl > 50, "ttl should be raised to min ttl")
+
+	cache.SetTtlLimit(TtlLimit{MaxNegative: 30})
+	cache.Add(buildNegativeMessage("nx.example.com.", g53.R_NXDOMAIN, "example.com. 3600 IN SOA ns.example.com. root.example.com. 1 3600 600 86400 20"))
+	soa := query("nx.example.com.").Sections[g53.AuthSection][0]
+	ut.Assert(t, soa.Ttl <= 20 && soa.Ttl >= 19, "negative ttl should be the soa minimum")
+
+	cache.Add(buildNegativeMessage("nx1.example.com.", g53.R_NXDOMAIN, "example.com. 3600 IN SOA ns.example.com. root.example.com. 1 3600 600 86400 3600"))
+	ut.Assert(t, query("nx1.example.com.").Sections[g53.AuthSection][0].Ttl <= 30, "negative ttl should be capped")
+
+	cache.Add(buildNegativeMessage("nosoa.example.com.", g53.R_SERVFAIL, ""))
+	ut.Assert(t, query("nosoa.example.com.") == nil, "negative answer without soa shouldn't be cached")
+}
+
+func TestViewCacheNXDomainCut(t *testing.T) {
+	cache := newViewCache("default", 10)
+	cache.Add(buildNegativeMessage("nx.example.com.", g53.R_NXDOMAIN, "example.com. 3600 IN SOA ns.example.com. root.example.com. 1 3600 600 86400 300"))
+
+	req := g53.MakeQuery(g53.NameFromStringUnsafe("a.b.nx.example.com."), g53.RR_AAAA, 512, false)
+	msg, _, found := cache.Get(req, nil)
+	ut.Assert(t, found, "name below nxdomain should be answered")
+	ut.Equal(t, msg.Header.Rcode, g53.R_NXDOMAIN)
+	ut.Assert(t, msg.Question.Name.Equals(req.Question.Name), "question should be the query")
+
+	_, _, found = cache.Get(g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 512, false), nil)
+	ut.Assert(t, found == false, "sibling of nxdomain isn't affected")
+
+	cache.Remove(g53.NameFromStringUnsafe("nx.example.com."), g53.RR_A)
+	_, _, found = cache.Get(req, nil)
+	ut.Assert(t, found == false, "nxdomain cut should be removed")
+}
+
+func TestViewCacheMemoryLimit(t *testing.T) {
+	cache := newViewCache("default", 100)
+	cache.SetMemoryLimit(1000)
+	for i := 0; i < 20; i++ {
+		cache.Add(buildMessage(fmt.Sprintf("test%d.example.com.", i), []string{"1.1.1.1"}, 300))
+	}
+	ut.Assert(t, cache.MemoryUsage() <= 1000, "memory usage %d exceeds the limit", cache.MemoryUsage())
+
+	get := func(name string) bool {
+		_, _, found := cache.Get(g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false), nil)
+		return found
+	}
+	ut.Assert(t, get("test19.example.com."), "latest message should be kept")
+	ut.Assert(t, get("test0.example.com.") == false, "oldest message should be evicted")
+
+	cache.SetMemoryLimit(0)
+	for i := 0; i < 20; i++ {
+		cache.Add(buildMessage(fmt.Sprintf("test%d.example.com.", i), []string{"1.1.1.1"}, 300))
+	}
+	ut.Assert(t, get("test0.example.com."), "no memory limit")
+}
+
+func TestShardedMessageCache(t *testing.T) {
+	ut.Equal(t, len(newMessageCache(3).shards), 1)
+	ut.Equal(t, len(newMessageCache(1000000).shards), MaxShardCount)
+
+	cache := newShardedMessageCache(64, 8)
+	for i := 0; i < 100; i++ {
+		cache.Add(buildMessage(fmt.Sprintf("test%d.example.com.", i), []string{"1.1.1.1"}, 300))
+	}
+	ut.Assert(t, cache.Len() <= 64, "cache size %d exceeds the capacity", cache.Len())
+	msg, found := cache.Get(g53.MakeQuery(g53.NameFromStringUnsafe("test99.example.com."), g53.RR_A, 512, false))
+	ut.Assert(t, found, "latest message should be found")
+	ut.Equal(t, msg.Question.Name.String(false), "test99.example.com.")
+
+	cache.ResetCapacity(16)
+	ut.Assert(t, cache.Len() <= 16, "cache should be shrinked")
+}
+
+func TestShardedRRsetTrustLevel(t *testing.T) {
+	cache := newShardedMessageCache(64, 8)
+	www := buildMessage("www.example.com.", []string{"1.1.1.1"}, 300)
+	cache.Add(www)
+
+	//authoritative answer cached by message in another shard
+	//replaces the rrset with lower trust level
+	wwwHash, _ := HashQuery(www.Question.Name, g53.RR_A)
+	var other *g53.Message
+	for i := 0; other == nil; i++ {
+		name := fmt.Sprintf("test%d.example.com.", i)
+		if hash, _ := HashQuery(g53.NameFromStringUnsafe(name), g53.RR_A); cache.shard(hash) != cache.shard(wwwHash) {
+			other = buildMessage(name, []string{"3.3.3.3"}, 300)
+		}
+	}
+	newWWW := buildMessage("www.example.com.", []string{"2.2.2.2"}, 300).Sections[g53.AnswerSection][0]
+	other.Sections[g53.AnswerSection] = append(other.Sections[g53.AnswerSection], newWWW)
+	other.Header.SetFlag(g53.FLAG_AA, true)
+	other.RecalculateSectionRRCount()
+	cache.Add(other)
+
+	msg, found := cache.Get(g53.MakeQuery(www.Question.Name, g53.RR_A, 512, false))
+	ut.Assert(t, found, "message should be found")
+	ut.Equal(t, msg.Sections[g53.AnswerSection][0].Rdatas[0].String(), "2.2.2.2")
+}
+
+func TestMessageCacheMemoryEviction(t *testing.T) {
+	cache := newShardedMessageCache(1024, 8)
+	memory := &memoryBudget{}
+	cache.setView("default", memory)
+	msg := buildMessage("test0.example.com.", []string{"1.1.1.1"}, 300)
+	cache.Add(msg)
+	rrsetSize := cache.rrsetCache.shards[0].rrsetSize(msg.Sections[g53.AnswerSection][0])
+	ut.Equal(t, memory.used, messageEntryOverhead+rrsetHashSize+rrsetSize)
+
+	memory.limit = 4000
+	for i := 0; i < 100; i++ {
+		cache.Add(buildMessage(fmt.Sprintf("test%d.example.com.", i), []string{"1.1.1.1"}, 300))
+	}
+	ut.Assert(t, memory.used <= 4000, "memory usage %d exceeds the limit", memory.used)
+	ut.Assert(t, cache.Len() > 0, "cache shouldn't be emptied")
+
+	cache.SetStaleWindow(time.Hour)
+	req := g53.MakeQuery(g53.NameFromStringUnsafe("test99.example.com."), g53.RR_A, 512, false)
+	_, found := cache.Get(req)
+	ut.Assert(t, found, "latest message should be found")
+	size := cache.Len()
+	keyHash, conflictHash := HashQuery(req.Question.Name, g53.RR_A)
+	cache.rrsetCache.remove(keyHash, conflictHash)
+	_, found = cache.Get(req)
+	ut.Assert(t, found == false, "message without rrset shouldn't be found")
+	_, found = cache.GetStale(req, 30)
+	ut.Assert(t, found == false, "message without rrset shouldn't be served as stale")
+	ut.Equal(t, cache.Len(), size-1)
+}
+
+const benchNameCount = 10000
+
+func benchmarkMessageCacheGet(b *testing.B, cache *MessageCache) {
+	reqs := make([]*g53.Message, benchNameCount)
+	for i := range reqs {
+		name := fmt.Sprintf("test%d.example.com.", i)
+		cache.Add(buildMessage(name, []string{"1.1.1.1"}, 3600))
+		reqs[i] = g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 512, false)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(reqs[i%benchNameCount])
+			i++
+		}
+	})
+}
+
+func benchmarkMessageCacheAdd(b *testing.B, cache *MessageCache) {
+	msgs := make([]*g53.Message, benchNameCount)
+	for i := range msgs {
+		msgs[i] = buildMessage(fmt.Sprintf("test%d.example.com.", i), []string{"1.1.1.1"}, 3600)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Add(msgs[i%benchNameCount])
+			i++
+		}
+	})
+}
+
+func BenchmarkMessageCacheGet(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkMessageCacheGet(b, newShardedMessageCache(benchNameCount, 1)) })
+	b.Run("sharded", func(b *testing.B) { benchmarkMessageCacheGet(b, newShardedMessageCache(benchNameCount, MaxShardCount)) })
+}
+
+func BenchmarkMessageCacheAdd(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkMessageCacheAdd(b, newShardedMessageCache(benchNameCount, 1)) })
+	b.Run("sharded", func(b *testing.B) { benchmarkMessageCacheAdd(b, newShardedMessageCache(benchNameCount, MaxShardCount)) })
+}
+
+func TestViewCacheSubnet(t *testing.T) {
+	cache := newViewCache("default", 10)
+	tailored := buildMessage("www.example.com.", []string{"1.1.1.1"}, 300)
+	subnet := ecs.NewClientSubnet(net.ParseIP("10.1.2.3"), 24)
+	subnet.ScopePrefix = 24
+	tailored.Edns = &g53.EDNS{UdpSize: 4096, Options: []g53.Option{subnet}}
+	cache.Add(tailored)
+
+	query := func(ip string, clientSubnet *ecs.ClientSubnet) *g53.Message {
+		req := g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, false)
+		if clientSubnet != nil {
+			ecs.SetEdns(req.Edns, clientSubnet)
+		}
+		msg, _, _ := cache.Get(req, net.ParseIP(ip))
+		return msg
+	}
+	ut.Assert(t, query("10.1.2.100", nil) != nil, "client in the scope should hit")
+	ut.Assert(t, query("10.1.3.100", nil) == nil, "client out of the scope should miss")
+
+	global := buildMessage("www.example.com.", []string{"2.2.2.2"}, 300)
+	cache.Add(global)
+	msg := query("10.1.3.100", nil)
+	ut.Equal(t, msg.Sections[g53.AnswerSection][0].Rdatas[0].String(), "2.2.2.2")
+	msg = query("10.1.2.100", nil)
+	ut.Equal(t, msg.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+
+	msg = query("127.0.0.1", ecs.NewClientSubnet(net.ParseIP("10.1.2.100"), 32))
+	ut.Equal(t, msg.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+	echo := ecs.FromEdns(msg.Edns)
+	ut.Equal(t, echo.SourcePrefix, uint8(32))
+	ut.Equal(t, echo.ScopePrefix, uint8(24))
+
+	msg = query("127.0.0.1", ecs.NewClientSubnet(net.ParseIP("10.1.2.100"), 16))
+	ut.Equal(t, msg.Sections[g53.AnswerSection][0].Rdatas[0].String(), "2.2.2.2")
+	ut.Equal(t, ecs.FromEdns(msg.Edns).ScopePrefix, uint8(0))
+
+	cache.Remove(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A)
+	ut.Assert(t, query("10.1.2.100", nil) == nil, "subnet answer should be removed")
+}
+
+func TestSubnetScopesEviction(t *testing.T) {
+	scopes := newSubnetScopes(2)
+	names := []*g53.Name{
+		g53.NameFromStringUnsafe("a.example.com."),
+		g53.NameFromStringUnsafe("b.example.com."),
+		g53.NameFromStringUnsafe("c.example.com."),
+	}
+	scopes.add(names[0], g53.RR_A, ecs.FamilyIPv4, 24)
+	scopes.add(names[1], g53.RR_A, ecs.FamilyIPv4, 24)
+	scopes.add(names[0], g53.RR_A, ecs.FamilyIPv4, 16)
+	scopes.add(names[2], g53.RR_A, ecs.FamilyIPv4, 24)
+	ut.Equal(t, scopes.get(names[0], g53.RR_A, ecs.FamilyIPv4), []uint8{24, 16})
+	ut.Assert(t, scopes.get(names[1], g53.RR_A, ecs.FamilyIPv4) == nil, "least recently added scopes should be evicted")
+	ut.Equal(t, scopes.get(names[2], g53.RR_A, ecs.FamilyIPv4), []uint8{24})
+
+	scopes.resetCapacity(1)
+	ut.Equal(t, scopes.get(names[2], g53.RR_A, ecs.FamilyIPv4), []uint8{24})
+	ut.Assert(t, scopes.get(names[0], g53.RR_A, ecs.FamilyIPv4) == nil, "scopes should be shrinked")
+}
@@ -2,9 +2,13 @@ package cache
 
 import (
 	"container/list"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/metrics"
 )
 
 type TrustLevel int
@@ -18,57 +22,169 @@ const (
 	AnswerWithAA        TrustLevel = 5
 )
 
+const rrsetEntryOverhead = 128
+
 type RRsetEntry struct {
 	keyHash      uint64
 	conflictHash uint64
 	rrset        *g53.RRset
 	trustLevel   TrustLevel
 	expireTime   time.Time
+	size         int64
 }
 
 func (e *RRsetEntry) IsExpire() bool {
 	return e.expireTime.Before(time.Now())
 }
 
+type memoryBudget struct {
+	//shared by the message and rrset caches of one view
+	used  int64
+	limit int64
+}
+
+func (b *memoryBudget) add(size int64) {
+	atomic.AddInt64(&b.used, size)
+}
+
+func (b *memoryBudget) exceeded() bool {
+	limit := atomic.LoadInt64(&b.limit)
+	return limit > 0 && atomic.LoadInt64(&b.used) > limit
+}
+
 type RRsetCache struct {
-	cap  int
-	data map[uint64]*list.Element
-	ll   *list.List
+	//rrsets are sharded by their own key, so the same rrset from
+	//different messages always meets in one shard
+	shards []*rrsetShard
+}
+
+func newRRsetCache(cap int, shardCount int) *RRsetCache {
+	c := &RRsetCache{
+		shards: make([]*rrsetShard, shardCount),
+	}
+	for i := range c.shards {
+		c.shards[i] = newRRsetShard(shardCapacity(cap, shardCount))
+	}
+	return c
+}
+
+func (c *RRsetCache) shard(keyHash uint64) *rrsetShard {
+	return c.shards[keyHash%uint64(len(c.shards))]
 }
 
-func newRRsetCache(cap int) *RRsetCache {
-	return &RRsetCache{
-		ll:   list.New(),
-		data: make(map[uint64]*list.Element),
-		cap:  cap,
+func (c *RRsetCache) setView(view string, memory *memoryBudget) {
+	for _, shard := range c.shards {
+		shard.view = view
+		shard.memory = memory
+	}
+}
+
+func (c *RRsetCache) resetCapacity(cap int) {
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.cap = shardCapacity(cap, len(c.shards))
+		shard.mu.Unlock()
 	}
 }
 
 func (c *RRsetCache) add(es []RRsetEntry) {
-	for i, e := range es {
-		if elem, ok := c.data[e.keyHash]; ok {
-			oe := elem.Value.(*RRsetEntry)
-			if !oe.IsExpire() && e.trustLevel < oe.trustLevel {
-				return
-			}
-			c.ll.MoveToFront(elem)
-			elem.Value = &es[i]
-		} else if c.ll.Len() < c.cap {
-			elem := c.ll.PushFront(&es[i])
-			c.data[e.keyHash] = elem
-		} else {
-			//reuse last elem
-			elem := c.ll.Back()
-			oe := elem.Value.(*RRsetEntry)
-			delete(c.data, oe.keyHash)
-			*oe = e
-			c.data[e.keyHash] = elem
-			c.ll.MoveToFront(elem)
-		}
+	for i := range es {
+		shard := c.shard(es[i].keyHash)
+		shard.mu.Lock()
+		shard.add(&es[i])
+		shard.mu.Unlock()
 	}
 }
 
 func (c *RRsetCache) get(keyHash, conflictHash uint64) (*g53.RRset, bool) {
+	shard := c.shard(keyHash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.get(keyHash, conflictHash)
+}
+
+func (c *RRsetCache) getStale(keyHash, conflictHash uint64, window time.Duration, ttl g53.RRTTL) (*g53.RRset, bool) {
+	shard := c.shard(keyHash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.getStale(keyHash, conflictHash, window, ttl)
+}
+
+func (c *RRsetCache) remove(keyHash, conflictHash uint64) bool {
+	shard := c.shard(keyHash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.remove(keyHash, conflictHash)
+}
+
+type rrsetShard struct {
+	cap    int
+	data   map[uint64]*list.Element
+	ll     *list.List
+	mu     sync.Mutex
+	view   string
+	memory *memoryBudget
+	buf    *util.OutputBuffer
+}
+
+func newRRsetShard(cap int) *rrsetShard {
+	return &rrsetShard{
+		ll:     list.New(),
+		data:   make(map[uint64]*list.Element),
+		cap:    cap,
+		memory: &memoryBudget{},
+		buf:    util.NewOutputBuffer(512),
+	}
+}
+
+func (c *rrsetShard) rrsetSize(rrset *g53.RRset) int64 {
+	c.buf.Clear()
+	rrset.ToWire(c.buf)
+	return int64(c.buf.Len()) + rrsetEntryOverhead
+}
+
+func (c *rrsetShard) add(e *RRsetEntry) {
+	e.size = c.rrsetSize(e.rrset)
+	if elem, ok := c.data[e.keyHash]; ok {
+		oe := elem.Value.(*RRsetEntry)
+		if !oe.IsExpire() && e.trustLevel < oe.trustLevel {
+			return
+		}
+		c.memory.add(e.size - oe.size)
+		c.ll.MoveToFront(elem)
+		elem.Value = e
+	} else if c.ll.Len() < c.cap {
+		c.memory.add(e.size)
+		elem := c.ll.PushFront(e)
+		c.data[e.keyHash] = elem
+	} else {
+		//reuse last elem
+		elem := c.ll.Back()
+		oe := elem.Value.(*RRsetEntry)
+		c.memory.add(e.size - oe.size)
+		delete(c.data, oe.keyHash)
+		*oe = *e
+		c.data[e.keyHash] = elem
+		c.ll.MoveToFront(elem)
+		metrics.RecordCacheEviction(c.view, metrics.EvictBySize)
+	}
+}
+
+func (c *rrsetShard) evictOldest() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem := c.ll.Back()
+	if elem == nil {
+		return false
+	}
+	oe := elem.Value.(*RRsetEntry)
+	c.remove(oe.keyHash, oe.conflictHash)
+	metrics.RecordCacheEviction(c.view, metrics.EvictByMemory)
+	return true
+}
+
+func (c *rrsetShard) get(keyHash, conflictHash uint64) (*g53.RRset, bool) {
 	if elem, hit := c.data[keyHash]; hit {
 		e := elem.Value.(*RRsetEntry)
 		now := time.Now()
@@ -82,10 +198,23 @@ func (c *RRsetCache) get(keyHash, conflictHash uint64) (*g53.RRset, bool) {
 	return nil, false
 }
 
-func (c *RRsetCache) remove(keyHash, conflictHash uint64) bool {
+func (c *rrsetShard) getStale(keyHash, conflictHash uint64, window time.Duration, ttl g53.RRTTL) (*g53.RRset, bool) {
+	if elem, hit := c.data[keyHash]; hit {
+		e := elem.Value.(*RRsetEntry)
+		if e.conflictHash == conflictHash && e.expireTime.Add(window).After(time.Now()) {
+			rrset := *e.rrset
+			rrset.Ttl = ttl
+			return &rrset, true
+		}
+	}
+	return nil, false
+}
+
+func (c *rrsetShard) remove(keyHash, conflictHash uint64) bool {
 	if elem, hit := c.data[keyHash]; hit {
 		e := elem.Value.(*RRsetEntry)
 		if e.conflictHash == conflictHash {
+			c.memory.add(-e.size)
 			delete(c.data, keyHash)
 			c.ll.Remove(elem)
 			return true
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"github.com/ben-han-cn/g53"
+)
+
+func minimizeResponse(resp *g53.Message, dnssecAware bool) *g53.Message {
+	//negative answer and referral need authority and glue, so does
+	//the cname chain which ends without the queried type
+	answers := resp.Sections[g53.AnswerSection]
+	if len(answers) == 0 || resp.Header.Rcode != g53.R_NOERROR {
+		return resp
+	}
+	for i := len(answers) - 1; i >= 0; i-- {
+		if answers[i].Type == g53.RR_RRSIG {
+			continue
+		}
+		if answers[i].Type == g53.RR_CNAME && resp.Question.Type != g53.RR_CNAME {
+			return resp
+		}
+		break
+	}
+
+	short := *resp
+	short.Sections[g53.AuthSection] = nil
+	if dnssecAware {
+		for _, rrset := range resp.Sections[g53.AuthSection] {
+			if isDenialRRset(rrset) {
+				short.Sections[g53.AuthSection] = append(short.Sections[g53.AuthSection], rrset)
+			}
+		}
+	}
+	short.Sections[g53.AdditionalSection] = nil
+	short.RecalculateSectionRRCount()
+	return &short
+}
+
+func isDenialRRset(rrset *g53.RRset) bool {
+	//wildcard expansion is proved by nsec/nsec3 with their signatures
+	switch rrset.Type {
+	case g53.RR_NSEC, g53.RR_NSEC3, g53.RR_RRSIG:
+		return true
+	default:
+		return false
+	}
+}
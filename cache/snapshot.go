@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/dnssec"
+)
+
+type snapshotHeader struct {
+	DumpTime time.Time
+}
+
+type snapshotEntry struct {
+	View    string
+	Message []byte
+}
+
+func (c *Cache) DumpSnapshot(file string) (int, error) {
+	tmpFile := file + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := c.dumpSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return 0, err
+	}
+	return count, os.Rename(tmpFile, file)
+}
+
+func (c *Cache) dumpSnapshot(w io.Writer) (int, error) {
+	encoder := gob.NewEncoder(w)
+	if err := encoder.Encode(&snapshotHeader{DumpTime: time.Now()}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	render := g53.NewMsgRender()
+	for view, viewCache := range c.cache {
+		for _, msg := range viewCache.Messages() {
+			msg.Rend(render)
+			data := make([]byte, render.Len())
+			copy(data, render.Data())
+			render.Clear()
+			if err := encoder.Encode(&snapshotEntry{View: view, Message: data}); err != nil {
+				return count, err
+			}
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+func (c *Cache) LoadSnapshot(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.loadSnapshot(f)
+}
+
+func (c *Cache) loadSnapshot(r io.Reader) (int, error) {
+	decoder := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, err
+	}
+	elapsed := g53.RRTTL(time.Since(header.DumpTime).Seconds())
+
+	count := 0
+	for {
+		var entry snapshotEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+
+		viewCache, ok := c.cache[entry.View]
+		if ok == false {
+			continue
+		}
+		msg, err := dnssec.MessageFromWire(util.NewInputBuffer(entry.Message))
+		if err != nil {
+			return count, err
+		}
+		if reduceTtl(msg, elapsed) {
+			viewCache.Add(msg)
+			count += 1
+		}
+	}
+}
+
+func reduceTtl(msg *g53.Message, elapsed g53.RRTTL) bool {
+	for _, section := range msg.Sections {
+		for _, rrset := range section {
+			if rrset.Ttl <= elapsed {
+				return false
+			}
+			rrset.Ttl -= elapsed
+		}
+	}
+	return true
+}
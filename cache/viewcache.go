@@ -1,48 +1,275 @@
 package cache
 
 import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
 )
 
 type ViewCache struct {
 	positiveCache *MessageCache
 	negativeCache *MessageCache
+	nxdomainCache *MessageCache
+	subnetCache   *MessageCache
+	scopes        *subnetScopes
+	memory        *memoryBudget
+	shortAnswer   bool
 }
 
-func newViewCache(cap int) *ViewCache {
-	return &ViewCache{
+func newViewCache(view string, cap int) *ViewCache {
+	c := &ViewCache{
 		positiveCache: newMessageCache(cap),
 		negativeCache: newMessageCache(cap),
+		nxdomainCache: newMessageCache(cap),
+		subnetCache:   newMessageCache(cap),
+		scopes:        newSubnetScopes(cap),
+		memory:        &memoryBudget{},
 	}
+	c.positiveCache.setView(view, c.memory)
+	c.negativeCache.setView(view, c.memory)
+	c.nxdomainCache.setView(view, c.memory)
+	c.subnetCache.setView(view, c.memory)
+	return c
+}
+
+func (c *ViewCache) SetMemoryLimit(limit uint64) {
+	atomic.StoreInt64(&c.memory.limit, int64(limit))
+}
+
+func (c *ViewCache) SetShortAnswer(enable bool) {
+	c.shortAnswer = enable
+}
+
+func (c *ViewCache) MemoryUsage() int64 {
+	return atomic.LoadInt64(&c.memory.used)
+}
+
+func (c *ViewCache) Len() int {
+	return c.positiveCache.Len() + c.negativeCache.Len() + c.nxdomainCache.Len() + c.subnetCache.Len()
 }
 
 func (c *ViewCache) ResetCapacity(cap int) {
 	c.positiveCache.ResetCapacity(cap)
 	c.negativeCache.ResetCapacity(cap)
+	c.nxdomainCache.ResetCapacity(cap)
+	c.subnetCache.ResetCapacity(cap)
+	c.scopes.resetCapacity(cap)
 }
 
-func (c *ViewCache) Get(req *g53.Message) (*g53.Message, bool) {
-	if msg, ok := c.positiveCache.Get(req); ok {
-		return msg, true
-	} else if msg, ok := c.negativeCache.Get(req); ok {
+func (c *ViewCache) SetTtlLimit(limit TtlLimit) {
+	c.positiveCache.SetTtlLimit(limit)
+	c.negativeCache.SetTtlLimit(limit)
+	c.nxdomainCache.SetTtlLimit(limit)
+	c.subnetCache.SetTtlLimit(limit)
+}
+
+func (c *ViewCache) SetPrefetchRate(rate uint32) {
+	c.positiveCache.SetPrefetchRate(rate)
+	c.negativeCache.SetPrefetchRate(rate)
+	c.subnetCache.SetPrefetchRate(rate)
+}
+
+func (c *ViewCache) SetStaleWindow(window time.Duration) {
+	c.positiveCache.SetStaleWindow(window)
+	c.negativeCache.SetStaleWindow(window)
+}
+
+func (c *ViewCache) Get(req *g53.Message, ip net.IP) (*g53.Message, bool, bool) {
+	//ip is the client address, used when request has no subnet option
+	clientSubnet := ecs.FromEdns(req.Edns)
+	if msg, prefetch, ok := c.getSubnet(req, clientSubnet, ip); ok {
+		return msg, prefetch, true
+	}
+
+	msg, prefetch, ok := c.get(req)
+	if ok && clientSubnet != nil {
+		ecs.Echo(msg, clientSubnet)
+	}
+	return msg, prefetch, ok
+}
+
+func (c *ViewCache) getSubnet(req *g53.Message, clientSubnet *ecs.ClientSubnet, ip net.IP) (*g53.Message, bool, bool) {
+	addr := clientSubnet
+	if addr == nil {
+		if ip == nil {
+			return nil, false, false
+		}
+		addr = ecs.NewClientSubnet(ip, net.IPv6len*8)
+	}
+	if addr.SourcePrefix == 0 {
+		return nil, false, false
+	}
+
+	//rfc7871 7.3.1, the longest scope not exceeding the source prefix wins
+	for _, scope := range c.scopes.get(req.Question.Name, req.Question.Type, addr.Family) {
+		if scope > addr.SourcePrefix {
+			continue
+		}
+
+		subnet := *addr
+		subnet.ScopePrefix = scope
+		if msg, prefetch, ok := c.subnetCache.LookupSubnet(req, &subnet); ok {
+			if clientSubnet != nil {
+				echo := *clientSubnet
+				echo.ScopePrefix = scope
+				msg.Edns = &g53.EDNS{
+					UdpSize: req.Edns.UdpSize,
+					Options: []g53.Option{&echo},
+				}
+			}
+			return msg, prefetch, true
+		}
+	}
+	return nil, false, false
+}
+
+func (c *ViewCache) get(req *g53.Message) (*g53.Message, bool, bool) {
+	if msg, prefetch, ok := c.positiveCache.Lookup(req); ok {
+		return msg, prefetch, true
+	} else if msg, prefetch, ok := c.negativeCache.Lookup(req); ok {
+		return msg, prefetch, true
+	} else if msg, ok := c.nxdomainCache.GetNXDomain(req); ok {
+		return msg, false, true
+	} else {
+		return nil, false, false
+	}
+}
+
+func (c *ViewCache) GetStale(req *g53.Message, ttl g53.RRTTL) (*g53.Message, bool) {
+	if msg, ok := c.positiveCache.GetStale(req, ttl); ok {
 		return msg, true
 	} else {
-		return nil, false
+		return c.negativeCache.GetStale(req, ttl)
 	}
 }
 
 func (c *ViewCache) Add(msg *g53.Message) {
+	if subnet := ecs.FromEdns(msg.Edns); subnet != nil && subnet.ScopePrefix > 0 {
+		c.subnetCache.AddSubnet(msg, subnet)
+		c.scopes.add(msg.Question.Name, msg.Question.Type, subnet.Family, subnet.ScopePrefix)
+		return
+	}
+
 	if msg.Header.ANCount > 0 {
 		c.positiveCache.Add(msg)
 	} else {
 		c.negativeCache.Add(msg)
+		if msg.Header.Rcode == g53.R_NXDOMAIN {
+			c.nxdomainCache.AddNXDomain(msg)
+		}
 	}
 }
 
+func (c *ViewCache) Messages() []*g53.Message {
+	return append(c.negativeCache.Messages(), c.positiveCache.Messages()...)
+}
+
 func (c *ViewCache) Remove(name *g53.Name, typ g53.RRType) bool {
+	c.nxdomainCache.Remove(name, g53.RR_ANY)
+	c.scopes.remove(name, typ)
 	if found := c.positiveCache.Remove(name, typ); found {
 		return true
 	} else {
 		return c.negativeCache.Remove(name, typ)
 	}
 }
+
+type subnetScopes struct {
+	//scopes cached for a query, longest first, so lookup doesn't probe
+	//every prefix length of the client address
+	cap    int
+	scopes map[uint64]*list.Element
+	ll     *list.List
+	mu     sync.RWMutex
+}
+
+type scopesEntry struct {
+	key    uint64
+	scopes []uint8
+}
+
+func newSubnetScopes(cap int) *subnetScopes {
+	return &subnetScopes{
+		cap:    cap,
+		scopes: make(map[uint64]*list.Element),
+		ll:     list.New(),
+	}
+}
+
+func scopesKey(name *g53.Name, typ g53.RRType, family uint16) uint64 {
+	keyHash, _ := hashQuery(name, typ, []byte{byte(family)})
+	return keyHash
+}
+
+func (s *subnetScopes) get(name *g53.Name, typ g53.RRType, family uint16) []uint8 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if elem, ok := s.scopes[scopesKey(name, typ, family)]; ok {
+		return elem.Value.(*scopesEntry).scopes
+	}
+	return nil
+}
+
+func (s *subnetScopes) add(name *g53.Name, typ g53.RRType, family uint16, scope uint8) {
+	key := scopesKey(name, typ, family)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, exist := s.scopes[key]
+	var scopes []uint8
+	if exist {
+		scopes = elem.Value.(*scopesEntry).scopes
+	}
+	i := 0
+	for ; i < len(scopes) && scopes[i] >= scope; i++ {
+		if scopes[i] == scope {
+			return
+		}
+	}
+
+	newScopes := make([]uint8, 0, len(scopes)+1)
+	newScopes = append(newScopes, scopes[:i]...)
+	newScopes = append(newScopes, scope)
+	newScopes = append(newScopes, scopes[i:]...)
+	if exist {
+		//scopes slice is shared with readers, replace it as a whole
+		elem.Value.(*scopesEntry).scopes = newScopes
+		s.ll.MoveToFront(elem)
+		return
+	}
+
+	//entries behind the dropped scopes are aged out by the lru
+	s.shrink(s.cap - 1)
+	s.scopes[key] = s.ll.PushFront(&scopesEntry{key: key, scopes: newScopes})
+}
+
+func (s *subnetScopes) shrink(cap int) {
+	for s.ll.Len() > cap && s.ll.Len() > 0 {
+		elem := s.ll.Back()
+		delete(s.scopes, elem.Value.(*scopesEntry).key)
+		s.ll.Remove(elem)
+	}
+}
+
+func (s *subnetScopes) remove(name *g53.Name, typ g53.RRType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, family := range []uint16{ecs.FamilyIPv4, ecs.FamilyIPv6} {
+		if elem, ok := s.scopes[scopesKey(name, typ, family)]; ok {
+			delete(s.scopes, elem.Value.(*scopesEntry).key)
+			s.ll.Remove(elem)
+		}
+	}
+}
+
+func (s *subnetScopes) resetCapacity(cap int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cap = cap
+	s.shrink(cap)
+}
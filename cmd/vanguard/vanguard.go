@@ -18,6 +18,7 @@ import (
 	"github.com/ben-han-cn/vanguard/metrics"
 	"github.com/ben-han-cn/vanguard/querylog"
 	"github.com/ben-han-cn/vanguard/resolver"
+	"github.com/ben-han-cn/vanguard/resolver/auth"
 	"github.com/ben-han-cn/vanguard/responsetransfer"
 	"github.com/ben-han-cn/vanguard/server"
 	view "github.com/ben-han-cn/vanguard/viewselector"
@@ -84,8 +85,8 @@ func main() {
 	}
 
 	acl.NewAclManager(conf)
-	queryHandler, xfrHandler := createHandler(conf)
-	server, err := server.NewServer(conf, queryHandler, xfrHandler)
+	queryHandler, xfrHandler, updateHandler := createHandler(conf)
+	server, err := server.NewServer(conf, queryHandler, xfrHandler, updateHandler)
 	if err != nil {
 		panic("create server failed:" + err.Error())
 	}
@@ -128,7 +129,7 @@ var moduleInOrder = []string{
 	ModuleFailForwarder,
 }
 
-func createHandler(conf *config.VanguardConf) (core.DNSQueryHandler, core.DNSQueryHandler) {
+func createHandler(conf *config.VanguardConf) (core.DNSQueryHandler, core.DNSQueryHandler, core.DNSQueryHandler) {
 	creator := make(map[string]ModuleCreator)
 	resolverEnable := false
 	for _, m := range conf.EnableModules {
@@ -174,9 +175,10 @@ func createHandler(conf *config.VanguardConf) (core.DNSQueryHandler, core.DNSQue
 	}
 	core.BuildQueryChain(handlers...)
 
-	var xfrHandler core.DNSQueryHandler
+	var xfrHandler, updateHandler core.DNSQueryHandler
 	if viewSelector != nil && resol != nil && resol.Auth != nil {
 		xfrHandler = xfr.NewXFRHandler(viewSelector, resol.Auth)
+		updateHandler = auth.NewUpdateHandler(viewSelector, resol.Auth)
 	}
-	return handlers[0], xfrHandler
+	return handlers[0], xfrHandler, updateHandler
 }
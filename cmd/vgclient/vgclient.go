@@ -10,6 +10,7 @@ import (
 	"github.com/ben-han-cn/vanguard/httpcmd"
 	"github.com/ben-han-cn/vanguard/resolver/auth"
 	"github.com/ben-han-cn/vanguard/resolver/forwarder"
+	"github.com/ben-han-cn/vanguard/resolver/recursor"
 	"github.com/ben-han-cn/vanguard/server"
 )
 
@@ -23,6 +24,9 @@ const (
 	cmdAddForwarder    = "add_forwarder"
 	cmdGetDomainCache  = "get_domain_cache"
 	cmdGetMessageCache = "get_message_cache"
+	cmdListNsasZones   = "list_nsas_zones"
+	cmdListNameServers = "list_nsas_name_servers"
+	cmdFlushNsasZone   = "flush_nsas_zone"
 )
 
 const cmdServiceName = "vanguard_cmd"
@@ -47,6 +51,10 @@ var supportedCommands = []httpcmd.Command{
 	&auth.DeleteAuthRrs{},
 
 	&forwarder.AddForwardZone{},
+
+	&recursor.ListNsasZones{},
+	&recursor.ListNsasNameServers{},
+	&recursor.FlushNsasZone{},
 }
 
 func main() {
@@ -109,6 +117,16 @@ func main() {
 			Type: args[3],
 		}
 		task.AddCmd(getMessageCache)
+	case cmdListNsasZones:
+		task.AddCmd(&recursor.ListNsasZones{View: args[1]})
+	case cmdListNameServers:
+		listNameServers := &recursor.ListNsasNameServers{View: args[1]}
+		if len(args) > 2 {
+			listNameServers.Zone = args[2]
+		}
+		task.AddCmd(listNameServers)
+	case cmdFlushNsasZone:
+		task.AddCmd(&recursor.FlushNsasZone{View: args[1], Zone: args[2]})
 	default:
 		fmt.Printf("unknown cmd %v\n", args[0])
 		return
@@ -141,6 +159,22 @@ func main() {
 				fmt.Printf("%v\n", rrset)
 			}
 		}
+	} else if args[0] == cmdListNsasZones {
+		var zones []recursor.ZoneInNsas
+		err = proxy.HandleTask(task, &zones)
+		if err.(*httpcmd.Error) == nil {
+			for _, zone := range zones {
+				fmt.Printf("%v\n", zone)
+			}
+		}
+	} else if args[0] == cmdListNameServers {
+		var servers []recursor.NameServerInNsas
+		err = proxy.HandleTask(task, &servers)
+		if err.(*httpcmd.Error) == nil {
+			for _, server := range servers {
+				fmt.Printf("%v\n", server)
+			}
+		}
 	} else {
 		err = proxy.HandleTask(task, nil)
 	}
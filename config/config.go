@@ -40,10 +40,29 @@ type VanguardConf struct {
 }
 
 type ServerConf struct {
-	Addrs        []string `yaml:"addr"`
-	HttpCmdAddr  string   `yaml:"http_cmd_addr"`
-	HandlerCount int      `yaml:"handler_count"`
-	EnableTCP    bool     `yaml:"enable_tcp"`
+	Addrs              []string `yaml:"addr"`
+	HttpCmdAddr        string   `yaml:"http_cmd_addr"`
+	HandlerCount       int      `yaml:"handler_count"`
+	EnableTCP          bool     `yaml:"enable_tcp"`
+	MaxTCPConn         int      `yaml:"max_tcp_conn"`
+	TCPIdleTimeout     uint32   `yaml:"tcp_idle_timeout"`
+	MaxQueryPerTCPConn int      `yaml:"max_query_per_tcp_conn"`
+	MaxUDPSize         uint16   `yaml:"max_udp_size"`
+	TLS                TLSConf  `yaml:"tls"`
+	DoH                DoHConf  `yaml:"doh"`
+}
+
+type TLSConf struct {
+	Addrs    []string `yaml:"addr"`
+	CertFile string   `yaml:"cert_file"`
+	KeyFile  string   `yaml:"key_file"`
+}
+
+type DoHConf struct {
+	Addr     string `yaml:"addr"`
+	Path     string `yaml:"path"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
 }
 
 type ViewConf struct {
@@ -88,11 +107,31 @@ type ViewWeight struct {
 }
 
 type CacheConf struct {
+	MinTtl             uint32        `yaml:"min_ttl"`
+	PositiveTtl        uint32        `yaml:"positive_ttl"`
+	NegativeTtl        uint32        `yaml:"negative_ttl"`
+	MaxCacheSize       uint          `yaml:"max_cache_size"`
+	MaxMemory          uint64        `yaml:"max_memory"`
+	ShortAnswer        bool          `yaml:"short_answer"`
+	Prefetch           bool          `yaml:"prefetch"`
+	PrefetchRate       uint32        `yaml:"prefetch_rate"`
+	ServeStale         bool          `yaml:"serve_stale"`
+	StaleTtl           uint32        `yaml:"stale_ttl"`
+	StaleAnswerTtl     uint32        `yaml:"stale_answer_ttl"`
+	StaleClientTimeout uint32        `yaml:"stale_client_timeout"`
+	StaleEDE           bool          `yaml:"stale_ede"`
+	SnapshotFile       string        `yaml:"snapshot_file"`
+	ViewCaches         []CacheInView `yaml:"view_cache"`
+}
+
+type CacheInView struct {
+	View         string `yaml:"view"`
+	MaxCacheSize uint   `yaml:"max_cache_size"`
+	MaxMemory    uint64 `yaml:"max_memory"`
+	MinTtl       uint32 `yaml:"min_ttl"`
 	PositiveTtl  uint32 `yaml:"positive_ttl"`
 	NegativeTtl  uint32 `yaml:"negative_ttl"`
-	MaxCacheSize uint   `yaml:"max_cache_size"`
-	ShortAnswer  bool   `yaml:"short_answer"`
-	Prefetch     bool   `yaml:"prefetch"`
+	ShortAnswer  *bool  `yaml:"short_answer"`
 }
 
 type SortListInView struct {
@@ -114,10 +153,16 @@ type ForwardZoneConf struct {
 }
 
 type RecursorInView struct {
-	Enable           bool   `yaml:"enable"`
-	View             string `yaml:"view"`
-	RootHintFile     string `yaml:"root_hint"`
-	EdnsSubnetEnable bool   `yaml:"subnet_enable"`
+	Enable            bool   `yaml:"enable"`
+	View              string `yaml:"view"`
+	RootHintFile      string `yaml:"root_hint"`
+	EdnsSubnetEnable  bool   `yaml:"subnet_enable"`
+	SubnetV4Prefix    uint8  `yaml:"subnet_v4_prefix"`
+	SubnetV6Prefix    uint8  `yaml:"subnet_v6_prefix"`
+	DnssecEnable      bool   `yaml:"dnssec_enable"`
+	TrustAnchorFile   string `yaml:"trust_anchor"`
+	QnameMinimisation bool   `yaml:"qname_minimisation"`
+	AddressFamily     string `yaml:"address_family"`
 }
 
 type ForwardZoneInView struct {
@@ -126,13 +171,29 @@ type ForwardZoneInView struct {
 	Zones       []ForwardZoneConf `yaml:"zones"`
 }
 
+type TLSForwarderConf struct {
+	Addr   string `yaml:"addr"`
+	CAFile string `yaml:"ca_file"`
+}
+
 type ForwarderConf struct {
-	ForwardZones []ForwardZoneInView `yaml:"forward_zone_for_view,omitempty"`
-	Prober       ForwardProberConf   `yaml:"probe_setting"`
+	ForwardZones  []ForwardZoneInView `yaml:"forward_zone_for_view,omitempty"`
+	Prober        ForwardProberConf   `yaml:"probe_setting"`
+	TLSForwarders []TLSForwarderConf  `yaml:"tls_forwarders"`
 }
 
 type ResolverConf struct {
-	CheckCnameIndirect bool `yaml:"check_cname_indirect"`
+	CheckCnameIndirect bool         `yaml:"check_cname_indirect"`
+	Recursor           RecursorConf `yaml:"recursor"`
+}
+
+type RecursorConf struct {
+	MaxQueryDepth      uint32 `yaml:"max_query_depth"`
+	MaxInflightQuery   int    `yaml:"max_inflight_query"`
+	SingleQueryTimeout uint32 `yaml:"single_query_timeout"`
+	QueryTimeout       uint32 `yaml:"query_timeout"`
+	BatchQueryCount    int    `yaml:"batch_query_count"`
+	MaxZoneCount       int    `yaml:"max_zone_count"`
 }
 
 type QuerySourceInView struct {
@@ -208,9 +269,23 @@ type DNS64InView struct {
 }
 
 type AuthZoneConf struct {
-	Name    string   `yaml:"name"`
-	File    string   `yaml:"file"`
-	Masters []string `yaml:"masters"`
+	Name            string          `yaml:"name"`
+	File            string          `yaml:"file"`
+	Masters         []string        `yaml:"masters"`
+	Notify          []string        `yaml:"notify"`
+	AllowUpdate     []string        `yaml:"allow_update"`
+	AllowUpdateKeys []string        `yaml:"allow_update_keys"`
+	AllowTransfer   []string        `yaml:"allow_transfer"`
+	Dnssec          *ZoneDnssecConf `yaml:"dnssec"`
+}
+
+type ZoneDnssecConf struct {
+	Algorithm       string `yaml:"algorithm"`
+	KSKFile         string `yaml:"ksk_file"`
+	ZSKFile         string `yaml:"zsk_file"`
+	NSEC3           bool   `yaml:"nsec3"`
+	NSEC3Iterations uint16 `yaml:"nsec3_iterations"`
+	NSEC3Salt       string `yaml:"nsec3_salt"`
 }
 
 type StubZoneConf struct {
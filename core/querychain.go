@@ -6,6 +6,10 @@ type DNSQueryHandler interface {
 	SetNext(DNSQueryHandler)
 }
 
+type ShutdownHook interface {
+	Shutdown()
+}
+
 type DefaultHandler struct {
 	next DNSQueryHandler
 }
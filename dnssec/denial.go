@@ -0,0 +1,205 @@
+package dnssec
+
+import (
+	"github.com/ben-han-cn/g53"
+)
+
+type DenialRecords struct {
+	//nsec and nsec3 records of a response which are already validated
+	nsecs  []*g53.RRset
+	nsec3s []*g53.RRset
+}
+
+func NewDenialRecords(section g53.Section) *DenialRecords {
+	d := &DenialRecords{}
+	for _, rrset := range section {
+		switch rrset.Type {
+		case g53.RR_NSEC:
+			d.nsecs = append(d.nsecs, rrset)
+		case g53.RR_NSEC3:
+			if _, ok := rrset.Rdatas[0].(*NSEC3); ok == false {
+				wrapped := *rrset
+				wrapped.Rdatas = []g53.Rdata{wrapNSEC3(rrset.Rdatas[0])}
+				rrset = &wrapped
+			}
+			d.nsec3s = append(d.nsec3s, rrset)
+		}
+	}
+	return d
+}
+
+func (d *DenialRecords) IsEmpty() bool {
+	return len(d.nsecs) == 0 && len(d.nsec3s) == 0
+}
+
+func (d *DenialRecords) ProveNXDomain(name *g53.Name) bool {
+	if owner, nsec := d.nsecCovering(name); nsec != nil {
+		ce := nsecClosestEncloser(name, owner, nsec)
+		return d.nsecNoWildcard(ce)
+	}
+
+	if ce, _, _ := d.nsec3ClosestEncloser(name); ce != nil {
+		return d.nsec3Covering(wildcardName(ce)) != nil
+	}
+	return false
+}
+
+func (d *DenialRecords) ProveNoData(name *g53.Name, typ g53.RRType) bool {
+	if nsec := d.nsecMatching(name); nsec != nil {
+		return nsec.HasType(typ) == false && nsec.HasType(g53.RR_CNAME) == false
+	}
+
+	if owner, nsec := d.nsecCovering(name); nsec != nil {
+		//empty non-terminal exists only if next name is under it
+		if nsec.NextName.IsSubDomain(name) {
+			return true
+		}
+		//wildcard no data
+		ce := nsecClosestEncloser(name, owner, nsec)
+		wildcard := d.nsecMatching(wildcardName(ce))
+		return wildcard != nil && wildcard.HasType(typ) == false && wildcard.HasType(g53.RR_CNAME) == false
+	}
+
+	if nsec3 := d.nsec3Matching(name); nsec3 != nil {
+		return NSEC3HasType(nsec3, typ) == false && NSEC3HasType(nsec3, g53.RR_CNAME) == false
+	}
+
+	ce, _, covering := d.nsec3ClosestEncloser(name)
+	if ce == nil {
+		return false
+	} else if typ == g53.RR_DS && NSEC3IsOptOut(covering) {
+		return true
+	}
+	wildcard := d.nsec3Matching(wildcardName(ce))
+	return wildcard != nil && NSEC3HasType(wildcard, typ) == false && NSEC3HasType(wildcard, g53.RR_CNAME) == false
+}
+
+func (d *DenialRecords) ProveInsecureDelegation(child *g53.Name) bool {
+	//child zone is delegated without ds
+	if nsec := d.nsecMatching(child); nsec != nil {
+		return nsec.HasType(g53.RR_NS) && nsec.HasType(g53.RR_DS) == false && nsec.HasType(g53.RR_SOA) == false
+	}
+
+	if nsec3 := d.nsec3Matching(child); nsec3 != nil {
+		return NSEC3HasType(nsec3, g53.RR_NS) && NSEC3HasType(nsec3, g53.RR_DS) == false && NSEC3HasType(nsec3, g53.RR_SOA) == false
+	}
+
+	ce, _, covering := d.nsec3ClosestEncloser(child)
+	return ce != nil && NSEC3IsOptOut(covering)
+}
+
+func (d *DenialRecords) ProveWildcardExpansion(name *g53.Name, labels uint8) bool {
+	//answer expanded from wildcard, which means no closer match for the name
+	if _, nsec := d.nsecCovering(name); nsec != nil {
+		return true
+	}
+
+	nameLabels := name.LabelCount() - 1
+	if uint(labels) >= nameLabels {
+		return false
+	}
+	nextCloser, err := name.Parent(nameLabels - uint(labels) - 1)
+	if err != nil {
+		return false
+	}
+	return d.nsec3Covering(nextCloser) != nil
+}
+
+func (d *DenialRecords) nsecMatching(name *g53.Name) *NSEC {
+	for _, rrset := range d.nsecs {
+		if rrset.Name.Equals(name) {
+			return rrset.Rdatas[0].(*NSEC)
+		}
+	}
+	return nil
+}
+
+func (d *DenialRecords) nsecCovering(name *g53.Name) (*g53.Name, *NSEC) {
+	for _, rrset := range d.nsecs {
+		nsec := rrset.Rdatas[0].(*NSEC)
+		if NSECCovers(rrset.Name, nsec, name) {
+			return rrset.Name, nsec
+		}
+	}
+	return nil, nil
+}
+
+func (d *DenialRecords) nsecNoWildcard(ce *g53.Name) bool {
+	wildcard := wildcardName(ce)
+	if d.nsecMatching(wildcard) != nil {
+		return false
+	}
+	_, nsec := d.nsecCovering(wildcard)
+	return nsec != nil
+}
+
+func (d *DenialRecords) nsec3Matching(name *g53.Name) *g53.NSEC3 {
+	for _, rrset := range d.nsec3s {
+		nsec3 := &rrset.Rdatas[0].(*NSEC3).NSEC3
+		if NSEC3Matches(rrset.Name, nsec3, name) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+func (d *DenialRecords) nsec3Covering(name *g53.Name) *g53.NSEC3 {
+	for _, rrset := range d.nsec3s {
+		nsec3 := &rrset.Rdatas[0].(*NSEC3).NSEC3
+		if NSEC3Covers(rrset.Name, nsec3, name) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+func (d *DenialRecords) nsec3ClosestEncloser(name *g53.Name) (*g53.Name, *g53.Name, *g53.NSEC3) {
+	//closest encloser proof, rfc5155 8.3
+	if len(d.nsec3s) == 0 {
+		return nil, nil, nil
+	}
+
+	nextCloser := name
+	for i := uint(1); i < name.LabelCount(); i++ {
+		ce, err := name.Parent(i)
+		if err != nil {
+			return nil, nil, nil
+		}
+		if d.nsec3Matching(ce) != nil {
+			if covering := d.nsec3Covering(nextCloser); covering != nil {
+				return ce, nextCloser, covering
+			}
+			return nil, nil, nil
+		}
+		nextCloser = ce
+	}
+	return nil, nil, nil
+}
+
+func nsecClosestEncloser(name, owner *g53.Name, nsec *NSEC) *g53.Name {
+	ce := commonAncestor(name, owner)
+	if next := commonAncestor(name, nsec.NextName); next.LabelCount() > ce.LabelCount() {
+		ce = next
+	}
+	return ce
+}
+
+func commonAncestor(n1, n2 *g53.Name) *g53.Name {
+	common := uint(n1.Compare(n2, false).CommonLabelCount)
+	if common == 0 {
+		return g53.Root
+	}
+	ancestor, err := n1.Parent(n1.LabelCount() - common)
+	if err != nil {
+		return g53.Root
+	}
+	return ancestor
+}
+
+func wildcardName(ce *g53.Name) *g53.Name {
+	wildcard, err := g53.NameFromStringUnsafe("*").Concat(ce)
+	if err != nil {
+		return ce
+	}
+	return wildcard
+}
@@ -0,0 +1,304 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+)
+
+const (
+	RSASHA256       uint8 = 8
+	ECDSAP256SHA256 uint8 = 13
+	ECDSAP384SHA384 uint8 = 14
+	ED25519         uint8 = 15
+)
+
+const (
+	DigestSHA1   uint8 = 1
+	DigestSHA256 uint8 = 2
+	DigestSHA384 uint8 = 4
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("dnssec algorithm isn't supported")
+	ErrUnsupportedDigest    = errors.New("ds digest type isn't supported")
+	ErrInvalidPublicKey     = errors.New("dnskey public key is invalid")
+	ErrSignatureMismatch    = errors.New("signature doesn't match")
+	ErrSignatureExpired     = errors.New("signature is expired or not yet valid")
+	ErrKeyMismatch          = errors.New("key doesn't match signature")
+	ErrRRsetMismatch        = errors.New("rrset doesn't match signature")
+)
+
+func IsSupportedAlgorithm(alg uint8) bool {
+	switch alg {
+	case RSASHA256, ECDSAP256SHA256, ECDSAP384SHA384, ED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+func IsSupportedDigest(typ uint8) bool {
+	switch typ {
+	case DigestSHA1, DigestSHA256, DigestSHA384:
+		return true
+	default:
+		return false
+	}
+}
+
+func KeyTag(key *DNSKEY) uint16 {
+	wire := rdataToWire(key)
+	var ac uint32
+	for i, b := range wire {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+func MakeDS(owner *g53.Name, key *DNSKEY, digestType uint8) (*g53.DS, error) {
+	var h hash.Hash
+	switch digestType {
+	case DigestSHA1:
+		h = sha1.New()
+	case DigestSHA256:
+		h = sha256.New()
+	case DigestSHA384:
+		h = sha512.New384()
+	default:
+		return nil, ErrUnsupportedDigest
+	}
+
+	h.Write(canonicalName(owner))
+	h.Write(rdataToWire(key))
+	return &g53.DS{
+		KeyTag:     KeyTag(key),
+		Algorithm:  key.Algorithm,
+		DigestType: digestType,
+		Digest:     hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func MatchDS(owner *g53.Name, key *DNSKEY, ds *g53.DS) bool {
+	if ds.KeyTag != KeyTag(key) || ds.Algorithm != key.Algorithm {
+		return false
+	}
+
+	expect, err := MakeDS(owner, key, ds.DigestType)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(expect.Digest, ds.Digest)
+}
+
+func IsSignatureValidAt(sig *g53.RRSig, t time.Time) bool {
+	//check signature validity period with serial number arithmetic
+	now := uint32(t.Unix())
+	return int32(now-sig.Inception) >= 0 && int32(sig.SigExpire-now) >= 0
+}
+
+func Verify(rrset *g53.RRset, sig *g53.RRSig, key *DNSKEY) error {
+	if sig.Algorithm != key.Algorithm || sig.Tag != KeyTag(key) || key.Protocol != DNSKEY_PROTOCOL || key.IsZoneKey() == false {
+		return ErrKeyMismatch
+	}
+
+	if sig.Covered != rrset.Type || rrset.Name.IsSubDomain(sig.Signer) == false ||
+		uint(sig.Labels) > rrset.Name.LabelCount()-1 {
+		return ErrRRsetMismatch
+	}
+
+	data, err := signedData(rrset, sig)
+	if err != nil {
+		return err
+	}
+
+	switch key.Algorithm {
+	case RSASHA256:
+		pub, err := rsaPublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig.Signature); err != nil {
+			return ErrSignatureMismatch
+		}
+	case ECDSAP256SHA256, ECDSAP384SHA384:
+		pub, err := ecdsaPublicKey(key.Algorithm, key.PublicKey)
+		if err != nil {
+			return err
+		}
+		size := len(key.PublicKey) / 2
+		if len(sig.Signature) != size*2 {
+			return ErrSignatureMismatch
+		}
+		r := new(big.Int).SetBytes(sig.Signature[:size])
+		s := new(big.Int).SetBytes(sig.Signature[size:])
+		if ecdsa.Verify(pub, ecdsaDigest(key.Algorithm, data), r, s) == false {
+			return ErrSignatureMismatch
+		}
+	case ED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return ErrInvalidPublicKey
+		}
+		if ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, sig.Signature) == false {
+			return ErrSignatureMismatch
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+func rsaPublicKey(data []byte) (*rsa.PublicKey, error) {
+	if len(data) < 3 {
+		return nil, ErrInvalidPublicKey
+	}
+
+	expLen, offset := int(data[0]), 1
+	if expLen == 0 {
+		expLen, offset = int(data[1])<<8|int(data[2]), 3
+	}
+	if expLen > 4 || len(data) <= offset+expLen {
+		return nil, ErrInvalidPublicKey
+	}
+
+	exp := 0
+	for _, b := range data[offset : offset+expLen] {
+		exp = exp<<8 | int(b)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(data[offset+expLen:]),
+		E: exp,
+	}, nil
+}
+
+func ecdsaPublicKey(alg uint8, data []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	if alg == ECDSAP384SHA384 {
+		curve = elliptic.P384()
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(data) != size*2 {
+		return nil, ErrInvalidPublicKey
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(data[:size]),
+		Y:     new(big.Int).SetBytes(data[size:]),
+	}, nil
+}
+
+func ecdsaDigest(alg uint8, data []byte) []byte {
+	if alg == ECDSAP384SHA384 {
+		digest := sha512.Sum384(data)
+		return digest[:]
+	}
+	digest := sha256.Sum256(data)
+	return digest[:]
+}
+
+func signedData(rrset *g53.RRset, sig *g53.RRSig) ([]byte, error) {
+	//rrsig rdata without signature followed by rrset in canonical form, rfc4034 3.1.8.1
+	buf := util.NewOutputBuffer(512)
+	buf.WriteUint16(uint16(sig.Covered))
+	buf.WriteUint8(sig.Algorithm)
+	buf.WriteUint8(sig.Labels)
+	buf.WriteUint32(sig.OriginalTtl)
+	buf.WriteUint32(sig.SigExpire)
+	buf.WriteUint32(sig.Inception)
+	buf.WriteUint16(sig.Tag)
+	buf.WriteData(canonicalName(sig.Signer))
+
+	owner := rrset.Name
+	if labels := owner.LabelCount() - 1; uint(sig.Labels) < labels {
+		//rrset is expanded from wildcard
+		suffix, err := owner.Parent(labels - uint(sig.Labels))
+		if err != nil {
+			return nil, err
+		}
+		if owner, err = g53.NameFromStringUnsafe("*").Concat(suffix); err != nil {
+			return nil, err
+		}
+	}
+	ownerWire := canonicalName(owner)
+
+	rdatas := make([][]byte, 0, len(rrset.Rdatas))
+	for _, rdata := range rrset.Rdatas {
+		rdatas = append(rdatas, canonicalRdata(rrset.Type, rdata))
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		buf.WriteData(ownerWire)
+		buf.WriteUint16(uint16(rrset.Type))
+		buf.WriteUint16(uint16(rrset.Class))
+		buf.WriteUint32(sig.OriginalTtl)
+		buf.WriteUint16(uint16(len(rdata)))
+		buf.WriteData(rdata)
+	}
+	return buf.Data(), nil
+}
+
+func canonicalName(name *g53.Name) []byte {
+	return lowerASCII(append([]byte(nil), name.Bytes()[:name.Length()]...))
+}
+
+func canonicalRdata(typ g53.RRType, rdata g53.Rdata) []byte {
+	//rdata with embedded domain name lowercased, rfc4034 6.2
+	wire := rdataToWire(rdata)
+	switch r := rdata.(type) {
+	case *g53.NS:
+		lowerASCII(wire[len(wire)-int(r.Name.Length()):])
+	case *g53.CName:
+		lowerASCII(wire[len(wire)-int(r.Name.Length()):])
+	case *g53.PTR:
+		lowerASCII(wire[len(wire)-int(r.Name.Length()):])
+	case *g53.DName:
+		lowerASCII(wire[len(wire)-int(r.Target.Length()):])
+	case *g53.MX:
+		lowerASCII(wire[len(wire)-int(r.Exchange.Length()):])
+	case *g53.SRV:
+		lowerASCII(wire[len(wire)-int(r.Target.Length()):])
+	case *g53.NAPTR:
+		lowerASCII(wire[len(wire)-int(r.Replacement.Length()):])
+	case *g53.SOA:
+		lowerASCII(wire[:len(wire)-20])
+	case *g53.RP:
+		lowerASCII(wire)
+	}
+	return wire
+}
+
+func lowerASCII(data []byte) []byte {
+	for i, c := range data {
+		if c >= 'A' && c <= 'Z' {
+			data[i] = c + ('a' - 'A')
+		}
+	}
+	return data
+}
@@ -0,0 +1,194 @@
+package dnssec
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+)
+
+const rootKSK2017 = ". 172800 IN DNSKEY 257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU="
+
+func rrsetFromString(s string) *g53.RRset {
+	rrset, err := RRsetFromString(s)
+	if err != nil {
+		panic("invalid rr " + s + ":" + err.Error())
+	}
+	return rrset
+}
+
+func TestKeyTagAndDS(t *testing.T) {
+	rrset := rrsetFromString(rootKSK2017)
+	key := rrset.Rdatas[0].(*DNSKEY)
+	ut.Equal(t, KeyTag(key), uint16(20326))
+	ut.Assert(t, key.IsSEP(), "ksk should has sep flag")
+
+	ds, err := MakeDS(g53.Root, key, DigestSHA256)
+	ut.Assert(t, err == nil, "make ds failed %v", err)
+	ut.Equal(t, strings.ToUpper(ds.Digest), "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D")
+
+	anchors := DefaultTrustAnchors()
+	anchor := anchors.ClosestAnchor(g53.NameFromStringUnsafe("www.example.com."))
+	ut.Assert(t, anchor != nil && anchor.Zone.IsRoot(), "root anchor should be found")
+	ut.Assert(t, anchor.Trust(key), "root ksk should be trusted")
+}
+
+func TestSignAndVerify(t *testing.T) {
+	zone := g53.NameFromStringUnsafe("example.com.")
+	now := time.Now()
+	for _, alg := range []uint8{RSASHA256, ECDSAP256SHA256, ECDSAP384SHA384, ED25519} {
+		key, priv, err := GenerateKey(alg, DNSKEY_FLAG_ZONE)
+		ut.Assert(t, err == nil, "generate key failed %v", err)
+
+		rrset := rrsetFromString("WWW.example.com. 300 IN A 1.1.1.1")
+		rrset.Rdatas = append(rrset.Rdatas, rrsetFromString("www.example.com. 300 IN A 2.2.2.2").Rdatas[0])
+		sig, err := Sign(rrset, zone, key, priv, now.Add(-time.Hour), now.Add(time.Hour))
+		ut.Assert(t, err == nil, "sign failed %v", err)
+		ut.Equal(t, sig.Labels, uint8(3))
+		ut.Assert(t, IsSignatureValidAt(sig, now), "signature should be valid now")
+		ut.Assert(t, IsSignatureValidAt(sig, now.Add(2*time.Hour)) == false, "signature should expire")
+
+		reversed := rrset.Clone()
+		reversed.Rdatas[0], reversed.Rdatas[1] = reversed.Rdatas[1], reversed.Rdatas[0]
+		reversed.Ttl = 100
+		ut.Assert(t, Verify(reversed, sig, key) == nil, "rdata order and ttl shouldn't affect signature")
+
+		tampered := rrset.Clone()
+		tampered.Rdatas[0] = rrsetFromString("www.example.com. 300 IN A 3.3.3.3").Rdatas[0]
+		ut.Equal(t, Verify(tampered, sig, key), ErrSignatureMismatch)
+
+		wildcard := rrsetFromString("*.example.com. 300 IN A 1.1.1.1")
+		sig, _ = Sign(wildcard, zone, key, priv, now.Add(-time.Hour), now.Add(time.Hour))
+		ut.Equal(t, sig.Labels, uint8(2))
+		expanded := rrsetFromString("a.b.example.com. 300 IN A 1.1.1.1")
+		ut.Assert(t, Verify(expanded, sig, key) == nil, "wildcard expansion should be verified")
+	}
+}
+
+func TestMessageWithDNSSECRdata(t *testing.T) {
+	key, _, _ := GenerateKey(ED25519, DNSKEY_FLAG_ZONE|DNSKEY_FLAG_SEP)
+	msg := g53.MakeQuery(g53.NameFromStringUnsafe("example.com."), g53.RR_DNSKEY, 4096, true)
+	msg.Header.SetFlag(g53.FLAG_QR, true)
+	msg.Sections[g53.AnswerSection] = g53.Section{&g53.RRset{
+		Name:   g53.NameFromStringUnsafe("example.com."),
+		Type:   g53.RR_DNSKEY,
+		Class:  g53.CLASS_IN,
+		Ttl:    300,
+		Rdatas: []g53.Rdata{key},
+	}}
+	msg.Sections[g53.AuthSection] = g53.Section{
+		rrsetFromString("example.com. 300 IN NSEC www.example.com. NS SOA RRSIG NSEC DNSKEY TYPE1234"),
+	}
+	msg.RecalculateSectionRRCount()
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+
+	resp, err := MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "parse message failed %v", err)
+	ut.Assert(t, resp.Edns != nil && resp.Edns.DnssecAware, "do bit should be kept")
+	ut.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].Compare(key), 0)
+	nsec := resp.Sections[g53.AuthSection][0].Rdatas[0].(*NSEC)
+	ut.Equal(t, nsec.NextName.String(false), "www.example.com.")
+	ut.Assert(t, nsec.HasType(g53.RR_DNSKEY) && nsec.HasType(g53.RRType(1234)), "nsec types should be kept")
+	ut.Assert(t, nsec.HasType(g53.RR_A) == false, "nsec shouldn't have a")
+}
+
+func g53ParsableMessage() []byte {
+	msg := g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, true)
+	msg.Header.SetFlag(g53.FLAG_QR, true)
+	msg.Sections[g53.AnswerSection] = g53.Section{
+		rrsetFromString("www.example.com. 300 IN CNAME web.example.com."),
+		rrsetFromString("web.example.com. 300 IN A 1.1.1.1"),
+		rrsetFromString("web.example.com. 300 IN RRSIG A 8 3 300 20300101000000 20200101000000 12345 example.com. aGVsbG8="),
+	}
+	msg.Sections[g53.AnswerSection][1].AddRdata(rrsetFromString("web.example.com. 300 IN A 2.2.2.2").Rdatas[0])
+	msg.Sections[g53.AuthSection] = g53.Section{
+		rrsetFromString("example.com. 300 IN NS ns1.example.com."),
+		rrsetFromString("example.com. 300 IN SOA ns1.example.com. root.example.com. 1 3600 900 86400 300"),
+		rrsetFromString("sub.example.com. 300 IN DS 12345 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+	}
+	msg.Sections[g53.AdditionalSection] = g53.Section{
+		rrsetFromString("ns1.example.com. 300 IN AAAA 2001::1"),
+		rrsetFromString("example.com. 300 IN MX 10 mail.example.com."),
+		rrsetFromString("example.com. 300 IN TXT \"v=spf1 -all\""),
+	}
+	msg.RecalculateSectionRRCount()
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	return render.Data()
+}
+
+func renderMessage(msg *g53.Message) []byte {
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	return render.Data()
+}
+
+func g53MessageFromWire(wire []byte) (msg *g53.Message, err error) {
+	defer func() {
+		//g53 crashes on rr without rdata following one of same rrset
+		if p := recover(); p != nil {
+			msg, err = nil, fmt.Errorf("g53 crashed: %v", p)
+		}
+	}()
+	return g53.MessageFromWire(util.NewInputBuffer(wire))
+}
+
+func TestMessageFromWireSameAsG53(t *testing.T) {
+	wire := g53ParsableMessage()
+	expect, err := g53.MessageFromWire(util.NewInputBuffer(wire))
+	ut.Assert(t, err == nil, "g53 parse message failed %v", err)
+	msg, err := MessageFromWire(util.NewInputBuffer(wire))
+	ut.Assert(t, err == nil, "parse message failed %v", err)
+	ut.Equal(t, msg.String(), expect.String())
+	ut.Equal(t, renderMessage(msg), renderMessage(expect))
+	ut.Equal(t, renderMessage(msg), wire)
+}
+
+func TestMessageFromWireFuzz(t *testing.T) {
+	wire := g53ParsableMessage()
+	rand := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		mutated := make([]byte, len(wire))
+		copy(mutated, wire)
+		for j := rand.Intn(4); j >= 0; j-- {
+			mutated[rand.Intn(len(mutated))] = byte(rand.Intn(256))
+		}
+		mutated = mutated[:rand.Intn(len(mutated)+1)]
+
+		expect, g53Err := g53MessageFromWire(mutated)
+		msg, err := MessageFromWire(util.NewInputBuffer(mutated))
+		if g53Err == nil {
+			ut.Assert(t, err == nil, "%x is parsed by g53 but get %v", mutated, err)
+			ut.Equal(t, msg.String(), expect.String())
+		}
+	}
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	ut.Equal(t, NSEC3Hash(g53.NameFromStringUnsafe("example."), 12, salt), "0P9MHAVEQVM6T7VBL5LOP2U3T2RP3TOM")
+	ut.Equal(t, NSEC3Hash(g53.NameFromStringUnsafe("a.example."), 12, salt), "35MTHGPGCU1QG68FAB165KLNSNK3DPVL")
+}
+
+func TestNSECDenial(t *testing.T) {
+	records := NewDenialRecords(g53.Section{
+		rrsetFromString("example.com. 300 IN NSEC a.example.com. NS SOA RRSIG NSEC DNSKEY"),
+		rrsetFromString("a.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC"),
+		rrsetFromString("d.example.com. 300 IN NSEC example.com. NS RRSIG NSEC"),
+	})
+
+	name := func(s string) *g53.Name { return g53.NameFromStringUnsafe(s) }
+	ut.Assert(t, records.ProveNXDomain(name("b.example.com.")), "b should not exist")
+	ut.Assert(t, records.ProveNXDomain(name("a.example.com.")) == false, "a exists")
+	ut.Assert(t, records.ProveNoData(name("a.example.com."), g53.RR_AAAA), "a has no aaaa")
+	ut.Assert(t, records.ProveNoData(name("a.example.com."), g53.RR_A) == false, "a has a")
+	ut.Assert(t, records.ProveInsecureDelegation(name("d.example.com.")), "d is insecure delegation")
+	ut.Assert(t, records.ProveInsecureDelegation(name("a.example.com.")) == false, "a isn't delegation")
+	ut.Assert(t, records.ProveWildcardExpansion(name("c.example.com."), 2), "c doesn't exist")
+}
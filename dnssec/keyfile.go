@@ -0,0 +1,92 @@
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	errUnknownAlgorithm = errors.New("unknown dnssec algorithm")
+	errKeyFileFormat    = errors.New("key file should be pem encoded pkcs8 private key")
+)
+
+var algorithmNames = map[string]uint8{
+	"RSASHA256":       RSASHA256,
+	"ECDSAP256SHA256": ECDSAP256SHA256,
+	"ECDSAP384SHA384": ECDSAP384SHA384,
+	"ED25519":         ED25519,
+}
+
+func AlgorithmFromString(s string) (uint8, error) {
+	if alg, ok := algorithmNames[strings.ToUpper(s)]; ok {
+		return alg, nil
+	}
+	return 0, errUnknownAlgorithm
+}
+
+func LoadKeyFile(file string, flags uint16) (*DNSKEY, crypto.Signer, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, nil, errKeyFileFormat
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, ok := key.(crypto.Signer)
+	if ok == false {
+		return nil, nil, errKeyFileFormat
+	}
+
+	alg, err := algorithmOfKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := PublicKeyBytes(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &DNSKEY{
+		Flags:     flags,
+		Protocol:  DNSKEY_PROTOCOL,
+		Algorithm: alg,
+		PublicKey: pub,
+	}, priv, nil
+}
+
+func WriteKeyFile(file string, priv crypto.Signer) error {
+	data, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}), 0600)
+}
+
+func algorithmOfKey(priv crypto.Signer) (uint8, error) {
+	switch pub := priv.Public().(type) {
+	case *rsa.PublicKey:
+		return RSASHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return ECDSAP256SHA256, nil
+		case 384:
+			return ECDSAP384SHA384, nil
+		}
+	case ed25519.PublicKey:
+		return ED25519, nil
+	}
+	return 0, ErrUnsupportedAlgorithm
+}
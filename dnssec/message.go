@@ -0,0 +1,108 @@
+package dnssec
+
+import (
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+)
+
+func MessageFromWire(buf *util.InputBuffer) (*g53.Message, error) {
+	//same as g53.MessageFromWire, but also accepts dnskey, nsec and
+	//rr types unknown to g53
+	m := &g53.Message{}
+	if err := g53.HeaderFromWire(&m.Header, buf); err != nil {
+		return nil, err
+	}
+
+	if m.Header.QDCount == 1 {
+		q, err := g53.QuestionFromWire(buf)
+		if err != nil {
+			return nil, err
+		}
+		m.Question = q
+	}
+
+	counts := []uint16{m.Header.ANCount, m.Header.NSCount, m.Header.ARCount}
+	for i, count := range counts {
+		section, err := sectionFromWire(buf, count)
+		if err != nil {
+			return nil, err
+		}
+
+		if g53.SectionType(i) == g53.AdditionalSection && len(section) > 0 {
+			last := section[len(section)-1]
+			if last.Type == g53.RR_OPT {
+				m.Edns = g53.EdnsFromRRset(last)
+				section = section[:len(section)-1]
+			} else if last.Type == g53.RR_TSIG {
+				m.Tsig = g53.TSIGFromRRset(last)
+				section = section[:len(section)-1]
+			}
+		}
+		m.Sections[i] = section
+	}
+	return m, nil
+}
+
+func sectionFromWire(buf *util.InputBuffer, count uint16) (g53.Section, error) {
+	var section g53.Section
+	var lastRRset *g53.RRset
+	for i := uint16(0); i < count; i++ {
+		rrset, err := rrsetFromWire(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastRRset != nil && lastRRset.IsSameRRset(rrset) && len(rrset.Rdatas) > 0 {
+			lastRRset.Rdatas = append(lastRRset.Rdatas, rrset.Rdatas[0])
+		} else {
+			if lastRRset != nil {
+				section = append(section, lastRRset)
+			}
+			lastRRset = rrset
+		}
+	}
+
+	if lastRRset != nil {
+		section = append(section, lastRRset)
+	}
+	return section, nil
+}
+
+func rrsetFromWire(buf *util.InputBuffer) (*g53.RRset, error) {
+	name, err := g53.NameFromWire(buf, false)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, err := g53.TypeFromWire(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	cls, err := g53.ClassFromWire(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := g53.TTLFromWire(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	rdata, err := RdataFromWire(typ, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var rdatas []g53.Rdata
+	if rdata != nil {
+		rdatas = []g53.Rdata{rdata}
+	}
+	return &g53.RRset{
+		Name:   name,
+		Type:   typ,
+		Class:  cls,
+		Ttl:    ttl,
+		Rdatas: rdatas,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package dnssec
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ben-han-cn/g53"
+)
+
+const (
+	NSEC3_HASH_SHA1   = 1
+	NSEC3_FLAG_OPTOUT = 0x01
+)
+
+func CompareName(n1, n2 *g53.Name) int {
+	//compare name in canonical order, rfc4034 6.1
+	return n1.Compare(n2, false).Order
+}
+
+func NSECCovers(owner *g53.Name, nsec *NSEC, name *g53.Name) bool {
+	//nsec rr covers name which is between owner and next name,
+	//the last nsec in zone loops back to zone apex
+	afterOwner := CompareName(name, owner) > 0
+	beforeNext := CompareName(name, nsec.NextName) < 0
+	if CompareName(owner, nsec.NextName) < 0 {
+		return afterOwner && beforeNext
+	}
+	return afterOwner || beforeNext
+}
+
+func NSEC3Hash(name *g53.Name, iterations uint16, salt []byte) string {
+	h := sha1.New()
+	h.Write(canonicalName(name))
+	h.Write(salt)
+	digest := h.Sum(nil)
+	for i := uint16(0); i < iterations; i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(salt)
+		digest = h.Sum(nil)
+	}
+	return base32.HexEncoding.EncodeToString(digest)
+}
+
+func NSEC3Salt(nsec3 *g53.NSEC3) []byte {
+	if nsec3.SaltLength == 0 {
+		return nil
+	}
+	salt, _ := hex.DecodeString(nsec3.Salt)
+	return salt
+}
+
+func NSEC3HashWithParam(name *g53.Name, nsec3 *g53.NSEC3) string {
+	//hash of the name with the nsec3 parameters, empty string for unsupported algorithm
+	if nsec3.Algorithm != NSEC3_HASH_SHA1 {
+		return ""
+	}
+	return NSEC3Hash(name, nsec3.Iterations, NSEC3Salt(nsec3))
+}
+
+func nsec3OwnerHash(owner *g53.Name) string {
+	label, err := owner.Split(0, 1)
+	if err != nil {
+		return ""
+	}
+	return strings.ToUpper(label.String(true))
+}
+
+func NSEC3Matches(owner *g53.Name, nsec3 *g53.NSEC3, name *g53.Name) bool {
+	hash := NSEC3HashWithParam(name, nsec3)
+	return hash != "" && hash == nsec3OwnerHash(owner)
+}
+
+func NSEC3Covers(owner *g53.Name, nsec3 *g53.NSEC3, name *g53.Name) bool {
+	hash := NSEC3HashWithParam(name, nsec3)
+	if hash == "" {
+		return false
+	}
+
+	ownerHash := nsec3OwnerHash(owner)
+	nextHash := strings.ToUpper(nsec3.NextHash)
+	if ownerHash < nextHash {
+		return hash > ownerHash && hash < nextHash
+	}
+	return hash > ownerHash || hash < nextHash
+}
+
+func NSEC3HasType(nsec3 *g53.NSEC3, typ g53.RRType) bool {
+	return hasType(nsec3.Types, typ)
+}
+
+func NSEC3IsOptOut(nsec3 *g53.NSEC3) bool {
+	return nsec3.Flags&NSEC3_FLAG_OPTOUT != 0
+}
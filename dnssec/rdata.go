@@ -0,0 +1,480 @@
+package dnssec
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+)
+
+var (
+	errRdataTooShort   = errors.New("rdata is too short")
+	errExtraRdata      = errors.New("extra data in rdata part")
+	errTypeBitmap      = errors.New("type bitmap format error")
+	errDNSKEYFormat    = errors.New("dnskey rdata format error")
+	errNSECFormat      = errors.New("nsec rdata format error")
+	errRRStringFormat  = errors.New("rr string format error")
+	errUnsupportedType = errors.New("unsupported rr type")
+)
+
+const (
+	DNSKEY_FLAG_ZONE = 0x0100
+	DNSKEY_FLAG_SEP  = 0x0001
+	DNSKEY_PROTOCOL  = 3
+)
+
+var g53KnownTypes = map[g53.RRType]bool{
+	//types which g53 could parse by itself
+	g53.RR_A:     true,
+	g53.RR_AAAA:  true,
+	g53.RR_CNAME: true,
+	g53.RR_SOA:   true,
+	g53.RR_NS:    true,
+	g53.RR_OPT:   true,
+	g53.RR_PTR:   true,
+	g53.RR_SRV:   true,
+	g53.RR_NAPTR: true,
+	g53.RR_DNAME: true,
+	g53.RR_RRSIG: true,
+	g53.RR_MX:    true,
+	g53.RR_TXT:   true,
+	g53.RR_RP:    true,
+	g53.RR_SPF:   true,
+	g53.RR_TSIG:  true,
+	g53.RR_NSEC3: true,
+	g53.RR_DS:    true,
+}
+
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+func (k *DNSKEY) Rend(r *g53.MsgRender) {
+	r.WriteUint16(k.Flags)
+	r.WriteUint8(k.Protocol)
+	r.WriteUint8(k.Algorithm)
+	r.WriteData(k.PublicKey)
+}
+
+func (k *DNSKEY) ToWire(buf *util.OutputBuffer) {
+	buf.WriteUint16(k.Flags)
+	buf.WriteUint8(k.Protocol)
+	buf.WriteUint8(k.Algorithm)
+	buf.WriteData(k.PublicKey)
+}
+
+func (k *DNSKEY) Compare(other g53.Rdata) int {
+	return bytes.Compare(rdataToWire(k), rdataToWire(other))
+}
+
+func (k *DNSKEY) String() string {
+	return fmt.Sprintf("%d %d %d %s", k.Flags, k.Protocol, k.Algorithm, base64.StdEncoding.EncodeToString(k.PublicKey))
+}
+
+func (k *DNSKEY) IsZoneKey() bool {
+	return k.Flags&DNSKEY_FLAG_ZONE != 0
+}
+
+func (k *DNSKEY) IsSEP() bool {
+	return k.Flags&DNSKEY_FLAG_SEP != 0
+}
+
+func DNSKEYFromWire(buf *util.InputBuffer, ll uint16) (*DNSKEY, error) {
+	if ll < 4 {
+		return nil, errRdataTooShort
+	}
+
+	flags, _ := buf.ReadUint16()
+	protocol, _ := buf.ReadUint8()
+	algorithm, _ := buf.ReadUint8()
+	key, err := buf.ReadBytes(uint(ll - 4))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DNSKEY{
+		Flags:     flags,
+		Protocol:  protocol,
+		Algorithm: algorithm,
+		PublicKey: append([]byte(nil), key...),
+	}, nil
+}
+
+var dnskeyRdataTemplate = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+(\d+)\s+(.+?)\s*$`)
+
+func DNSKEYFromString(s string) (*DNSKEY, error) {
+	fields := dnskeyRdataTemplate.FindStringSubmatch(s)
+	if len(fields) != 5 {
+		return nil, errDNSKEYFormat
+	}
+
+	flags, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := strconv.ParseUint(fields[3], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(fields[4]), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DNSKEY{
+		Flags:     uint16(flags),
+		Protocol:  uint8(protocol),
+		Algorithm: uint8(algorithm),
+		PublicKey: key,
+	}, nil
+}
+
+type NSEC struct {
+	NextName *g53.Name
+	Types    []g53.RRType
+}
+
+func (n *NSEC) Rend(r *g53.MsgRender) {
+	r.WriteName(n.NextName, false)
+	r.WriteData(typeBitmapToWire(n.Types))
+}
+
+func (n *NSEC) ToWire(buf *util.OutputBuffer) {
+	n.NextName.ToWire(buf)
+	buf.WriteData(typeBitmapToWire(n.Types))
+}
+
+func (n *NSEC) Compare(other g53.Rdata) int {
+	return bytes.Compare(rdataToWire(n), rdataToWire(other))
+}
+
+func (n *NSEC) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(n.NextName.String(false))
+	for _, typ := range n.Types {
+		buf.WriteString(" ")
+		buf.WriteString(typ.String())
+	}
+	return buf.String()
+}
+
+func (n *NSEC) HasType(typ g53.RRType) bool {
+	return hasType(n.Types, typ)
+}
+
+func NSECFromWire(buf *util.InputBuffer, ll uint16) (*NSEC, error) {
+	start := buf.Position()
+	next, err := g53.NameFromWire(buf, false)
+	if err != nil {
+		return nil, err
+	}
+
+	used := buf.Position() - start
+	if used > uint(ll) {
+		return nil, errRdataTooShort
+	}
+	bitmap, err := buf.ReadBytes(uint(ll) - used)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := typeBitmapFromWire(bitmap)
+	if err != nil {
+		return nil, err
+	}
+	return &NSEC{
+		NextName: next,
+		Types:    types,
+	}, nil
+}
+
+func NSECFromString(s string) (*NSEC, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errNSECFormat
+	}
+
+	next, err := g53.NameFromString(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var types []g53.RRType
+	for _, field := range fields[1:] {
+		typ, err := typeFromString(field)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	return &NSEC{
+		NextName: next,
+		Types:    sortTypes(types),
+	}, nil
+}
+
+type NSEC3 struct {
+	//g53.NSEC3 can't be rendered without types, which is
+	//required by nsec3 of empty non-terminal
+	g53.NSEC3
+}
+
+func (n *NSEC3) Rend(r *g53.MsgRender) {
+	r.WriteData(n.wire())
+}
+
+func (n *NSEC3) ToWire(buf *util.OutputBuffer) {
+	buf.WriteData(n.wire())
+}
+
+func (n *NSEC3) Compare(other g53.Rdata) int {
+	return bytes.Compare(n.wire(), rdataToWire(other))
+}
+
+func (n *NSEC3) String() string {
+	var buf bytes.Buffer
+	salt := "-"
+	if n.SaltLength > 0 {
+		salt = n.Salt
+	}
+	buf.WriteString(fmt.Sprintf("%d %d %d %s %s", n.Algorithm, n.Flags, n.Iterations, salt, n.NextHash))
+	for _, typ := range n.Types {
+		buf.WriteString(" ")
+		buf.WriteString(typ.String())
+	}
+	return buf.String()
+}
+
+func (n *NSEC3) wire() []byte {
+	salt := NSEC3Salt(&n.NSEC3)
+	next, _ := base32.HexEncoding.DecodeString(strings.ToUpper(n.NextHash))
+	data := []byte{n.Algorithm, n.Flags, byte(n.Iterations >> 8), byte(n.Iterations), byte(len(salt))}
+	data = append(data, salt...)
+	data = append(data, byte(len(next)))
+	data = append(data, next...)
+	return append(data, typeBitmapToWire(n.Types)...)
+}
+
+func wrapNSEC3(rdata g53.Rdata) g53.Rdata {
+	if nsec3, ok := rdata.(*g53.NSEC3); ok {
+		return &NSEC3{NSEC3: *nsec3}
+	}
+	return rdata
+}
+
+type UnknownRdata struct {
+	Data []byte
+}
+
+func (u *UnknownRdata) Rend(r *g53.MsgRender) {
+	r.WriteData(u.Data)
+}
+
+func (u *UnknownRdata) ToWire(buf *util.OutputBuffer) {
+	buf.WriteData(u.Data)
+}
+
+func (u *UnknownRdata) Compare(other g53.Rdata) int {
+	return bytes.Compare(u.Data, rdataToWire(other))
+}
+
+func (u *UnknownRdata) String() string {
+	return fmt.Sprintf("\\# %d %s", len(u.Data), hex.EncodeToString(u.Data))
+}
+
+func RdataFromWire(typ g53.RRType, buf *util.InputBuffer) (g53.Rdata, error) {
+	if g53KnownTypes[typ] {
+		rdata, err := g53.RdataFromWire(typ, buf)
+		if err != nil || rdata == nil {
+			return nil, err
+		}
+		return wrapNSEC3(rdata), nil
+	}
+
+	ll, err := buf.ReadUint16()
+	if err != nil {
+		return nil, err
+	} else if ll == 0 {
+		return nil, nil
+	}
+
+	start := buf.Position()
+	var rdata g53.Rdata
+	switch typ {
+	case g53.RR_DNSKEY:
+		rdata, err = DNSKEYFromWire(buf, ll)
+	case g53.RR_NSEC:
+		rdata, err = NSECFromWire(buf, ll)
+	default:
+		var data []byte
+		if data, err = buf.ReadBytes(uint(ll)); err == nil {
+			rdata = &UnknownRdata{Data: append([]byte(nil), data...)}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	} else if buf.Position()-start != uint(ll) {
+		return nil, errExtraRdata
+	}
+	return rdata, nil
+}
+
+func RdataFromString(typ g53.RRType, s string) (g53.Rdata, error) {
+	switch typ {
+	case g53.RR_DNSKEY:
+		return DNSKEYFromString(s)
+	case g53.RR_NSEC:
+		return NSECFromString(s)
+	default:
+		if g53KnownTypes[typ] {
+			rdata, err := g53.RdataFromString(typ, s)
+			return wrapNSEC3(rdata), err
+		}
+		return nil, errUnsupportedType
+	}
+}
+
+func RRsetFromString(s string) (*g53.RRset, error) {
+	//rr string in format "name [ttl] [class] type rdata", ttl and class are optional
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return nil, errRRStringFormat
+	}
+
+	name, err := g53.NameFromString(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	rrset := &g53.RRset{
+		Name:  name,
+		Class: g53.CLASS_IN,
+	}
+	i := 1
+	if ttl, err := strconv.ParseUint(fields[i], 10, 32); err == nil {
+		rrset.Ttl = g53.RRTTL(ttl)
+		i += 1
+	}
+	if i < len(fields) && strings.EqualFold(fields[i], "IN") {
+		i += 1
+	}
+	if i+1 >= len(fields) {
+		return nil, errRRStringFormat
+	}
+
+	if rrset.Type, err = typeFromString(fields[i]); err != nil {
+		return nil, err
+	}
+
+	rdata, err := RdataFromString(rrset.Type, strings.Join(fields[i+1:], " "))
+	if err != nil {
+		return nil, err
+	}
+	rrset.Rdatas = []g53.Rdata{rdata}
+	return rrset, nil
+}
+
+func typeFromString(s string) (g53.RRType, error) {
+	//support generic type name like TYPE65534, rfc3597
+	if len(s) > 4 && strings.EqualFold(s[:4], "TYPE") {
+		if typ, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return g53.RRType(typ), nil
+		}
+	}
+	return g53.TypeFromString(s)
+}
+
+func rdataToWire(rdata g53.Rdata) []byte {
+	buf := util.NewOutputBuffer(64)
+	rdata.ToWire(buf)
+	return buf.Data()
+}
+
+func hasType(types []g53.RRType, typ g53.RRType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func sortTypes(types []g53.RRType) []g53.RRType {
+	for i := 1; i < len(types); i++ {
+		for j := i; j > 0 && types[j] < types[j-1]; j-- {
+			types[j], types[j-1] = types[j-1], types[j]
+		}
+	}
+	return types
+}
+
+func typeBitmapToWire(types []g53.RRType) []byte {
+	var bitmap []byte
+	var window [32]byte
+	currentWindow, windowLen := -1, 0
+	flush := func() {
+		if windowLen > 0 {
+			bitmap = append(bitmap, byte(currentWindow), byte(windowLen))
+			bitmap = append(bitmap, window[:windowLen]...)
+		}
+		window = [32]byte{}
+		windowLen = 0
+	}
+
+	for _, typ := range sortTypes(append([]g53.RRType(nil), types...)) {
+		w := int(typ >> 8)
+		if w != currentWindow {
+			flush()
+			currentWindow = w
+		}
+		offset := int(typ&0xff) / 8
+		window[offset] |= 0x80 >> (uint(typ) % 8)
+		if offset+1 > windowLen {
+			windowLen = offset + 1
+		}
+	}
+	flush()
+	return bitmap
+}
+
+func typeBitmapFromWire(bitmap []byte) ([]g53.RRType, error) {
+	var types []g53.RRType
+	lastWindow := -1
+	for len(bitmap) > 0 {
+		if len(bitmap) < 2 {
+			return nil, errTypeBitmap
+		}
+
+		window, length := int(bitmap[0]), int(bitmap[1])
+		if window <= lastWindow || length == 0 || length > 32 || len(bitmap) < length+2 {
+			return nil, errTypeBitmap
+		}
+
+		for i, b := range bitmap[2 : length+2] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>uint(bit)) != 0 {
+					types = append(types, g53.RRType(window*256+i*8+bit))
+				}
+			}
+		}
+		bitmap = bitmap[length+2:]
+		lastWindow = window
+	}
+	return types, nil
+}
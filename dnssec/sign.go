@@ -0,0 +1,141 @@
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+)
+
+const defaultRSAKeySize = 2048
+
+func GenerateKey(alg uint8, flags uint16) (*DNSKEY, crypto.Signer, error) {
+	var priv crypto.Signer
+	var pub []byte
+	switch alg {
+	case RSASHA256:
+		key, err := rsa.GenerateKey(rand.Reader, defaultRSAKeySize)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv, pub = key, rsaPublicKeyBytes(&key.PublicKey)
+	case ECDSAP256SHA256, ECDSAP384SHA384:
+		curve := elliptic.P256()
+		if alg == ECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv, pub = key, ecdsaPublicKeyBytes(&key.PublicKey)
+	case ED25519:
+		pubKey, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv, pub = key, []byte(pubKey)
+	default:
+		return nil, nil, ErrUnsupportedAlgorithm
+	}
+
+	return &DNSKEY{
+		Flags:     flags,
+		Protocol:  DNSKEY_PROTOCOL,
+		Algorithm: alg,
+		PublicKey: pub,
+	}, priv, nil
+}
+
+func PublicKeyBytes(priv crypto.Signer) ([]byte, error) {
+	//public part of the private key in dnskey format
+	switch pub := priv.Public().(type) {
+	case *rsa.PublicKey:
+		return rsaPublicKeyBytes(pub), nil
+	case *ecdsa.PublicKey:
+		return ecdsaPublicKeyBytes(pub), nil
+	case ed25519.PublicKey:
+		return []byte(pub), nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func Sign(rrset *g53.RRset, signer *g53.Name, key *DNSKEY, priv crypto.Signer, inception, expiration time.Time) (*g53.RRSig, error) {
+	labels := rrset.Name.LabelCount() - 1
+	if rrset.Name.IsWildCard() {
+		labels -= 1
+	}
+
+	sig := &g53.RRSig{
+		Covered:     rrset.Type,
+		Algorithm:   key.Algorithm,
+		Labels:      uint8(labels),
+		OriginalTtl: uint32(rrset.Ttl),
+		SigExpire:   uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		Tag:         KeyTag(key),
+		Signer:      signer,
+	}
+	data, err := signedData(rrset, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.Algorithm {
+	case RSASHA256:
+		digest := sha256.Sum256(data)
+		sig.Signature, err = priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ECDSAP256SHA256, ECDSAP384SHA384:
+		ecKey, ok := priv.(*ecdsa.PrivateKey)
+		if ok == false {
+			return nil, ErrKeyMismatch
+		}
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, ecKey, ecdsaDigest(key.Algorithm, data)); err == nil {
+			size := (ecKey.Curve.Params().BitSize + 7) / 8
+			sig.Signature = append(paddedBytes(r, size), paddedBytes(s, size)...)
+		}
+	case ED25519:
+		sig.Signature, err = priv.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		err = ErrUnsupportedAlgorithm
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func rsaPublicKeyBytes(pub *rsa.PublicKey) []byte {
+	exp := big.NewInt(int64(pub.E)).Bytes()
+	var data []byte
+	if len(exp) < 256 {
+		data = append(data, byte(len(exp)))
+	} else {
+		data = append(data, 0, byte(len(exp)>>8), byte(len(exp)))
+	}
+	data = append(data, exp...)
+	return append(data, pub.N.Bytes()...)
+}
+
+func ecdsaPublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	return append(paddedBytes(pub.X, size), paddedBytes(pub.Y, size)...)
+}
+
+func paddedBytes(n *big.Int, size int) []byte {
+	data := n.Bytes()
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
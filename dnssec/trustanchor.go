@@ -0,0 +1,120 @@
+package dnssec
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ben-han-cn/g53"
+)
+
+var errInvalidTrustAnchor = errors.New("trust anchor should be ds or dnskey")
+
+var defaultTrustAnchors = []string{
+	//root key signing keys published by iana
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+type TrustAnchor struct {
+	Zone *g53.Name
+	DS   []*g53.DS
+	Keys []*DNSKEY
+}
+
+type TrustAnchors map[string]*TrustAnchor
+
+func DefaultTrustAnchors() TrustAnchors {
+	anchors, err := TrustAnchorsFromString(strings.Join(defaultTrustAnchors, "\n"))
+	if err != nil {
+		panic("load default trust anchor failed:" + err.Error())
+	}
+	return anchors
+}
+
+func LoadTrustAnchors(file string) (TrustAnchors, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return TrustAnchorsFromString(string(content))
+}
+
+func TrustAnchorsFromString(content string) (TrustAnchors, error) {
+	anchors := make(TrustAnchors)
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.IndexAny(line, ";#"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		rrset, err := RRsetFromString(line)
+		if err != nil {
+			return nil, err
+		}
+		if err := anchors.add(rrset); err != nil {
+			return nil, err
+		}
+	}
+	return anchors, nil
+}
+
+func (anchors TrustAnchors) add(rrset *g53.RRset) error {
+	key := anchorKey(rrset.Name)
+	anchor, ok := anchors[key]
+	if ok == false {
+		anchor = &TrustAnchor{Zone: rrset.Name}
+		anchors[key] = anchor
+	}
+
+	switch rdata := rrset.Rdatas[0].(type) {
+	case *g53.DS:
+		anchor.DS = append(anchor.DS, rdata)
+	case *DNSKEY:
+		anchor.Keys = append(anchor.Keys, rdata)
+	default:
+		return errInvalidTrustAnchor
+	}
+	return nil
+}
+
+func (anchors TrustAnchors) Get(zone *g53.Name) *TrustAnchor {
+	return anchors[anchorKey(zone)]
+}
+
+func (anchors TrustAnchors) ClosestAnchor(name *g53.Name) *TrustAnchor {
+	//trust anchor which is closest to the name
+	for i := uint(0); i < name.LabelCount(); i++ {
+		parent, err := name.Parent(i)
+		if err != nil {
+			break
+		}
+		if anchor := anchors.Get(parent); anchor != nil {
+			return anchor
+		}
+	}
+	return nil
+}
+
+func (anchor *TrustAnchor) Trust(key *DNSKEY) bool {
+	//key of the anchor is trusted by itself or matches anchor ds
+	for _, k := range anchor.Keys {
+		if k.Compare(key) == 0 {
+			return true
+		}
+	}
+
+	for _, ds := range anchor.DS {
+		if MatchDS(anchor.Zone, key, ds) {
+			return true
+		}
+	}
+	return false
+}
+
+func anchorKey(name *g53.Name) string {
+	return strings.ToLower(name.String(false))
+}
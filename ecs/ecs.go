@@ -0,0 +1,141 @@
+package ecs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/ben-han-cn/g53"
+)
+
+const (
+	FamilyIPv4 = 1
+	FamilyIPv6 = 2
+
+	DefaultV4SourcePrefix = 24
+	DefaultV6SourcePrefix = 56
+)
+
+type ClientSubnet struct { //edns client subnet option defined in rfc7871
+	Family       uint16
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	IP           net.IP
+}
+
+func NewClientSubnet(ip net.IP, prefix uint8) *ClientSubnet {
+	subnet := &ClientSubnet{Family: FamilyIPv6}
+	if ip4 := ip.To4(); ip4 != nil {
+		subnet.Family = FamilyIPv4
+		ip = ip4
+	} else {
+		ip = ip.To16()
+	}
+
+	if int(prefix) > len(ip)*8 {
+		prefix = uint8(len(ip) * 8)
+	}
+	subnet.SourcePrefix = prefix
+	subnet.IP = subnet.Mask(ip, prefix)
+	return subnet
+}
+
+func (s *ClientSubnet) AddrLen() int {
+	if s.Family == FamilyIPv4 {
+		return net.IPv4len
+	} else {
+		return net.IPv6len
+	}
+}
+
+func (s *ClientSubnet) Mask(ip net.IP, prefix uint8) net.IP {
+	if s.Family == FamilyIPv4 {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	if ip == nil {
+		return nil
+	}
+	return ip.Mask(net.CIDRMask(int(prefix), s.AddrLen()*8))
+}
+
+func (s *ClientSubnet) Contains(ip net.IP) bool {
+	//whether ip is in the network this option applies to
+	masked := s.Mask(ip, s.ScopePrefix)
+	return masked != nil && masked.Equal(s.Mask(s.IP, s.ScopePrefix))
+}
+
+func (s *ClientSubnet) Rend(render *g53.MsgRender) {
+	addrLen := int(s.SourcePrefix+7) / 8
+	render.WriteUint16(g53.EDNS_SUBNET)
+	render.WriteUint16(uint16(4 + addrLen))
+	render.WriteUint16(s.Family)
+	render.WriteUint8(s.SourcePrefix)
+	render.WriteUint8(s.ScopePrefix)
+	render.WriteData([]byte(s.Mask(s.IP, s.SourcePrefix))[:addrLen])
+}
+
+func (s *ClientSubnet) String() string {
+	return fmt.Sprintf("; CLIENT-SUBNET: %s/%d/%d\n", s.IP.String(), s.SourcePrefix, s.ScopePrefix)
+}
+
+func FromEdns(edns *g53.EDNS) *ClientSubnet {
+	if edns == nil {
+		return nil
+	}
+
+	for _, opt := range edns.Options {
+		switch o := opt.(type) {
+		case *ClientSubnet:
+			return o
+		case *g53.SubnetOpt:
+			//g53 doesn't export the fields of subnet option, read
+			//them from its wire format
+			render := g53.NewMsgRender()
+			o.Rend(render)
+			return fromWire(render.Data())
+		}
+	}
+	return nil
+}
+
+func fromWire(data []byte) *ClientSubnet {
+	//option code and length are followed by family, source prefix,
+	//scope prefix and address
+	if len(data) < 8 {
+		return nil
+	}
+
+	family := binary.BigEndian.Uint16(data[4:])
+	if family != FamilyIPv4 && family != FamilyIPv6 {
+		return nil
+	}
+	subnet := &ClientSubnet{Family: family}
+	source, scope, addr := data[6], data[7], data[8:]
+	if len(addr) > subnet.AddrLen() || int(source) > subnet.AddrLen()*8 {
+		return nil
+	}
+
+	ip := make(net.IP, subnet.AddrLen())
+	copy(ip, addr)
+	subnet = NewClientSubnet(ip, source)
+	subnet.ScopePrefix = scope
+	return subnet
+}
+
+func SetEdns(edns *g53.EDNS, subnet *ClientSubnet) {
+	//replace the subnet option in edns, nil subnet just removes it
+	opts := make([]g53.Option, 0, len(edns.Options)+1)
+	for _, opt := range edns.Options {
+		switch opt.(type) {
+		case *ClientSubnet, *g53.SubnetOpt:
+		default:
+			opts = append(opts, opt)
+		}
+	}
+	if subnet != nil {
+		opts = append(opts, subnet)
+	}
+	edns.Options = opts
+}
@@ -0,0 +1,105 @@
+package ecs
+
+import (
+	"net"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/core"
+)
+
+func TestClientSubnetWire(t *testing.T) {
+	request := g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, false)
+	SetEdns(request.Edns, NewClientSubnet(net.ParseIP("10.1.2.3"), 20))
+	request.RecalculateSectionRRCount()
+
+	render := g53.NewMsgRender()
+	request.Rend(render)
+	parsed, err := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "parse request failed %v", err)
+
+	subnet := FromEdns(parsed.Edns)
+	ut.Assert(t, subnet != nil, "subnet option should be parsed")
+	ut.Equal(t, subnet.Family, uint16(FamilyIPv4))
+	ut.Equal(t, subnet.SourcePrefix, uint8(20))
+	ut.Equal(t, subnet.IP.String(), "10.1.0.0")
+
+	response := g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, false)
+	response.Header.SetFlag(g53.FLAG_QR, true)
+	v6Subnet := NewClientSubnet(net.ParseIP("2001:db8:1:2::1"), 56)
+	v6Subnet.ScopePrefix = 48
+	SetEdns(response.Edns, v6Subnet)
+	render.Clear()
+	response.Rend(render)
+	parsed, err = g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "parse response failed %v", err)
+	subnet = FromEdns(parsed.Edns)
+	ut.Assert(t, subnet != nil, "subnet option should be parsed")
+	ut.Equal(t, subnet.Family, uint16(FamilyIPv6))
+	ut.Equal(t, subnet.SourcePrefix, uint8(56))
+	ut.Equal(t, subnet.ScopePrefix, uint8(48))
+	ut.Equal(t, subnet.IP.String(), "2001:db8:1::")
+	ut.Assert(t, fromWire([]byte{0, 8, 0, 4, 0, 3, 24, 0}) == nil, "unknown family should be rejected")
+
+	v6 := NewClientSubnet(net.ParseIP("2001:db8:1:2::1"), 200)
+	ut.Equal(t, v6.SourcePrefix, uint8(128))
+	scoped := NewClientSubnet(v6.IP, 48)
+	scoped.ScopePrefix = 32
+	ut.Assert(t, scoped.Contains(net.ParseIP("2001:db8:ffff::1")), "address in scope")
+	ut.Assert(t, scoped.Contains(net.ParseIP("2001:db9::1")) == false, "address out of scope")
+}
+
+func TestSourcePrefixLimit(t *testing.T) {
+	prefixes := NewSourcePrefixes(&config.VanguardConf{
+		Recursor: []config.RecursorInView{{View: "default", EdnsSubnetEnable: true, SubnetV4Prefix: 16}},
+	})
+	p := prefixes.Get("default")
+	ut.Equal(t, p.V6, uint8(DefaultV6SourcePrefix))
+
+	var client core.Client
+	client.Addr, _ = net.ResolveUDPAddr("udp", "10.1.2.3:5555")
+	client.Request = g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, false)
+	subnet := p.ClientSubnet(&client)
+	ut.Equal(t, subnet.IP.String(), "10.1.0.0")
+	ut.Equal(t, subnet.SourcePrefix, uint8(16))
+
+	SetEdns(client.Request.Edns, NewClientSubnet(net.ParseIP("192.168.10.1"), 32))
+	subnet = p.ClientSubnet(&client)
+	ut.Equal(t, subnet.IP.String(), "192.168.0.0")
+	ut.Equal(t, subnet.SourcePrefix, uint8(16))
+
+	SetEdns(client.Request.Edns, NewClientSubnet(net.ParseIP("192.168.10.1"), 0))
+	ut.Assert(t, p.ClientSubnet(&client) == nil, "client opts out with source prefix 0")
+
+	client.Request.Edns.Options = nil
+	ut.Assert(t, prefixes.Get("v1").ClientSubnet(&client) == nil, "subnet is disabled by default")
+}
+
+func TestSetResponseSubnet(t *testing.T) {
+	request := g53.MakeQuery(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, 4096, false)
+	sent := NewClientSubnet(net.ParseIP("10.1.2.3"), 24)
+	got := NewClientSubnet(net.ParseIP("10.1.2.3"), 24)
+	got.ScopePrefix = 32
+
+	response := request.MakeResponse()
+	response.Edns = &g53.EDNS{UdpSize: 4096, Options: []g53.Option{got}}
+	SetResponseSubnet(response, nil, sent)
+	scoped := FromEdns(response.Edns)
+	ut.Equal(t, scoped.ScopePrefix, uint8(24))
+	ut.Equal(t, got.ScopePrefix, uint8(32))
+
+	clientSubnet := NewClientSubnet(net.ParseIP("10.1.2.3"), 32)
+	Echo(response, clientSubnet)
+	echo := FromEdns(response.Edns)
+	ut.Equal(t, echo.SourcePrefix, uint8(32))
+	ut.Equal(t, echo.ScopePrefix, uint8(24))
+
+	response = request.MakeResponse()
+	SetResponseSubnet(response, nil, sent)
+	ut.Assert(t, response.Edns == nil, "no option means scope 0")
+	SetResponseSubnet(response, clientSubnet, sent)
+	ut.Equal(t, FromEdns(response.Edns).ScopePrefix, uint8(0))
+}
@@ -0,0 +1,142 @@
+package ecs
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/core"
+)
+
+const defaultUdpSize = 4096
+
+type SourcePrefix struct {
+	Enable bool
+	V4     uint8
+	V6     uint8
+}
+
+var defaultSourcePrefix = SourcePrefix{
+	V4: DefaultV4SourcePrefix,
+	V6: DefaultV6SourcePrefix,
+}
+
+type SourcePrefixes map[string]SourcePrefix
+
+func NewSourcePrefixes(conf *config.VanguardConf) SourcePrefixes {
+	prefixes := make(SourcePrefixes)
+	for _, c := range conf.Recursor {
+		p := SourcePrefix{
+			Enable: c.EdnsSubnetEnable,
+			V4:     c.SubnetV4Prefix,
+			V6:     c.SubnetV6Prefix,
+		}
+		if p.V4 == 0 || p.V4 > 32 {
+			p.V4 = DefaultV4SourcePrefix
+		}
+		if p.V6 == 0 || p.V6 > 128 {
+			p.V6 = DefaultV6SourcePrefix
+		}
+		prefixes[c.View] = p
+	}
+	return prefixes
+}
+
+func (ps SourcePrefixes) Get(view string) SourcePrefix {
+	if p, ok := ps[view]; ok {
+		return p
+	} else {
+		return defaultSourcePrefix
+	}
+}
+
+func (p SourcePrefix) limit(family uint16) uint8 {
+	if family == FamilyIPv4 {
+		return p.V4
+	} else {
+		return p.V6
+	}
+}
+
+func (p SourcePrefix) ClientSubnet(client *core.Client) *ClientSubnet {
+	//subnet sent to upstream on behalf of the client, the option supplied
+	//by client is truncated to the limit, source prefix 0 means the client
+	//doesn't want its address revealed, rfc7871 7.1.2
+	if subnet := FromEdns(client.Request.Edns); subnet != nil {
+		if subnet.SourcePrefix == 0 {
+			return nil
+		}
+		prefix := subnet.SourcePrefix
+		if limit := p.limit(subnet.Family); prefix > limit {
+			prefix = limit
+		}
+		return NewClientSubnet(subnet.IP, prefix)
+	}
+
+	if p.Enable == false || client.Addr == nil {
+		return nil
+	}
+	ip := client.IP()
+	if ip.To4() != nil {
+		return NewClientSubnet(ip, p.V4)
+	} else {
+		return NewClientSubnet(ip, p.V6)
+	}
+}
+
+func (s *ClientSubnet) Hash() uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	binary.BigEndian.PutUint16(buf[:], s.Family)
+	buf[2] = s.SourcePrefix
+	buf[3] = s.ScopePrefix
+	h.Write(buf[:])
+	h.Write(s.Mask(s.IP, s.SourcePrefix))
+	return h.Sum64()
+}
+
+func (s *ClientSubnet) withScope(scope uint8) *ClientSubnet {
+	subnet := *s
+	if scope > subnet.SourcePrefix {
+		scope = subnet.SourcePrefix
+	}
+	subnet.ScopePrefix = scope
+	return &subnet
+}
+
+func SetResponseSubnet(response *g53.Message, clientSubnet, sent *ClientSubnet) {
+	//rfc7871 7.3, scope only makes sense for the subnet sent out and never
+	//exceeds its source prefix, response without option has scope 0
+	var scoped *ClientSubnet
+	if got := FromEdns(response.Edns); got != nil && sent != nil && got.Family == sent.Family && got.ScopePrefix > 0 {
+		scoped = sent.withScope(got.ScopePrefix)
+	}
+	setResponseOption(response, clientSubnet, scoped)
+}
+
+func Echo(response *g53.Message, clientSubnet *ClientSubnet) {
+	//response shared between clients carries the option of the first one
+	setResponseOption(response, clientSubnet, FromEdns(response.Edns))
+}
+
+func setResponseOption(response *g53.Message, clientSubnet, scoped *ClientSubnet) {
+	opt := scoped
+	if clientSubnet != nil {
+		opt = clientSubnet.withScope(0)
+		if scoped != nil && scoped.Family == clientSubnet.Family {
+			opt = clientSubnet.withScope(scoped.ScopePrefix)
+		}
+	}
+
+	if response.Edns == nil {
+		if opt == nil {
+			return
+		}
+		response.Edns = &g53.EDNS{UdpSize: defaultUdpSize}
+	} else {
+		edns := *response.Edns
+		response.Edns = &edns
+	}
+	SetEdns(response.Edns, opt)
+}
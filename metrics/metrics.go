@@ -17,6 +17,11 @@ const (
 	TotalView   = "any"
 )
 
+const (
+	EvictBySize   = "size"
+	EvictByMemory = "memory"
+)
+
 var gMetrics *Metrics
 
 type Metrics struct {
@@ -37,6 +42,8 @@ func NewMetrics(conf *config.VanguardConf) *Metrics {
 	gMetrics.reg.MustRegister(QPS)
 	gMetrics.reg.MustRegister(CacheSize)
 	gMetrics.reg.MustRegister(CacheHits)
+	gMetrics.reg.MustRegister(CachePrefetches)
+	gMetrics.reg.MustRegister(CacheEvictions)
 
 	gMetrics.reg.MustRegister(RequestCountByView)
 	gMetrics.reg.MustRegister(ResponseCountByView)
@@ -44,6 +51,11 @@ func NewMetrics(conf *config.VanguardConf) *Metrics {
 	gMetrics.reg.MustRegister(QPSByView)
 	gMetrics.reg.MustRegister(CacheSizeByView)
 	gMetrics.reg.MustRegister(CacheHitsByView)
+	gMetrics.reg.MustRegister(CachePrefetchesByView)
+	gMetrics.reg.MustRegister(CacheEvictionsByView)
+	gMetrics.reg.MustRegister(CacheMemory)
+	gMetrics.reg.MustRegister(RecursorInflight)
+	gMetrics.reg.MustRegister(RecursorRejects)
 
 	gMetrics.ReloadConfig(conf)
 	return gMetrics
@@ -127,7 +139,29 @@ func RecordCacheHit(view string) {
 	CacheHitsByView.WithLabelValues("cache", view).Inc()
 }
 
+func RecordCachePrefetch(view string) {
+	CachePrefetches.WithLabelValues("cache").Inc()
+	CachePrefetchesByView.WithLabelValues("cache", view).Inc()
+}
+
+func RecordCacheEviction(view string, reason string) {
+	CacheEvictions.WithLabelValues("cache", reason).Inc()
+	CacheEvictionsByView.WithLabelValues("cache", view, reason).Inc()
+}
+
+func RecordCacheMemory(view string, size int64) {
+	CacheMemory.WithLabelValues("cache", view).Set(float64(size))
+}
+
 func RecordCacheSize(view string, size int, totalSize int) {
 	CacheSize.WithLabelValues("cache").Set(float64(totalSize))
 	CacheSizeByView.WithLabelValues("cache", view).Set(float64(size))
 }
+
+func RecordRecursorInflight(count int) {
+	RecursorInflight.WithLabelValues("recursor").Set(float64(count))
+}
+
+func RecordRecursorReject() {
+	RecursorRejects.WithLabelValues("recursor").Inc()
+}
@@ -94,4 +94,53 @@ var (
 		Name:      "cache_hits_by_view",
 		Help:      "The count of cache hits per view.",
 	}, []string{"module", "view"})
+
+	CachePrefetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cache_prefetches_total",
+		Help:      "The count of cache entries refreshed before expire all views.",
+	}, []string{"module"})
+
+	CachePrefetchesByView = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cache_prefetches_by_view",
+		Help:      "The count of cache entries refreshed before expire per view.",
+	}, []string{"module", "view"})
+
+	CacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cache_evictions_total",
+		Help:      "The count of cache entries evicted all views.",
+	}, []string{"module", "reason"})
+
+	CacheEvictionsByView = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cache_evictions_by_view",
+		Help:      "The count of cache entries evicted per view.",
+	}, []string{"module", "view", "reason"})
+
+	CacheMemory = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cache_memory_by_view",
+		Help:      "The estimated bytes used by the cache per view.",
+	}, []string{"module", "view"})
+
+	RecursorInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "recursor_inflight_query",
+		Help:      "The count of recursive queries in progress.",
+	}, []string{"module"})
+
+	RecursorRejects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "recursor_rejects_total",
+		Help:      "Counter of recursive queries rejected by the inflight limit.",
+	}, []string{"module"})
 )
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/ben-han-cn/cement/domaintree"
 	"github.com/ben-han-cn/g53"
@@ -17,20 +18,36 @@ import (
 	view "github.com/ben-han-cn/vanguard/viewselector"
 )
 
+const journalCompactInterval = 10 * time.Minute
+
+type ZoneCommitHandler func(view string, z zone.Zone)
+
 type AuthDataSource struct {
 	chain.DefaultResolver
-	viewZones map[string]*domaintree.DomainTree
-	lock      sync.RWMutex
+	viewZones     map[string]*domaintree.DomainTree
+	lock          sync.RWMutex
+	commitHandler ZoneCommitHandler
+	stopCh        chan struct{}
 }
 
 func NewAuth(conf *config.VanguardConf) *AuthDataSource {
-	ds := &AuthDataSource{}
+	ds := &AuthDataSource{
+		stopCh: make(chan struct{}),
+	}
 	ds.ReloadConfig(conf)
 	httpcmd.RegisterHandler(ds, []httpcmd.Command{&AddAuthZone{}, &DeleteAuthZone{}, &UpdateAuthZone{}, &AddAuthRrs{}, &DeleteAuthRrs{}, &UpdateAuthRrs{}})
 	return ds
 }
 
 func (ds *AuthDataSource) ReloadConfig(conf *config.VanguardConf) {
+	ds.stopJournalCompact()
+	//flush changes of current zones, so new zones are loaded with them
+	ds.ForEachZone(func(_ string, z zone.Zone) {
+		if err := z.Compact(); err != nil {
+			logger.GetLogger().Error("compact zone %s failed: %s", z.GetOrigin().String(false), err.Error())
+		}
+	})
+
 	viewZones := make(map[string]*domaintree.DomainTree)
 	for view, _ := range view.GetViewAndIds() {
 		viewZones[view] = domaintree.NewDomainTree()
@@ -49,17 +66,33 @@ func (ds *AuthDataSource) ReloadConfig(conf *config.VanguardConf) {
 				zoneData = loadZoneFromMaster(origin, viewAuth.View, z.Masters)
 				zoneData.SetMasters(z.Masters)
 			} else {
-				f, err := os.OpenFile(z.File, os.O_RDONLY, 0755)
+				zoneData, err = loadZoneFile(origin, z.File)
 				if err != nil {
-					panic("open zone file " + z.File + " failed " + err.Error())
+					panic("read zone file " + z.File + " failed " + err.Error())
 				}
-				defer f.Close()
-				content, err := ioutil.ReadAll(f)
+			}
+
+			if z.File != "" {
+				if err := zoneData.SetPersistFile(z.File); err != nil {
+					panic("persist zone " + z.Name + " to " + z.File + " failed:" + err.Error())
+				}
+			}
+			zoneData.SetAcls(z.AllowUpdate)
+			updateKeys, err := parseUpdateKeys(z.AllowUpdateKeys)
+			if err != nil {
+				panic("load update keys of zone " + z.Name + " failed:" + err.Error())
+			}
+			zoneData.SetUpdateKeys(updateKeys)
+			zoneData.SetTransferAcls(z.AllowTransfer)
+			zoneData.SetNotifies(z.Notify)
+			if z.Dnssec != nil {
+				signConf, err := loadSignConf(origin, z.Dnssec)
 				if err != nil {
-					panic("read zone file " + z.File + " failed " + err.Error())
+					panic("load dnssec key of zone " + z.Name + " failed:" + err.Error())
 				}
-				zoneData = loadZone(origin, string(content))
+				zoneData.SetSignConf(signConf)
 			}
+			ds.watchZone(viewAuth.View, zoneData)
 
 			if _, err := tree.Insert(origin, zoneData); err != nil {
 				panic("load auth zone " + z.Name + " failed:" + err.Error())
@@ -69,7 +102,92 @@ func (ds *AuthDataSource) ReloadConfig(conf *config.VanguardConf) {
 		}
 	}
 
+	ds.lock.Lock()
+	oldViewZones := ds.viewZones
 	ds.viewZones = viewZones
+	ds.lock.Unlock()
+
+	//new zones are serving, changes to old ones shouldn't be persisted
+	for _, zones := range oldViewZones {
+		zones.ForEach(func(data interface{}) {
+			data.(zone.Zone).SetPersistFile("")
+		})
+	}
+	go ds.compactJournals(ds.stopCh)
+}
+
+func loadZoneFile(origin *g53.Name, file string) (zone.Zone, error) {
+	f, err := os.OpenFile(file, os.O_RDONLY, 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return loadZone(origin, string(content)), nil
+}
+
+func parseUpdateKeys(keys []string) ([]*g53.Name, error) {
+	names := make([]*g53.Name, 0, len(keys))
+	for _, key := range keys {
+		name, err := g53.NameFromString(key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (ds *AuthDataSource) ForEachZone(f func(string, zone.Zone)) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	for view, zones := range ds.viewZones {
+		zones.ForEach(func(data interface{}) {
+			f(view, data.(zone.Zone))
+		})
+	}
+}
+
+func (ds *AuthDataSource) stopJournalCompact() {
+	close(ds.stopCh)
+	ds.stopCh = make(chan struct{})
+}
+
+func (ds *AuthDataSource) compactJournals(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(journalCompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		ds.ForEachZone(func(_ string, z zone.Zone) {
+			if err := z.Compact(); err != nil {
+				logger.GetLogger().Error("compact zone %s failed: %s", z.GetOrigin().String(false), err.Error())
+			}
+		})
+	}
+}
+
+func (ds *AuthDataSource) SetZoneCommitHandler(handler ZoneCommitHandler) {
+	ds.lock.Lock()
+	ds.commitHandler = handler
+	ds.lock.Unlock()
+}
+
+func (ds *AuthDataSource) watchZone(view string, z zone.Zone) {
+	z.SetCommitHook(func() {
+		ds.lock.RLock()
+		handler := ds.commitHandler
+		ds.lock.RUnlock()
+		if handler != nil {
+			handler(view, z)
+		}
+	})
 }
 
 func (ds *AuthDataSource) Resolve(client *core.Client) {
@@ -80,6 +198,13 @@ func (ds *AuthDataSource) Resolve(client *core.Client) {
 		return
 	}
 
+	if finder.IsExpired() {
+		client.Response = request.MakeResponse()
+		client.Response.Header.Rcode = g53.R_SERVFAIL
+		client.CacheAnswer = false
+		return
+	}
+
 	query := NewQuery(matchType, request, finder)
 	query.Process()
 	client.Response = query.GetResponse()
@@ -2,6 +2,9 @@ package auth
 
 import (
 	//	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ben-han-cn/cement/domaintree"
@@ -14,7 +17,21 @@ import (
 	view "github.com/ben-han-cn/vanguard/viewselector"
 )
 
-func setupTestZone() *AuthDataSource {
+func copyTestZoneFile(dir string) string {
+	content, err := ioutil.ReadFile("testdata/example.com")
+	if err != nil {
+		panic("read test zone failed:" + err.Error())
+	}
+	file := filepath.Join(dir, "example.com")
+	ioutil.WriteFile(file, content, 0644)
+	return file
+}
+
+func setupTestZone(dir string) *AuthDataSource {
+	return setupTestZoneWithFile(copyTestZoneFile(dir))
+}
+
+func setupTestZoneWithFile(file string) *AuthDataSource {
 	logger.UseDefaultLogger("error")
 	view.InitViews(view.DefaultView)
 
@@ -25,7 +42,7 @@ func setupTestZone() *AuthDataSource {
 				Zones: []config.AuthZoneConf{
 					config.AuthZoneConf{
 						Name: "example.com.",
-						File: "testdata/example.com",
+						File: file,
 					},
 				},
 			},
@@ -35,7 +52,9 @@ func setupTestZone() *AuthDataSource {
 }
 
 func TestAuthCmd(t *testing.T) {
-	auth := setupTestZone()
+	dir, _ := ioutil.TempDir("", "vanguard-auth")
+	defer os.RemoveAll(dir)
+	auth := setupTestZone(dir)
 	originStr := "example.com"
 	origin, _ := g53.NameFromString(originStr)
 	zoneData, ok := auth.GetZone("default", origin)
@@ -103,7 +122,9 @@ func TestAuthCmd(t *testing.T) {
 }
 
 func TestAuthInvalidCmd(t *testing.T) {
-	auth := setupTestZone()
+	dir, _ := ioutil.TempDir("", "vanguard-auth")
+	defer os.RemoveAll(dir)
+	auth := setupTestZone(dir)
 	cname := &AuthRR{
 		View:  view.DefaultView,
 		Zone:  "example.com.",
@@ -196,3 +217,33 @@ func TestAuthInvalidCmd(t *testing.T) {
 	findResult = zoneData.Find(g53.NameFromStringUnsafe(old_a.Name), g53.RR_A, zone.DefaultFind).GetResult()
 	ut.Equal(t, findResult.Type, zone.FRNXDomain)
 }
+
+func TestZoneJournalPersist(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "vanguard-auth")
+	defer os.RemoveAll(dir)
+	file := copyTestZoneFile(dir)
+
+	auth := setupTestZoneWithFile(file)
+	origin := g53.NameFromStringUnsafe("example.com.")
+	rrs := AuthRRs{&AuthRR{"default", "example.com", "api.example.com", "3600", "A", "1.2.3.4"}}
+	ut.Equal(t, auth.addAuthRrs(rrs), (*httpcmd.Error)(nil))
+	_, err := os.Stat(file + ".jnl")
+	ut.Assert(t, err == nil, "journal should be created")
+
+	checkZone := func(auth *AuthDataSource) {
+		z, _ := auth.GetZone("default", origin)
+		result := z.Find(g53.NameFromStringUnsafe("api.example.com."), g53.RR_A, zone.DefaultFind).GetResult()
+		ut.Equal(t, result.Type, zone.FRSuccess)
+		soa := z.Find(origin, g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+		ut.Equal(t, soa.Rdatas[0].(*g53.SOA).Serial, uint32(2))
+	}
+
+	restarted := setupTestZoneWithFile(file)
+	checkZone(restarted)
+
+	z, _ := restarted.GetZone("default", origin)
+	ut.Assert(t, z.Compact() == nil, "compact should succeed")
+	_, err = os.Stat(file + ".jnl")
+	ut.Assert(t, os.IsNotExist(err), "journal should be removed after compact")
+	checkZone(setupTestZoneWithFile(file))
+}
@@ -132,11 +132,12 @@ func (z *AuthDataSource) addAuthZone(view, name, content string, masters []strin
 
 func (z *AuthDataSource) deleteAuthZone(view, name string) *httpcmd.Error {
 	origin := g53.NameFromStringUnsafe(name)
-	_, result := z.GetZone(view, origin)
+	zoneData, result := z.GetZone(view, origin)
 	if result != domaintree.ExactMatch {
 		return ErrGetZoneFail
 	}
 
+	zoneData.SetPersistFile("")
 	z.lock.Lock()
 	z.viewZones[view].Delete(origin)
 	z.lock.Unlock()
@@ -153,6 +154,7 @@ func (z *AuthDataSource) updateAuthZone(view, name string, masters []string) *ht
 	zoneData.SetMasters(masters)
 	if len(masters) != 0 {
 		zoneData = loadZoneFromMaster(origin, view, masters)
+		z.watchZone(view, zoneData)
 	}
 	z.lock.Lock()
 	z.viewZones[view].Delete(origin)
@@ -276,6 +278,7 @@ func (z *AuthDataSource) loadZoneData(origin *g53.Name, viewName, content string
 		}
 		zoneData = loadZone(origin, content)
 	}
+	z.watchZone(viewName, zoneData)
 
 	z.lock.Lock()
 	_, err := tree.Insert(origin, zoneData)
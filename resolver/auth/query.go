@@ -15,6 +15,7 @@ type Query struct {
 	answers     []*g53.RRset
 	additionals []*g53.RRset
 	authorities []*g53.RRset
+	dnssec      bool
 }
 
 func NewQuery(matchType domaintree.SearchResult, request *g53.Message, finder zone.Zone) *Query {
@@ -23,6 +24,7 @@ func NewQuery(matchType domaintree.SearchResult, request *g53.Message, finder zo
 		finder:    finder,
 		request:   request,
 		response:  request.MakeResponse(),
+		dnssec:    request.Edns != nil && request.Edns.DnssecAware && finder.IsSigned(),
 	}
 }
 
@@ -63,6 +65,10 @@ func (q *Query) Process() {
 		panic("")
 	}
 
+	if q.dnssec && result.Type != zone.FRServFail {
+		q.addDNSSECRRsets(result)
+	}
+
 	for _, rrset := range q.answers {
 		q.response.AddRRset(g53.AnswerSection, rrset)
 	}
@@ -94,6 +100,44 @@ func (q *Query) addSOA() {
 	q.authorities = append(q.authorities, result.RRset)
 }
 
+func (q *Query) addDNSSECRRsets(result *zone.FindResult) {
+	question := q.request.Question
+	if result.Type == zone.FRDelegation {
+		cut := result.RRset.Name
+		if ds := q.finder.Find(cut, g53.RR_DS, zone.DefaultFind).GetResult(); ds.Type == zone.FRSuccess {
+			q.authorities = append(q.authorities, ds.RRset)
+		} else {
+			q.authorities = append(q.authorities, q.finder.GetDenialProof(cut, g53.RR_DS, zone.FRNXRRset)...)
+		}
+	} else {
+		q.authorities = append(q.authorities, q.finder.GetDenialProof(question.Name, question.Type, result.Type)...)
+	}
+
+	q.answers = q.withRRSig(q.answers)
+	q.authorities = q.withRRSig(q.authorities)
+}
+
+func (q *Query) withRRSig(rrsets []*g53.RRset) []*g53.RRset {
+	origin := q.finder.GetOrigin()
+	signed := make([]*g53.RRset, 0, len(rrsets)*2)
+	for _, rrset := range rrsets {
+		signed = append(signed, rrset)
+		//denial proof already has signatures, ns of delegation isn't signed
+		switch rrset.Type {
+		case g53.RR_RRSIG, g53.RR_NSEC, g53.RR_NSEC3:
+			continue
+		case g53.RR_NS:
+			if rrset.Name.Equals(origin) == false {
+				continue
+			}
+		}
+		if sig := q.finder.GetRRSig(rrset); sig != nil {
+			signed = append(signed, sig)
+		}
+	}
+	return signed
+}
+
 func (q *Query) GetResponse() *g53.Message {
 	return q.response
 }
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+)
+
+const defaultSignAlgorithm = "ECDSAP256SHA256"
+
+var errNoKSKFile = errors.New("ksk file isn't specified")
+
+func loadSignConf(origin *g53.Name, conf *config.ZoneDnssecConf) (*zone.SignConf, error) {
+	if conf.KSKFile == "" {
+		return nil, errNoKSKFile
+	}
+
+	algName := conf.Algorithm
+	if algName == "" {
+		algName = defaultSignAlgorithm
+	}
+	alg, err := dnssec.AlgorithmFromString(algName)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := hex.DecodeString(conf.NSEC3Salt)
+	if err != nil {
+		return nil, err
+	}
+	signConf := &zone.SignConf{
+		NSEC3:           conf.NSEC3,
+		NSEC3Iterations: conf.NSEC3Iterations,
+		NSEC3Salt:       salt,
+	}
+
+	ksk, err := loadSigningKey(conf.KSKFile, alg, dnssec.DNSKEY_FLAG_ZONE|dnssec.DNSKEY_FLAG_SEP)
+	if err != nil {
+		return nil, err
+	}
+	signConf.Keys = append(signConf.Keys, ksk)
+	if ds, err := dnssec.MakeDS(origin, ksk.Key, dnssec.DigestSHA256); err == nil {
+		logger.GetLogger().Info("zone %s is signed, ds of ksk is: %s", origin.String(false), ds.String())
+	}
+
+	if conf.ZSKFile != "" {
+		zsk, err := loadSigningKey(conf.ZSKFile, alg, dnssec.DNSKEY_FLAG_ZONE)
+		if err != nil {
+			return nil, err
+		}
+		signConf.Keys = append(signConf.Keys, zsk)
+	}
+	return signConf, nil
+}
+
+func loadSigningKey(file string, alg uint8, flags uint16) (*zone.SigningKey, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		key, priv, err := dnssec.GenerateKey(alg, flags)
+		if err != nil {
+			return nil, err
+		}
+		if err := dnssec.WriteKeyFile(file, priv); err != nil {
+			return nil, err
+		}
+		logger.GetLogger().Info("generate dnssec key %s with tag %d", file, dnssec.KeyTag(key))
+		return &zone.SigningKey{Key: key, Private: priv}, nil
+	}
+
+	key, priv, err := dnssec.LoadKeyFile(file, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &zone.SigningKey{Key: key, Private: priv}, nil
+}
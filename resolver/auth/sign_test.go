@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+	view "github.com/ben-han-cn/vanguard/viewselector"
+)
+
+var signedZoneContent = `example.com. 3600 IN SOA a.iana-servers.net. hostmaster.example.com. 1 3600 900 604800 300
+example.com. 86400 IN NS ns.example.com.
+ns.example.com. 3600 IN A 1.1.1.1
+www.example.com. 3600 IN A 2.2.2.2
+a.b.example.com. 3600 IN A 3.3.3.3
+*.wild.example.com. 3600 IN A 4.4.4.4
+secure.example.com. 3600 IN NS ns.secure.example.com.
+secure.example.com. 3600 IN DS 12345 13 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+ns.secure.example.com. 3600 IN A 5.5.5.5
+insecure.example.com. 3600 IN NS ns.insecure.example.com.
+ns.insecure.example.com. 3600 IN A 6.6.6.6
+`
+
+func setupSignedZone(nsec3 bool) (*AuthDataSource, zone.Zone) {
+	logger.UseDefaultLogger("error")
+	view.InitViews(view.DefaultView)
+	dir, _ := ioutil.TempDir("", "vanguard-sign")
+	file := filepath.Join(dir, "example.com")
+	ioutil.WriteFile(file, []byte(signedZoneContent), 0644)
+
+	auth := NewAuth(&config.VanguardConf{
+		Auth: []config.AuthZoneInView{
+			config.AuthZoneInView{
+				View: "default",
+				Zones: []config.AuthZoneConf{
+					config.AuthZoneConf{
+						Name: "example.com.",
+						File: file,
+						Dnssec: &config.ZoneDnssecConf{
+							KSKFile:         filepath.Join(dir, "ksk"),
+							ZSKFile:         filepath.Join(dir, "zsk"),
+							NSEC3:           nsec3,
+							NSEC3Iterations: 1,
+							NSEC3Salt:       "aabbccdd",
+						},
+					},
+				},
+			},
+		},
+	})
+	z, _ := auth.GetZone("default", g53.NameFromStringUnsafe("example.com."))
+	return auth, z
+}
+
+func signedQuery(t *testing.T, auth *AuthDataSource, name string, typ g53.RRType) *g53.Message {
+	request := g53.MakeQuery(g53.NameFromStringUnsafe(name), typ, 4096, true)
+	z, matchType := auth.GetZone("default", request.Question.Name)
+	query := NewQuery(matchType, request, z)
+	query.Process()
+
+	render := g53.NewMsgRender()
+	query.GetResponse().Rend(render)
+	resp, err := dnssec.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "parse response failed %v", err)
+	return resp
+}
+
+func verifySections(t *testing.T, keys *g53.RRset, resp *g53.Message) *dnssec.DenialRecords {
+	var verified g53.Section
+	for _, st := range []g53.SectionType{g53.AnswerSection, g53.AuthSection} {
+		section := resp.Sections[st]
+		for _, rrset := range section {
+			if rrset.Type == g53.RR_RRSIG || (rrset.Type == g53.RR_NS && st == g53.AuthSection && rrset.Name.Equals(keys.Name) == false) {
+				continue
+			}
+
+			valid := false
+			for _, sigRRset := range section {
+				if sigRRset.Type != g53.RR_RRSIG || sigRRset.Name.Equals(rrset.Name) == false {
+					continue
+				}
+				for _, rdata := range sigRRset.Rdatas {
+					sig := rdata.(*g53.RRSig)
+					for _, key := range keys.Rdatas {
+						if sig.Covered == rrset.Type && dnssec.Verify(rrset, sig, key.(*dnssec.DNSKEY)) == nil {
+							valid = true
+						}
+					}
+				}
+			}
+			ut.Assert(t, valid, "%s %s should be signed", rrset.Name.String(false), rrset.Type.String())
+			verified = append(verified, rrset)
+		}
+	}
+	return dnssec.NewDenialRecords(verified)
+}
+
+func TestSignedZoneQuery(t *testing.T) {
+	for _, nsec3 := range []bool{false, true} {
+		auth, z := setupSignedZone(nsec3)
+		ut.Assert(t, z.IsSigned(), "zone should be signed")
+		name := func(s string) *g53.Name { return g53.NameFromStringUnsafe(s) }
+
+		resp := signedQuery(t, auth, "example.com.", g53.RR_DNSKEY)
+		ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+		keys := resp.Sections[g53.AnswerSection][0]
+		ut.Equal(t, keys.Type, g53.RR_DNSKEY)
+		ut.Equal(t, len(keys.Rdatas), 2)
+		verifySections(t, keys, resp)
+
+		resp = signedQuery(t, auth, "www.example.com.", g53.RR_A)
+		ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+		verifySections(t, keys, resp)
+
+		resp = signedQuery(t, auth, "nx.example.com.", g53.RR_A)
+		ut.Equal(t, resp.Header.Rcode, g53.R_NXDOMAIN)
+		ut.Assert(t, verifySections(t, keys, resp).ProveNXDomain(name("nx.example.com.")), "nxdomain should be proved")
+
+		resp = signedQuery(t, auth, "www.example.com.", g53.RR_MX)
+		ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+		ut.Assert(t, verifySections(t, keys, resp).ProveNoData(name("www.example.com."), g53.RR_MX), "nodata should be proved")
+
+		resp = signedQuery(t, auth, "b.example.com.", g53.RR_A)
+		ut.Assert(t, verifySections(t, keys, resp).ProveNoData(name("b.example.com."), g53.RR_A), "empty non-terminal should be proved")
+
+		resp = signedQuery(t, auth, "x.wild.example.com.", g53.RR_A)
+		answer := resp.Sections[g53.AnswerSection]
+		ut.Equal(t, answer[1].Rdatas[0].(*g53.RRSig).Labels, uint8(3))
+		ut.Assert(t, verifySections(t, keys, resp).ProveWildcardExpansion(name("x.wild.example.com."), 3), "wildcard expansion should be proved")
+
+		resp = signedQuery(t, auth, "x.wild.example.com.", g53.RR_TXT)
+		ut.Assert(t, verifySections(t, keys, resp).ProveNoData(name("x.wild.example.com."), g53.RR_TXT), "wildcard nodata should be proved")
+
+		resp = signedQuery(t, auth, "www.secure.example.com.", g53.RR_A)
+		auth_ := resp.Sections[g53.AuthSection]
+		ut.Equal(t, auth_[0].Type, g53.RR_NS)
+		ut.Equal(t, auth_[1].Type, g53.RR_DS)
+		verifySections(t, keys, resp)
+
+		resp = signedQuery(t, auth, "secure.example.com.", g53.RR_DS)
+		ut.Equal(t, resp.Sections[g53.AnswerSection][0].Type, g53.RR_DS)
+		verifySections(t, keys, resp)
+
+		resp = signedQuery(t, auth, "www.insecure.example.com.", g53.RR_A)
+		ut.Assert(t, verifySections(t, keys, resp).ProveInsecureDelegation(name("insecure.example.com.")), "insecure delegation should be proved")
+	}
+}
+
+func TestSignedZoneUpdate(t *testing.T) {
+	auth, z := setupSignedZone(false)
+	keys := signedQuery(t, auth, "example.com.", g53.RR_DNSKEY).Sections[g53.AnswerSection][0]
+	oldSOASig := z.GetRRSig(z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset)
+
+	newA, _ := g53.RRsetFromString("nx.example.com. 300 IN A 10.0.0.8")
+	z.SetAcls([]string{"any"})
+	update := g53.MakeUpdate(z.GetOrigin())
+	update.UpdateAddRRset(newA)
+	ut.Equal(t, runUpdate(auth, update), g53.R_NOERROR)
+
+	resp := signedQuery(t, auth, "nx.example.com.", g53.RR_A)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+	verifySections(t, keys, resp)
+	soaSig := z.GetRRSig(z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset)
+	ut.Assert(t, soaSig.Rdatas[0].Compare(oldSOASig.Rdatas[0]) != 0, "soa should be signed again")
+
+	resp = signedQuery(t, auth, "nx1.example.com.", g53.RR_A)
+	ut.Assert(t, verifySections(t, keys, resp).ProveNXDomain(g53.NameFromStringUnsafe("nx1.example.com.")), "nsec chain should be updated")
+}
+
+func TestSigningKeyPersist(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "vanguard-key")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ksk")
+	origin := g53.NameFromStringUnsafe("example.com.")
+	conf := &config.ZoneDnssecConf{Algorithm: "ED25519", KSKFile: file}
+	signConf, err := loadSignConf(origin, conf)
+	ut.Assert(t, err == nil, "generate key failed %v", err)
+	ut.Equal(t, signConf.Keys[0].Key.Algorithm, uint8(dnssec.ED25519))
+
+	loaded, err := loadSignConf(origin, conf)
+	ut.Assert(t, err == nil, "load key failed %v", err)
+	ut.Equal(t, loaded.Keys[0].Key.Compare(signConf.Keys[0].Key), 0)
+
+	_, err = loadSignConf(origin, &config.ZoneDnssecConf{})
+	ut.Equal(t, err, errNoKSKFile)
+}
@@ -11,23 +11,194 @@ import (
 	view "github.com/ben-han-cn/vanguard/viewselector"
 )
 
+type UpdateHandler struct {
+	core.DefaultHandler
+
+	viewSelector *view.SelectorMgr
+	auth         *AuthDataSource
+}
+
+func NewUpdateHandler(viewSelector *view.SelectorMgr, auth *AuthDataSource) *UpdateHandler {
+	return &UpdateHandler{
+		viewSelector: viewSelector,
+		auth:         auth,
+	}
+}
+
+func (h *UpdateHandler) HandleQuery(ctx *core.Context) {
+	client := &ctx.Client
+	if client.Request.Tsig != nil {
+		//signed update is authenticated by key, so the view
+		//is decided by the key not the client address
+		if h.viewSelector.SelectTSIGView(ctx) == false {
+			client.Response.Header.Rcode = g53.R_NOTAUTH
+			return
+		}
+	} else {
+		h.viewSelector.SelectView(ctx)
+	}
+
+	if client.Response == nil {
+		client.Response = client.Request.MakeResponse()
+	}
+	h.auth.HandleUpdate(ctx)
+}
+
 func (ds *AuthDataSource) HandleUpdate(ctx *core.Context) {
-	if err := ds.handleUpdate(ctx); err != nil {
+	client := &ctx.Client
+	rcode, err := ds.handleUpdate(client)
+	if err != nil {
+		logger.GetLogger().Error("Update failed: %s", err.Error())
+	}
+	client.Response.Header.Rcode = rcode
+}
+
+func (ds *AuthDataSource) handleUpdate(client *core.Client) (g53.Rcode, error) {
+	request := client.Request
+	if request.Question == nil || request.Question.Type != g53.RR_SOA ||
+		request.Question.Class != g53.CLASS_IN {
+		return g53.R_FORMERR, nil
+	}
+
+	zoneName := request.Question.Name
+	z, result := ds.GetZone(client.View, zoneName)
+	if result != domaintree.ExactMatch {
+		return g53.R_NOTAUTH, nil
+	}
+
+	if z.IsMaster() == false {
+		return g53.R_NOTAUTH, nil
+	}
+
+	//signed update has to pass the acl check as well
+	if request.Tsig != nil && z.AllowUpdateKey(request.Tsig.Header.Name) == false {
+		return g53.R_REFUSED, view.ErrNoAuthUpdate
+	}
+	updator, ok := z.GetUpdator(client.IP(), false)
+	if ok == false {
+		return g53.R_REFUSED, view.ErrNoAuthUpdate
+	}
+
+	if rcode := checkPrerequisites(z, request.GetSection(g53.AnswerSection)); rcode != g53.R_NOERROR {
+		return rcode, nil
+	}
+
+	updates := request.GetSection(g53.AuthSection)
+	if rcode := prescanUpdates(zoneName, updates); rcode != g53.R_NOERROR {
+		return rcode, nil
+	}
+
+	if err := applyUpdate(updator, updates); err != nil {
 		if err == zone.ErrServFail {
-			ctx.Client.Response.Header.Rcode = g53.R_SERVFAIL
+			return g53.R_SERVFAIL, err
 		} else {
-			ctx.Client.Response.Header.Rcode = g53.R_NOTAUTH
+			return g53.R_REFUSED, err
 		}
-		logger.GetLogger().Error("Update failed: %s", err.Error())
 	}
+	return g53.R_NOERROR, nil
 }
 
-func (ds *AuthDataSource) handleUpdate(ctx *core.Context) error {
-	client := &ctx.Client
-	return ds.handleDynamicRRsets(client.View,
-		client.Request.Question.Name,
-		client.IP(),
-		client.Request.GetSection(g53.AuthSection))
+func isNameInZone(name, origin *g53.Name) bool {
+	relation := name.Compare(origin, false).Relation
+	return relation == g53.EQUAL || relation == g53.SUBDOMAIN
+}
+
+func checkPrerequisites(z zone.Zone, prereqs []*g53.RRset) g53.Rcode {
+	//rfc2136 3.2
+	origin := z.GetOrigin()
+	for _, rrset := range prereqs {
+		if rrset.Ttl != 0 {
+			return g53.R_FORMERR
+		}
+
+		if isNameInZone(rrset.Name, origin) == false {
+			return g53.R_NOTZONE
+		}
+
+		switch rrset.Class {
+		case g53.CLASS_ANY:
+			if len(rrset.Rdatas) != 0 {
+				return g53.R_FORMERR
+			}
+			if rrset.Type == g53.RR_ANY {
+				if nameExists(z, rrset.Name) == false {
+					return g53.R_NXDOMAIN
+				}
+			} else if findRRset(z, rrset.Name, rrset.Type) == nil {
+				return g53.R_NXRRSET
+			}
+		case g53.CLASS_NONE:
+			if len(rrset.Rdatas) != 0 {
+				return g53.R_FORMERR
+			}
+			if rrset.Type == g53.RR_ANY {
+				if nameExists(z, rrset.Name) {
+					return g53.R_YXDOMAIN
+				}
+			} else if findRRset(z, rrset.Name, rrset.Type) != nil {
+				return g53.R_YXRRSET
+			}
+		case g53.CLASS_IN:
+			exists := findRRset(z, rrset.Name, rrset.Type)
+			if exists == nil || exists.Equals(rrset) == false {
+				return g53.R_NXRRSET
+			}
+		default:
+			return g53.R_FORMERR
+		}
+	}
+	return g53.R_NOERROR
+}
+
+func nameExists(z zone.Zone, name *g53.Name) bool {
+	result := z.Find(name, g53.RR_ANY, zone.DefaultFind).GetResult()
+	switch result.Type {
+	case zone.FRNXDomain, zone.FRServFail:
+		return false
+	case zone.FRDelegation:
+		return result.RRset != nil && result.RRset.Name.Equals(name)
+	default:
+		return true
+	}
+}
+
+func findRRset(z zone.Zone, name *g53.Name, typ g53.RRType) *g53.RRset {
+	result := z.Find(name, typ, zone.DefaultFind).GetResult()
+	if result.RRset == nil || result.RRset.Type != typ || result.RRset.Name.Equals(name) == false {
+		return nil
+	}
+	return result.RRset
+}
+
+func prescanUpdates(origin *g53.Name, updates []*g53.RRset) g53.Rcode {
+	//rfc2136 3.4.1
+	for _, rrset := range updates {
+		if isNameInZone(rrset.Name, origin) == false {
+			return g53.R_NOTZONE
+		}
+
+		switch rrset.Class {
+		case g53.CLASS_IN:
+			if rrset.Type == g53.RR_ANY || rrset.Type == g53.RR_AXFR ||
+				rrset.Type == g53.RR_IXFR || rrset.Type == g53.RR_MAILA ||
+				len(rrset.Rdatas) == 0 {
+				return g53.R_FORMERR
+			}
+		case g53.CLASS_ANY:
+			if rrset.Ttl != 0 || len(rrset.Rdatas) != 0 ||
+				rrset.Type == g53.RR_AXFR || rrset.Type == g53.RR_IXFR {
+				return g53.R_FORMERR
+			}
+		case g53.CLASS_NONE:
+			if rrset.Ttl != 0 || rrset.Type == g53.RR_ANY ||
+				rrset.Type == g53.RR_AXFR || rrset.Type == g53.RR_IXFR {
+				return g53.R_FORMERR
+			}
+		default:
+			return g53.R_FORMERR
+		}
+	}
+	return g53.R_NOERROR
 }
 
 func (ds *AuthDataSource) handleDynamicRRsets(viewName string, zoneName *g53.Name, clientIP net.IP, rrsets []*g53.RRset) error {
@@ -35,7 +206,10 @@ func (ds *AuthDataSource) handleDynamicRRsets(viewName string, zoneName *g53.Nam
 	if err != nil {
 		return err
 	}
+	return applyUpdate(updator, rrsets)
+}
 
+func applyUpdate(updator zone.ZoneUpdator, rrsets []*g53.RRset) error {
 	tx, err := updator.Begin()
 	if err != nil {
 		return view.ErrNoAuthUpdate
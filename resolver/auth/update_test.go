@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+)
+
+func runUpdate(auth *AuthDataSource, update *g53.Message) g53.Rcode {
+	ctx := core.NewContext()
+	ctx.Reset()
+	ctx.Client.Addr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}
+	ctx.Client.Request = update
+	ctx.Client.Response = update.MakeResponse()
+	auth.HandleUpdate(ctx)
+	return ctx.Client.Response.Header.Rcode
+}
+
+func TestHandleUpdate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "vanguard-auth")
+	defer os.RemoveAll(dir)
+	auth := setupTestZone(dir)
+	origin := g53.NameFromStringUnsafe("example.com.")
+	z, _ := auth.GetZone("default", origin)
+	newA, _ := g53.RRsetFromString("dhcp.example.com. 300 IN A 10.0.0.8")
+
+	update := g53.MakeUpdate(origin)
+	update.UpdateAddRRset(newA)
+	ut.Equal(t, runUpdate(auth, update), g53.R_REFUSED)
+
+	z.SetAcls([]string{"any"})
+	update = g53.MakeUpdate(origin)
+	update.UpdateNameNotExists([]*g53.Name{newA.Name})
+	update.UpdateAddRRset(newA)
+	ut.Equal(t, runUpdate(auth, update), g53.R_NOERROR)
+	result := z.Find(newA.Name, g53.RR_A, zone.DefaultFind).GetResult()
+	ut.Equal(t, result.Type, zone.FRSuccess)
+	ut.Equal(t, result.RRset.Rdatas[0].String(), "10.0.0.8")
+	soa := z.Find(origin, g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+	ut.Equal(t, soa.Rdatas[0].(*g53.SOA).Serial, uint32(2))
+
+	ut.Equal(t, runUpdate(auth, update), g53.R_YXDOMAIN)
+
+	update = g53.MakeUpdate(origin)
+	update.UpdateRRsetNotExists(newA)
+	ut.Equal(t, runUpdate(auth, update), g53.R_YXRRSET)
+
+	update = g53.MakeUpdate(origin)
+	update.UpdateRdataExsits(&g53.RRset{
+		Name:   newA.Name,
+		Type:   g53.RR_A,
+		Class:  g53.CLASS_IN,
+		Rdatas: newA.Rdatas,
+	})
+	update.UpdateRemoveRRset(newA)
+	ut.Equal(t, runUpdate(auth, update), g53.R_NOERROR)
+	result = z.Find(newA.Name, g53.RR_A, zone.DefaultFind).GetResult()
+	ut.Equal(t, result.Type, zone.FRNXDomain)
+
+	update = g53.MakeUpdate(origin)
+	update.UpdateNameExists([]*g53.Name{newA.Name})
+	ut.Equal(t, runUpdate(auth, update), g53.R_NXDOMAIN)
+
+	outOfZone, _ := g53.RRsetFromString("www.knet.cn. 300 IN A 10.0.0.8")
+	update = g53.MakeUpdate(origin)
+	update.UpdateAddRRset(outOfZone)
+	ut.Equal(t, runUpdate(auth, update), g53.R_NOTZONE)
+
+	update = g53.MakeUpdate(g53.NameFromStringUnsafe("knet.cn."))
+	update.UpdateAddRRset(outOfZone)
+	ut.Equal(t, runUpdate(auth, update), g53.R_NOTAUTH)
+}
+
+func TestHandleSignedUpdate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "vanguard-auth")
+	defer os.RemoveAll(dir)
+	auth := setupTestZone(dir)
+	origin := g53.NameFromStringUnsafe("example.com.")
+	z, _ := auth.GetZone("default", origin)
+	newA, _ := g53.RRsetFromString("dhcp.example.com. 300 IN A 10.0.0.8")
+
+	signedUpdate := func() *g53.Message {
+		update := g53.MakeUpdate(origin)
+		update.UpdateAddRRset(newA)
+		tsig, err := g53.NewTSIG("key1", "c2VjcmV0", "hmac-sha256")
+		ut.Assert(t, err == nil, "create tsig failed:%v", err)
+		update.SetTSIG(tsig)
+		return update
+	}
+
+	z.SetAcls([]string{"any"})
+	ut.Equal(t, runUpdate(auth, signedUpdate()), g53.R_REFUSED)
+
+	z.SetUpdateKeys([]*g53.Name{g53.NameFromStringUnsafe("key1")})
+	z.SetAcls([]string{"none"})
+	ut.Equal(t, runUpdate(auth, signedUpdate()), g53.R_REFUSED)
+
+	z.SetAcls([]string{"any"})
+	ut.Equal(t, runUpdate(auth, signedUpdate()), g53.R_NOERROR)
+	result := z.Find(newA.Name, g53.RR_A, zone.DefaultFind).GetResult()
+	ut.Equal(t, result.Type, zone.FRSuccess)
+}
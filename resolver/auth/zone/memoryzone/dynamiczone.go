@@ -11,22 +11,34 @@ import (
 )
 
 type memoryTx struct {
-	owner *DynamicZone
-	tmp   *MemoryZone
-	lock  *sync.RWMutex
+	owner   *DynamicZone
+	tmp     *MemoryZone
+	lock    *sync.RWMutex
+	touched touchedNames
 }
 
 func (tx *memoryTx) Commit() error {
-	defer tx.lock.Unlock()
-
 	if err := tx.tmp.validate(); err != nil {
+		tx.lock.Unlock()
 		return err
 	}
 
+	if tx.owner.signer != nil {
+		tx.owner.signer.resign(tx.tmp, tx.touched)
+	}
 	old := tx.owner.MemoryZone
+	diff := diffZone(old, tx.tmp, tx.touched)
+	tx.owner.appendJournal(diff)
 	tx.owner.MemoryZone = tx.tmp
+	tx.owner.persistDiff(diff)
 	tx.tmp = nil
+	hook := tx.owner.commitHook
+	tx.lock.Unlock()
+
 	go old.clean()
+	if hook != nil {
+		hook()
+	}
 	return nil
 }
 
@@ -52,9 +64,18 @@ func (ctx *emptyZoneFinderCtx) GetAdditional() []*g53.RRset {
 
 type DynamicZone struct {
 	*MemoryZone
-	lock    sync.RWMutex
-	masters []string
-	acls    []string
+	lock         sync.RWMutex
+	masters      []string
+	notifies     []string
+	acls         []string
+	updateKeys   []*g53.Name
+	transferAcls []string
+	journal      []*zone.ZoneDiff
+	commitHook   func()
+	persistFile  string
+	journalDirty bool
+	expired      bool
+	signer       *zoneSigner
 }
 
 func NewDynamicZone(origin *g53.Name) *DynamicZone {
@@ -102,15 +123,33 @@ func (z *DynamicZone) Load(loadChan <-chan *g53.RRset, abortChan <-chan struct{}
 
 	z.lock.Lock()
 	z.MemoryZone = newMemZone
+	z.journal = nil
+	if z.signer != nil {
+		z.signer.reset()
+	}
+	//journal is based on the replaced data
+	if z.persistFile != "" {
+		if err := z.compact(); err != nil {
+			logger.GetLogger().Error("persist zone %s failed: %s", z.origin.String(false), err.Error())
+		}
+	}
 	z.lock.Unlock()
 
 	return nil
 }
 
 func (z *DynamicZone) Dump() ([]*g53.RRset, error) {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
 	return z.MemoryZone.dump()
 }
 
+func (z *DynamicZone) SetCommitHook(hook func()) {
+	z.lock.Lock()
+	z.commitHook = hook
+	z.lock.Unlock()
+}
+
 func (z *DynamicZone) GetUpdator(ip net.IP, force bool) (zone.ZoneUpdator, bool) {
 	if force {
 		return z, true
@@ -122,7 +161,8 @@ func (z *DynamicZone) GetUpdator(ip net.IP, force bool) (zone.ZoneUpdator, bool)
 		return nil, false
 	}
 
-	if ip == nil || len(z.acls) == 0 {
+	//ip is nil when update is from api
+	if ip == nil {
 		return z, true
 	} else {
 		for _, aclName := range z.acls {
@@ -158,12 +198,77 @@ func (z *DynamicZone) Masters() []string {
 	}
 }
 
+func (z *DynamicZone) SetNotifies(notifies []string) {
+	z.lock.Lock()
+	z.notifies = notifies
+	z.lock.Unlock()
+}
+
+func (z *DynamicZone) Notifies() []string {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	notifies := make([]string, len(z.notifies))
+	copy(notifies, z.notifies)
+	return notifies
+}
+
+func (z *DynamicZone) SetExpired(expired bool) {
+	z.lock.Lock()
+	z.expired = expired
+	z.lock.Unlock()
+}
+
+func (z *DynamicZone) IsExpired() bool {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.expired
+}
+
 func (z *DynamicZone) SetAcls(acls []string) {
 	z.lock.Lock()
 	z.acls = acls
 	z.lock.Unlock()
 }
 
+func (z *DynamicZone) SetUpdateKeys(keys []*g53.Name) {
+	z.lock.Lock()
+	z.updateKeys = keys
+	z.lock.Unlock()
+}
+
+func (z *DynamicZone) AllowUpdateKey(key *g53.Name) bool {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	for _, k := range z.updateKeys {
+		if k.Equals(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *DynamicZone) SetTransferAcls(acls []string) {
+	z.lock.Lock()
+	z.transferAcls = acls
+	z.lock.Unlock()
+}
+
+func (z *DynamicZone) AllowTransfer(ip net.IP) bool {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+
+	//ip is nil when transfer is authenticated by key
+	if ip == nil {
+		return true
+	}
+	for _, aclName := range z.transferAcls {
+		if acl.GetAclManager().Find(aclName, ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (z *DynamicZone) Find(name *g53.Name, typ g53.RRType, option zone.FindOption) zone.FinderContext {
 	if z.MemoryZone.isEmpty() {
 		return &emptyZoneFinderCtx{
@@ -171,6 +276,16 @@ func (z *DynamicZone) Find(name *g53.Name, typ g53.RRType, option zone.FindOptio
 		}
 	} else {
 		z.lock.RLock()
+		if typ == g53.RR_DNSKEY && z.signer != nil && name.Equals(z.MemoryZone.origin) {
+			z.lock.RUnlock()
+			return &dynamicZoneFinderCtx{
+				memoryZoneFinderCtx: &memoryZoneFinderCtx{
+					result: zone.FindResult{Type: zone.FRSuccess, RRset: z.signer.dnskey.Clone()},
+					finder: z.MemoryZone,
+				},
+				zone: z,
+			}
+		}
 		ctx := z.MemoryZone.find(name, typ, option)
 		z.lock.RUnlock()
 
@@ -190,28 +305,37 @@ func (z *DynamicZone) Find(name *g53.Name, typ g53.RRType, option zone.FindOptio
 func (z *DynamicZone) Begin() (zone.Transaction, error) {
 	z.lock.Lock()
 	return &memoryTx{
-		lock:  &z.lock,
-		owner: z,
-		tmp:   z.MemoryZone.clone(),
+		lock:    &z.lock,
+		owner:   z,
+		tmp:     z.MemoryZone.clone(),
+		touched: make(touchedNames),
 	}, nil
 }
 
 func (z *DynamicZone) Add(tx zone.Transaction, rrset *g53.RRset) error {
-	return tx.(*memoryTx).tmp.addRRset(rrset)
+	mtx := tx.(*memoryTx)
+	mtx.touched.add(rrset.Name)
+	return mtx.tmp.addRRset(rrset)
 }
 
 func (z *DynamicZone) DeleteRRset(tx zone.Transaction, rrset *g53.RRset) error {
-	_, err := tx.(*memoryTx).tmp.deleteRRset(rrset)
+	mtx := tx.(*memoryTx)
+	mtx.touched.add(rrset.Name)
+	_, err := mtx.tmp.deleteRRset(rrset)
 	return err
 }
 
 func (z *DynamicZone) DeleteDomain(tx zone.Transaction, name *g53.Name) error {
-	_, err := tx.(*memoryTx).tmp.deleteDomain(name)
+	mtx := tx.(*memoryTx)
+	mtx.touched.add(name)
+	_, err := mtx.tmp.deleteDomain(name)
 	return err
 }
 
 func (z *DynamicZone) DeleteRr(tx zone.Transaction, rrset *g53.RRset) error {
-	_, err := tx.(*memoryTx).tmp.deleteRr(rrset)
+	mtx := tx.(*memoryTx)
+	mtx.touched.add(rrset.Name)
+	_, err := mtx.tmp.deleteRr(rrset)
 	return err
 }
 
@@ -229,6 +353,54 @@ func (z *DynamicZone) DomainCount() int {
 	return z.MemoryZone.domains.NodeCount()
 }
 
+func (z *DynamicZone) SetSignConf(conf *zone.SignConf) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if conf == nil || len(conf.Keys) == 0 {
+		z.signer = nil
+	} else {
+		z.signer = newZoneSigner(z.MemoryZone.origin, conf)
+	}
+}
+
+func (z *DynamicZone) IsSigned() bool {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.signer != nil
+}
+
+func (z *DynamicZone) GetRRSig(rrset *g53.RRset) *g53.RRset {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	if z.signer == nil {
+		return nil
+	}
+
+	source := z.signer.dnskey
+	if rrset.Type != g53.RR_DNSKEY {
+		if source = z.MemoryZone.sourceRRset(rrset.Name, rrset.Type); source == nil {
+			return nil
+		}
+	}
+
+	sig := z.signer.getRRSig(source)
+	if sig == nil || sig.Name.Equals(rrset.Name) {
+		return sig
+	}
+	synthesis := *sig
+	synthesis.Name = rrset.Name
+	return &synthesis
+}
+
+func (z *DynamicZone) GetDenialProof(name *g53.Name, typ g53.RRType, result zone.ResultType) []*g53.RRset {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	if z.signer == nil || z.MemoryZone.isEmpty() {
+		return nil
+	}
+	return z.signer.denialProof(z.MemoryZone, name, typ, result)
+}
+
 type dynamicZoneFinderCtx struct {
 	*memoryZoneFinderCtx
 	zone *DynamicZone
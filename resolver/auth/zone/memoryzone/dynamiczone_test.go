@@ -2,7 +2,11 @@ package memoryzone
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	ut "github.com/ben-han-cn/cement/unittest"
@@ -129,3 +133,103 @@ func TestTransaction(t *testing.T) {
 	tx.Commit()
 	zoneHasARRset(t, dzone, "a.cn.", []string{})
 }
+
+func TestDynamicZoneDump(t *testing.T) {
+	zone := createDynamicZone("cn.", dynamicZoneData)
+	rrsets, err := zone.Dump()
+	ut.Assert(t, err == nil, "dump should succeed:%v", err)
+	ut.Equal(t, len(rrsets), len(dynamicZoneData))
+	ut.Equal(t, rrsets[0].Type, g53.RR_SOA)
+
+	_, err = NewDynamicZone(g53.NameFromStringUnsafe("cn.")).Dump()
+	ut.Equal(t, err, zn.ErrShortOfSOA)
+}
+
+func TestDynamicZoneJournal(t *testing.T) {
+	zone := createDynamicZone("cn.", dynamicZoneData)
+	_, ok := zone.Journal(2023300522)
+	ut.Assert(t, ok == false, "zone load has no journal")
+
+	commits := 0
+	zone.SetCommitHook(func() { commits += 1 })
+
+	tx, _ := zone.Begin()
+	rrset, _ := g53.RRsetFromString("a.cn. 300 IN A 3.3.3.3")
+	zone.Add(tx, rrset)
+	rrset, _ = g53.RRsetFromString("b.cn. 300 IN A 1.1.1.1")
+	zone.DeleteRr(tx, rrset)
+	zone.IncreaseSerialNumber(tx)
+	ut.Assert(t, tx.Commit() == nil, "commit should succeed")
+
+	tx, _ = zone.Begin()
+	rrset, _ = g53.RRsetFromString("f.cn. 600 IN A 2.2.2.2")
+	zone.Add(tx, rrset)
+	zone.IncreaseSerialNumber(tx)
+	ut.Assert(t, tx.Commit() == nil, "commit should succeed")
+	ut.Equal(t, commits, 2)
+
+	diffs, ok := zone.Journal(2023300522)
+	ut.Assert(t, ok, "journal should exist")
+	ut.Equal(t, len(diffs), 2)
+	ut.Equal(t, soaSerial(diffs[0].OldSOA), uint32(2023300522))
+	ut.Equal(t, soaSerial(diffs[0].NewSOA), uint32(2023300523))
+	ut.Equal(t, len(diffs[0].Added), 1)
+	ut.Equal(t, diffs[0].Added[0].String(), "a.cn.\t300\tIN\tA\t3.3.3.3\n")
+	ut.Equal(t, len(diffs[0].Deleted), 1)
+	ut.Equal(t, diffs[0].Deleted[0].Name.String(false), "b.cn.")
+	ut.Equal(t, len(diffs[1].Deleted), 1)
+	ut.Equal(t, len(diffs[1].Added), 1)
+	ut.Equal(t, diffs[1].Added[0].Ttl, g53.RRTTL(600))
+
+	diffs, ok = zone.Journal(2023300523)
+	ut.Assert(t, ok, "journal should exist")
+	ut.Equal(t, len(diffs), 1)
+	_, ok = zone.Journal(2023300524)
+	ut.Assert(t, ok == false, "no diff from latest serial")
+}
+
+func loadDynamicZone(zone *DynamicZone, data []string) error {
+	loadChan := make(chan *g53.RRset)
+	go func() {
+		for _, rr := range data {
+			rrset, _ := g53.RRsetFromString(rr)
+			loadChan <- rrset
+		}
+		close(loadChan)
+	}()
+	return zone.Load(loadChan, make(chan struct{}))
+}
+
+func TestSlaveZonePersist(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "vanguard-zone")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cn")
+	staleJournal := "del cn. 300 IN SOA a.dns.cn. root.cnnic.cn. 2023300500 7200 3600 2419200 21600\n" +
+		"add cn. 300 IN SOA a.dns.cn. root.cnnic.cn. 2023300501 7200 3600 2419200 21600\n" +
+		"add e.cn. 300 IN A 5.5.5.5\n" +
+		"commit\n"
+	ioutil.WriteFile(journalFileName(file), []byte(staleJournal), 0644)
+
+	zone := NewDynamicZone(g53.NameFromStringUnsafe("cn."))
+	zone.SetMasters([]string{"127.0.0.1:53"})
+	ut.Assert(t, loadDynamicZone(zone, dynamicZoneData) == nil, "load should succeed")
+	ut.Assert(t, zone.SetPersistFile(file) == nil, "set persist file should succeed")
+	zoneHasARRset(t, zone, "e.cn.", nil)
+	_, err := os.Stat(journalFileName(file))
+	ut.Assert(t, os.IsNotExist(err), "journal of slave zone should be truncated")
+
+	tx, _ := zone.Begin()
+	rrset, _ := g53.RRsetFromString("e.cn. 300 IN A 5.5.5.5")
+	zone.Add(tx, rrset)
+	zone.IncreaseSerialNumber(tx)
+	ut.Assert(t, tx.Commit() == nil, "commit should succeed")
+	_, err = os.Stat(journalFileName(file))
+	ut.Assert(t, err == nil, "incremental transfer should be journaled")
+
+	ut.Assert(t, loadDynamicZone(zone, dynamicZoneData) == nil, "load should succeed")
+	_, err = os.Stat(journalFileName(file))
+	ut.Assert(t, os.IsNotExist(err), "full transfer should truncate journal")
+	content, _ := ioutil.ReadFile(file)
+	ut.Equal(t, strings.Contains(string(content), "2023300522"), true)
+	ut.Equal(t, strings.Contains(string(content), "e.cn."), false)
+}
@@ -0,0 +1,124 @@
+package memoryzone
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+)
+
+const maxJournalSize = 100
+
+type touchedNames map[string]*g53.Name
+
+func (names touchedNames) add(name *g53.Name) {
+	names[strings.ToLower(name.String(false))] = name
+}
+
+func diffZone(old, new *MemoryZone, names touchedNames) *zone.ZoneDiff {
+	oldSOA, newSOA := old.getSOA(), new.getSOA()
+	if oldSOA == nil || newSOA == nil {
+		return nil
+	}
+
+	diff := &zone.ZoneDiff{
+		OldSOA: oldSOA.Clone(),
+		NewSOA: newSOA.Clone(),
+	}
+	for _, name := range names {
+		deleted, added := diffNameNode(old.getNameNode(name), new.getNameNode(name))
+		diff.Deleted = append(diff.Deleted, deleted...)
+		diff.Added = append(diff.Added, added...)
+	}
+	return diff
+}
+
+func diffNameNode(old, new NameNode) ([]*g53.RRset, []*g53.RRset) {
+	var deleted, added []*g53.RRset
+	for _, typ := range sortedTypes(old) {
+		if typ == g53.RR_SOA {
+			continue
+		}
+
+		oldRRset := old[typ]
+		newRRset, ok := new[typ]
+		if ok == false {
+			deleted = append(deleted, oldRRset.Clone())
+		} else if oldRRset == newRRset {
+			continue
+		} else if oldRRset.Ttl != newRRset.Ttl {
+			deleted = append(deleted, oldRRset.Clone())
+			added = append(added, newRRset.Clone())
+		} else {
+			if rdatas := rdatasDiff(oldRRset.Rdatas, newRRset.Rdatas); len(rdatas) > 0 {
+				deleted = append(deleted, rrsetWithRdatas(oldRRset, rdatas))
+			}
+			if rdatas := rdatasDiff(newRRset.Rdatas, oldRRset.Rdatas); len(rdatas) > 0 {
+				added = append(added, rrsetWithRdatas(newRRset, rdatas))
+			}
+		}
+	}
+
+	for _, typ := range sortedTypes(new) {
+		if _, ok := old[typ]; ok == false && typ != g53.RR_SOA {
+			added = append(added, new[typ].Clone())
+		}
+	}
+	return deleted, added
+}
+
+func sortedTypes(node NameNode) []g53.RRType {
+	types := make([]g53.RRType, 0, len(node))
+	for typ := range node {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func rrsetWithRdatas(rrset *g53.RRset, rdatas []g53.Rdata) *g53.RRset {
+	return &g53.RRset{
+		Name:   rrset.Name,
+		Type:   rrset.Type,
+		Class:  rrset.Class,
+		Ttl:    rrset.Ttl,
+		Rdatas: rdatas,
+	}
+}
+
+func soaSerial(soa *g53.RRset) uint32 {
+	return soa.Rdatas[0].(*g53.SOA).Serial
+}
+
+func (z *DynamicZone) appendJournal(diff *zone.ZoneDiff) {
+	//caller should hold the zone lock
+	if diff == nil || soaSerial(diff.OldSOA) == soaSerial(diff.NewSOA) {
+		//the change can't be expressed by ixfr
+		z.journal = nil
+		return
+	}
+
+	if len(z.journal) > 0 && soaSerial(z.journal[len(z.journal)-1].NewSOA) != soaSerial(diff.OldSOA) {
+		z.journal = nil
+	}
+
+	z.journal = append(z.journal, diff)
+	if len(z.journal) > maxJournalSize {
+		z.journal = z.journal[len(z.journal)-maxJournalSize:]
+	}
+}
+
+func (z *DynamicZone) Journal(fromSerial uint32) ([]*zone.ZoneDiff, bool) {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+
+	for i, diff := range z.journal {
+		if soaSerial(diff.OldSOA) == fromSerial {
+			diffs := make([]*zone.ZoneDiff, len(z.journal)-i)
+			copy(diffs, z.journal[i:])
+			return diffs, true
+		}
+	}
+	return nil, false
+}
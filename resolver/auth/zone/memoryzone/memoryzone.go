@@ -94,7 +94,46 @@ func zoneCutCallback(node *domaintree.Node, param interface{}) bool {
 }
 
 func (z *MemoryZone) dump() ([]*g53.RRset, error) {
-	return nil, nil
+	data := z.originNode.Data()
+	if data == nil {
+		return nil, zone.ErrShortOfSOA
+	}
+
+	soa, ok := data.(NameNode)[g53.RR_SOA]
+	if ok == false {
+		return nil, zone.ErrShortOfSOA
+	}
+
+	//soa should be the first rrset, rdata of rrset may be rotated
+	//by query, so return copies
+	rrsets := []*g53.RRset{soa.Clone()}
+	z.domains.ForEach(func(node *domaintree.Node) {
+		if node.IsEmpty() {
+			return
+		}
+		for typ, rrset := range node.Data().(NameNode) {
+			if typ != g53.RR_SOA {
+				rrsets = append(rrsets, rrset.Clone())
+			}
+		}
+	})
+	return rrsets, nil
+}
+
+func (z *MemoryZone) getSOA() *g53.RRset {
+	data := z.originNode.Data()
+	if data == nil {
+		return nil
+	}
+	return data.(NameNode)[g53.RR_SOA]
+}
+
+func (z *MemoryZone) getNameNode(name *g53.Name) NameNode {
+	node, ret := z.domains.Search(name)
+	if ret != domaintree.ExactMatch || node.IsEmpty() {
+		return nil
+	}
+	return node.Data().(NameNode)
 }
 
 func (z *MemoryZone) find(name *g53.Name, typ g53.RRType, option zone.FindOption) *memoryZoneFinderCtx {
@@ -172,7 +211,8 @@ func (z *MemoryZone) find(name *g53.Name, typ g53.RRType, option zone.FindOption
 
 	nameNode := node.Data().(NameNode)
 	ctx.node = nameNode
-	if node.GetFlag(domaintree.NF_CALLBACK) && node != z.originNode {
+	//ds of the delegation is served by parent zone
+	if node.GetFlag(domaintree.NF_CALLBACK) && node != z.originNode && typ != g53.RR_DS {
 		if ns, ok := nameNode[g53.RR_NS]; ok {
 			ctx.result = zone.FindResult{
 				Type:  zone.FRDelegation,
@@ -505,12 +545,18 @@ func (z *MemoryZone) increaseSerialNumber() {
 		panic("zone short of soa")
 	}
 
-	soa := data.(NameNode)[g53.RR_SOA]
+	nameNode := data.(NameNode)
+	soa := nameNode[g53.RR_SOA]
 	if len(soa.Rdatas) != 1 {
 		panic("zone soa rr isn't one")
 	}
 
-	soa.Rdatas[0].(*g53.SOA).Serial += 1
+	//soa is shared with the zone before transaction
+	newSOA := soa.Clone()
+	soaRdata := *soa.Rdatas[0].(*g53.SOA)
+	soaRdata.Serial += 1
+	newSOA.Rdatas[0] = &soaRdata
+	nameNode[g53.RR_SOA] = newSOA
 }
 
 func cloneNode(v interface{}) interface{} {
@@ -0,0 +1,238 @@
+package memoryzone
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+)
+
+const (
+	journalFileSuffix = ".jnl"
+	journalDelete     = "del "
+	journalAdd        = "add "
+	journalCommit     = "commit"
+)
+
+var errJournalFormat = errors.New("journal line format error")
+
+func journalFileName(zoneFile string) string {
+	return zoneFile + journalFileSuffix
+}
+
+func (z *DynamicZone) SetPersistFile(zoneFile string) error {
+	z.lock.RLock()
+	isMaster := len(z.masters) == 0
+	z.lock.RUnlock()
+
+	//data of slave zone comes from master, which is newer than the journal
+	if zoneFile != "" && isMaster {
+		if err := z.replayJournalFile(journalFileName(zoneFile)); err != nil {
+			return err
+		}
+	}
+
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.persistFile = zoneFile
+	if zoneFile != "" && isMaster == false && z.MemoryZone.getSOA() != nil {
+		return z.compact()
+	}
+	return nil
+}
+
+func (z *DynamicZone) Compact() error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if z.persistFile == "" || z.journalDirty == false {
+		return nil
+	}
+	return z.compact()
+}
+
+func (z *DynamicZone) compact() error {
+	//caller should hold the zone lock
+	rrsets, err := z.MemoryZone.dump()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, rrset := range rrsets {
+		buf.WriteString(rrset.String())
+	}
+
+	tmpFile := z.persistFile + ".tmp"
+	if err := writeFileSync(tmpFile, buf.Bytes(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, z.persistFile); err != nil {
+		return err
+	}
+	if err := os.Remove(journalFileName(z.persistFile)); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	z.journalDirty = false
+	return nil
+}
+
+func (z *DynamicZone) persistDiff(diff *zone.ZoneDiff) {
+	//caller should hold the zone lock
+	if z.persistFile == "" {
+		return
+	}
+
+	var err error
+	if diff == nil || soaSerial(diff.OldSOA) == soaSerial(diff.NewSOA) {
+		//the change can't be replayed from journal
+		err = z.compact()
+	} else {
+		err = writeFileSync(journalFileName(z.persistFile), diffToJournal(diff), os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		z.journalDirty = true
+	}
+
+	if err != nil {
+		logger.GetLogger().Error("persist zone %s failed: %s", z.origin.String(false), err.Error())
+	}
+}
+
+func writeFileSync(file string, data []byte, flag int) error {
+	f, err := os.OpenFile(file, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func diffToJournal(diff *zone.ZoneDiff) []byte {
+	var buf bytes.Buffer
+	writeRRsets := func(prefix string, rrsets []*g53.RRset) {
+		for _, rrset := range rrsets {
+			for _, line := range strings.Split(strings.TrimRight(rrset.String(), "\n"), "\n") {
+				buf.WriteString(prefix)
+				buf.WriteString(line)
+				buf.WriteString("\n")
+			}
+		}
+	}
+	writeRRsets(journalDelete, append([]*g53.RRset{diff.OldSOA}, diff.Deleted...))
+	writeRRsets(journalAdd, append([]*g53.RRset{diff.NewSOA}, diff.Added...))
+	buf.WriteString(journalCommit)
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+func (z *DynamicZone) replayJournalFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var diff zone.ZoneDiff
+	applied := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line == journalCommit {
+			if ok, err := z.replayDiff(&diff); err != nil {
+				return err
+			} else if ok {
+				applied += 1
+			}
+			diff = zone.ZoneDiff{}
+			continue
+		}
+
+		var rrset *g53.RRset
+		if strings.HasPrefix(line, journalDelete) {
+			rrset, err = g53.RRsetFromString(line[len(journalDelete):])
+			if err == nil {
+				if rrset.Type == g53.RR_SOA {
+					diff.OldSOA = rrset
+				} else {
+					diff.Deleted = append(diff.Deleted, rrset)
+				}
+			}
+		} else if strings.HasPrefix(line, journalAdd) {
+			rrset, err = g53.RRsetFromString(line[len(journalAdd):])
+			if err == nil {
+				if rrset.Type == g53.RR_SOA {
+					diff.NewSOA = rrset
+				} else {
+					diff.Added = append(diff.Added, rrset)
+				}
+			}
+		} else {
+			err = errJournalFormat
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		z.lock.Lock()
+		z.journalDirty = true
+		z.lock.Unlock()
+		logger.GetLogger().Info("replay %d transactions from journal %s", applied, file)
+	}
+	return nil
+}
+
+func (z *DynamicZone) replayDiff(diff *zone.ZoneDiff) (bool, error) {
+	if diff.OldSOA == nil || diff.NewSOA == nil {
+		return false, errJournalFormat
+	}
+
+	z.lock.RLock()
+	soa := z.MemoryZone.getSOA()
+	z.lock.RUnlock()
+	if soa == nil {
+		return false, zone.ErrShortOfSOA
+	}
+
+	//transaction before the zone file is compacted
+	if g53.CompareSerial(soaSerial(diff.OldSOA), soaSerial(soa)) == -1 {
+		return false, nil
+	} else if soaSerial(diff.OldSOA) != soaSerial(soa) {
+		return false, zone.ErrUpdateSOA
+	}
+
+	tx, _ := z.Begin()
+	for _, rrset := range diff.Deleted {
+		if err := z.DeleteRr(tx, rrset); err != nil {
+			tx.RollBack()
+			return false, err
+		}
+	}
+	for _, rrset := range append([]*g53.RRset{diff.NewSOA}, diff.Added...) {
+		if err := z.Add(tx, rrset); err != nil && err != g53.ErrDuplicateRdata {
+			tx.RollBack()
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,477 @@
+package memoryzone
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/domaintree"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+)
+
+const (
+	signatureValidity  = 14 * 24 * time.Hour
+	signatureRefresh   = 4 * 24 * time.Hour
+	signatureClockSkew = time.Hour
+	defaultDNSKEYTtl   = 3600
+	nsec3HashLength    = 20
+)
+
+type denialChain struct {
+	names  []*g53.Name
+	hashes []string
+	types  map[string][]g53.RRType
+	exists map[string]bool
+	ttl    g53.RRTTL
+}
+
+type zoneSigner struct {
+	conf   *zone.SignConf
+	origin *g53.Name
+	dnskey *g53.RRset
+	lock   sync.Mutex
+	sigs   map[string]map[g53.RRType]*g53.RRset
+	chain  *denialChain
+}
+
+func newZoneSigner(origin *g53.Name, conf *zone.SignConf) *zoneSigner {
+	dnskey := &g53.RRset{
+		Name:  origin,
+		Type:  g53.RR_DNSKEY,
+		Class: g53.CLASS_IN,
+		Ttl:   defaultDNSKEYTtl,
+	}
+	for _, key := range conf.Keys {
+		dnskey.Rdatas = append(dnskey.Rdatas, key.Key)
+	}
+
+	return &zoneSigner{
+		conf:   conf,
+		origin: origin,
+		dnskey: dnskey,
+		sigs:   make(map[string]map[g53.RRType]*g53.RRset),
+	}
+}
+
+func nameKey(name *g53.Name) string {
+	return strings.ToLower(name.String(false))
+}
+
+func (s *zoneSigner) signingKeys(typ g53.RRType) []*zone.SigningKey {
+	if typ == g53.RR_DNSKEY {
+		return s.conf.Keys
+	}
+
+	var zsks []*zone.SigningKey
+	for _, key := range s.conf.Keys {
+		if key.Key.IsSEP() == false {
+			zsks = append(zsks, key)
+		}
+	}
+	if len(zsks) == 0 {
+		return s.conf.Keys
+	}
+	return zsks
+}
+
+func (s *zoneSigner) sign(rrset *g53.RRset, now time.Time) *g53.RRset {
+	sigs := &g53.RRset{
+		Name:  rrset.Name,
+		Type:  g53.RR_RRSIG,
+		Class: rrset.Class,
+		Ttl:   rrset.Ttl,
+	}
+	for _, key := range s.signingKeys(rrset.Type) {
+		sig, err := dnssec.Sign(rrset, s.origin, key.Key, key.Private, now.Add(-signatureClockSkew), now.Add(signatureValidity))
+		if err != nil {
+			logger.GetLogger().Error("sign %s %s failed: %s", rrset.Name.String(false), rrset.Type.String(), err.Error())
+			continue
+		}
+		sigs.Rdatas = append(sigs.Rdatas, sig)
+	}
+
+	if len(sigs.Rdatas) == 0 {
+		return nil
+	}
+	return sigs
+}
+
+func (s *zoneSigner) signature(rrset *g53.RRset, now time.Time) *g53.RRset {
+	//caller should hold the signer lock
+	key := nameKey(rrset.Name)
+	if sig, ok := s.sigs[key][rrset.Type]; ok {
+		expire := time.Unix(int64(sig.Rdatas[0].(*g53.RRSig).SigExpire), 0)
+		if expire.Sub(now) > signatureRefresh {
+			return sig
+		}
+	}
+
+	sig := s.sign(rrset, now)
+	if sig != nil {
+		if _, ok := s.sigs[key]; ok == false {
+			s.sigs[key] = make(map[g53.RRType]*g53.RRset)
+		}
+		s.sigs[key][rrset.Type] = sig
+	}
+	return sig
+}
+
+func (s *zoneSigner) getRRSig(rrset *g53.RRset) *g53.RRset {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.signature(rrset, time.Now())
+}
+
+func (s *zoneSigner) reset() {
+	s.lock.Lock()
+	s.sigs = make(map[string]map[g53.RRType]*g53.RRset)
+	s.chain = nil
+	s.lock.Unlock()
+}
+
+func (s *zoneSigner) resign(z *MemoryZone, names touchedNames) {
+	//drop signatures of changed names and sign them again with the
+	//new zone, denial chain is rebuilt since names may be added or deleted
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sigs, nameKey(s.origin))
+	for key := range names {
+		delete(s.sigs, key)
+	}
+	for key, sigs := range s.sigs {
+		delete(sigs, g53.RR_NSEC)
+		delete(sigs, g53.RR_NSEC3)
+		if len(sigs) == 0 {
+			delete(s.sigs, key)
+		}
+	}
+
+	now := time.Now()
+	s.chain = s.buildChain(z)
+	names.add(s.origin)
+	for _, name := range names {
+		if node := z.getNameNode(name); node != nil && z.isOccluded(name) == false {
+			for _, rrset := range node {
+				if rrset.Type != g53.RR_NS || name.Equals(s.origin) {
+					s.signature(rrset, now)
+				}
+			}
+		}
+	}
+}
+
+func (z *MemoryZone) isOccluded(name *g53.Name) bool {
+	//name below zone cut is glue or garbage which isn't signed
+	for i := uint(1); i+z.origin.LabelCount() <= name.LabelCount(); i++ {
+		parent, _ := name.Parent(i)
+		if parent.Equals(z.origin) {
+			break
+		}
+		if node := z.getNameNode(parent); node != nil {
+			if _, ok := node[g53.RR_NS]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (z *MemoryZone) authoritativeNames() []*g53.Name {
+	var names []*g53.Name
+	z.domains.ForEach(func(node *domaintree.Node) {
+		if node.IsEmpty() {
+			return
+		}
+		for _, rrset := range node.Data().(NameNode) {
+			if rrset.Name.IsSubDomain(z.origin) && z.isOccluded(rrset.Name) == false {
+				names = append(names, rrset.Name)
+			}
+			break
+		}
+	})
+	return names
+}
+
+func (s *zoneSigner) nodeTypes(name *g53.Name, node NameNode) []g53.RRType {
+	var types []g53.RRType
+	_, hasDS := node[g53.RR_DS]
+	isDelegation := name.Equals(s.origin) == false && node[g53.RR_NS] != nil
+	for typ := range node {
+		if isDelegation == false || typ == g53.RR_NS || typ == g53.RR_DS {
+			types = append(types, typ)
+		}
+	}
+	if name.Equals(s.origin) {
+		types = append(types, g53.RR_DNSKEY)
+	}
+	if isDelegation == false || hasDS {
+		types = append(types, g53.RR_RRSIG)
+	}
+	if s.conf.NSEC3 == false {
+		types = append(types, g53.RR_NSEC)
+		if isDelegation {
+			types = append(types, g53.RR_RRSIG)
+		}
+	}
+	return sortedUniqueTypes(types)
+}
+
+func sortedUniqueTypes(types []g53.RRType) []g53.RRType {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	var unique []g53.RRType
+	for i, typ := range types {
+		if i == 0 || typ != types[i-1] {
+			unique = append(unique, typ)
+		}
+	}
+	return unique
+}
+
+func (s *zoneSigner) nsec3Hash(name *g53.Name) string {
+	return dnssec.NSEC3Hash(name, s.conf.NSEC3Iterations, s.conf.NSEC3Salt)
+}
+
+func (s *zoneSigner) buildChain(z *MemoryZone) *denialChain {
+	chain := &denialChain{
+		types:  make(map[string][]g53.RRType),
+		exists: make(map[string]bool),
+		ttl:    defaultDNSKEYTtl,
+	}
+	if soa := z.getSOA(); soa != nil {
+		chain.ttl = soa.Ttl
+		if minimum := g53.RRTTL(soa.Rdatas[0].(*g53.SOA).Minimum); minimum < chain.ttl {
+			chain.ttl = minimum
+		}
+	}
+
+	for _, name := range z.authoritativeNames() {
+		types := s.nodeTypes(name, z.getNameNode(name))
+		chain.exists[nameKey(name)] = true
+		if s.conf.NSEC3 == false {
+			chain.names = append(chain.names, name)
+			chain.types[nameKey(name)] = types
+			continue
+		}
+
+		chain.types[s.nsec3Hash(name)] = types
+		//empty non-terminals have nsec3 without types
+		for i := uint(1); i+z.origin.LabelCount() <= name.LabelCount(); i++ {
+			parent, _ := name.Parent(i)
+			if chain.exists[nameKey(parent)] {
+				continue
+			}
+			chain.exists[nameKey(parent)] = true
+			if z.getNameNode(parent) == nil {
+				chain.types[s.nsec3Hash(parent)] = nil
+			}
+		}
+	}
+
+	if s.conf.NSEC3 {
+		for hash := range chain.types {
+			chain.hashes = append(chain.hashes, hash)
+		}
+		sort.Strings(chain.hashes)
+	} else {
+		sort.Slice(chain.names, func(i, j int) bool {
+			return dnssec.CompareName(chain.names[i], chain.names[j]) < 0
+		})
+		for _, name := range chain.names {
+			for i := uint(1); i+z.origin.LabelCount() <= name.LabelCount(); i++ {
+				parent, _ := name.Parent(i)
+				chain.exists[nameKey(parent)] = true
+			}
+		}
+	}
+	return chain
+}
+
+func (s *zoneSigner) getChain(z *MemoryZone) *denialChain {
+	//caller should hold the signer lock
+	if s.chain == nil {
+		s.chain = s.buildChain(z)
+	}
+	return s.chain
+}
+
+func (c *denialChain) closestEncloser(origin, name *g53.Name) (*g53.Name, *g53.Name) {
+	nextCloser := name
+	for i := uint(1); i+origin.LabelCount() <= name.LabelCount(); i++ {
+		parent, _ := name.Parent(i)
+		if c.exists[nameKey(parent)] {
+			return parent, nextCloser
+		}
+		nextCloser = parent
+	}
+	return origin, nextCloser
+}
+
+func (s *zoneSigner) nsecCovering(c *denialChain, name *g53.Name) int {
+	i := sort.Search(len(c.names), func(i int) bool {
+		return dnssec.CompareName(c.names[i], name) > 0
+	})
+	if i == 0 {
+		return len(c.names) - 1
+	}
+	return i - 1
+}
+
+func (s *zoneSigner) nsecRRset(c *denialChain, i int) *g53.RRset {
+	name := c.names[i]
+	return &g53.RRset{
+		Name:  name,
+		Type:  g53.RR_NSEC,
+		Class: g53.CLASS_IN,
+		Ttl:   c.ttl,
+		Rdatas: []g53.Rdata{&dnssec.NSEC{
+			NextName: c.names[(i+1)%len(c.names)],
+			Types:    c.types[nameKey(name)],
+		}},
+	}
+}
+
+func (s *zoneSigner) nsec3Index(c *denialChain, name *g53.Name, covering bool) int {
+	hash := s.nsec3Hash(name)
+	i := sort.SearchStrings(c.hashes, hash)
+	if i < len(c.hashes) && c.hashes[i] == hash {
+		if covering {
+			return -1
+		}
+		return i
+	} else if covering == false {
+		return -1
+	} else if i == 0 {
+		return len(c.hashes) - 1
+	}
+	return i - 1
+}
+
+func (s *zoneSigner) nsec3RRset(c *denialChain, i int) *g53.RRset {
+	hash := c.hashes[i]
+	owner, _ := g53.NameFromStringUnsafe(strings.ToLower(hash)).Concat(s.origin)
+	salt := s.conf.NSEC3Salt
+	return &g53.RRset{
+		Name:  owner,
+		Type:  g53.RR_NSEC3,
+		Class: g53.CLASS_IN,
+		Ttl:   c.ttl,
+		Rdatas: []g53.Rdata{&dnssec.NSEC3{NSEC3: g53.NSEC3{
+			Algorithm:  dnssec.NSEC3_HASH_SHA1,
+			Iterations: s.conf.NSEC3Iterations,
+			SaltLength: uint8(len(salt)),
+			Salt:       hex.EncodeToString(salt),
+			HashLength: nsec3HashLength,
+			NextHash:   c.hashes[(i+1)%len(c.hashes)],
+			Types:      c.types[hash],
+		}}},
+	}
+}
+
+func (s *zoneSigner) denialProof(z *MemoryZone, name *g53.Name, typ g53.RRType, result zone.ResultType) []*g53.RRset {
+	//nsec or nsec3 records with signatures which prove the result
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c := s.getChain(z)
+	exists := c.exists[nameKey(name)]
+	synthesized := (result == zone.FRSuccess || result == zone.FRCname) && z.getNameNode(name) == nil
+	if result != zone.FRNXDomain && result != zone.FRNXRRset && synthesized == false {
+		return nil
+	}
+
+	var rrsets []*g53.RRset
+	if s.conf.NSEC3 == false {
+		if len(c.names) == 0 {
+			return nil
+		}
+		//nsec of existing name or the one covers the name
+		indexes := []int{s.nsecCovering(c, name)}
+		if exists == false && synthesized == false {
+			ce, _ := c.closestEncloser(s.origin, name)
+			wildcard, _ := g53.NameFromStringUnsafe("*").Concat(ce)
+			indexes = append(indexes, s.nsecCovering(c, wildcard))
+		}
+		for _, index := range uniqueIndexes(indexes) {
+			rrsets = append(rrsets, s.nsecRRset(c, index))
+		}
+	} else {
+		if len(c.hashes) == 0 {
+			return nil
+		}
+		var indexes []int
+		if exists && synthesized == false {
+			indexes = append(indexes, s.nsec3Index(c, name, false))
+		} else {
+			ce, nextCloser := c.closestEncloser(s.origin, name)
+			indexes = append(indexes, s.nsec3Index(c, nextCloser, true))
+			if synthesized == false {
+				wildcard, _ := g53.NameFromStringUnsafe("*").Concat(ce)
+				indexes = append(indexes, s.nsec3Index(c, ce, false))
+				indexes = append(indexes, s.nsec3Index(c, wildcard, result == zone.FRNXDomain))
+			}
+		}
+		for _, index := range uniqueIndexes(indexes) {
+			rrsets = append(rrsets, s.nsec3RRset(c, index))
+		}
+	}
+
+	now := time.Now()
+	var proof []*g53.RRset
+	for _, rrset := range rrsets {
+		proof = append(proof, rrset)
+		if sig := s.signature(rrset, now); sig != nil {
+			proof = append(proof, sig)
+		}
+	}
+	return proof
+}
+
+func uniqueIndexes(indexes []int) []int {
+	var unique []int
+	for _, index := range indexes {
+		if index < 0 {
+			continue
+		}
+		duplicate := false
+		for _, i := range unique {
+			if i == index {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate == false {
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
+
+func (z *MemoryZone) sourceRRset(name *g53.Name, typ g53.RRType) *g53.RRset {
+	//rrset in zone which the answer comes from, it may be synthesized from wildcard
+	if node := z.getNameNode(name); node != nil {
+		return node[typ]
+	}
+
+	for i := uint(1); i+z.origin.LabelCount() <= name.LabelCount(); i++ {
+		parent, _ := name.Parent(i)
+		node, ret := z.domains.Search(parent)
+		if ret != domaintree.ExactMatch {
+			continue
+		}
+		if node.GetFlag(wildcardMark) == false {
+			return nil
+		}
+		wildcard, _ := g53.NameFromStringUnsafe("*").Concat(parent)
+		if node := z.getNameNode(wildcard); node != nil {
+			return node[typ]
+		}
+		return nil
+	}
+	return nil
+}
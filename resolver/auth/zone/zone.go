@@ -1,10 +1,12 @@
 package zone
 
 import (
+	"crypto"
 	"errors"
 	"net"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/dnssec"
 )
 
 var (
@@ -40,6 +42,7 @@ var SupportRRTypes = []g53.RRType{
 	g53.RR_NAPTR,
 	g53.RR_OPT,
 	g53.RR_DNAME,
+	g53.RR_DS,
 }
 
 type ResultType int
@@ -75,6 +78,13 @@ type ZoneFinder interface {
 	Find(*g53.Name, g53.RRType, FindOption) FinderContext
 }
 
+type ZoneDiff struct {
+	OldSOA  *g53.RRset
+	NewSOA  *g53.RRset
+	Deleted []*g53.RRset
+	Added   []*g53.RRset
+}
+
 type Transaction interface {
 	RollBack() error
 	Commit() error
@@ -97,18 +107,55 @@ type ZoneTransfer interface {
 	IsMaster() bool
 	Masters() []string
 	SetMasters([]string)
+	Notifies() []string
+	SetNotifies([]string)
+	IsExpired() bool
+	SetExpired(bool)
+}
+
+type ZoneJournal interface {
+	Dump() ([]*g53.RRset, error)
+	Journal(uint32) ([]*ZoneDiff, bool)
+	SetCommitHook(func())
+	SetPersistFile(string) error
+	Compact() error
 }
 
 type SafeZone interface {
 	GetUpdator(net.IP, bool) (ZoneUpdator, bool)
 	SetAcls([]string)
+	SetUpdateKeys([]*g53.Name)
+	AllowUpdateKey(*g53.Name) bool
+	AllowTransfer(net.IP) bool
+	SetTransferAcls([]string)
+}
+
+type SigningKey struct {
+	Key     *dnssec.DNSKEY
+	Private crypto.Signer
+}
+
+type SignConf struct {
+	Keys            []*SigningKey
+	NSEC3           bool
+	NSEC3Iterations uint16
+	NSEC3Salt       []byte
+}
+
+type ZoneSigner interface {
+	SetSignConf(*SignConf)
+	IsSigned() bool
+	GetRRSig(*g53.RRset) *g53.RRset
+	GetDenialProof(*g53.Name, g53.RRType, ResultType) []*g53.RRset
 }
 
 type Zone interface {
 	ZoneFinder
 	ZoneLoader
 	ZoneTransfer
+	ZoneJournal
 	SafeZone
+	ZoneSigner
 }
 
 func IsRRsetTypeSupport(typ g53.RRType) bool {
@@ -4,6 +4,7 @@ import (
 	"github.com/ben-han-cn/g53"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/ecs"
 	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/resolver/chain"
 	"github.com/ben-han-cn/vanguard/resolver/querysource"
@@ -12,7 +13,8 @@ import (
 
 type Forwarder struct {
 	chain.DefaultResolver
-	viewFwder *ViewFwderMgr
+	viewFwder    *ViewFwderMgr
+	subnetPrefix ecs.SourcePrefixes
 }
 
 func NewForwarder(conf *config.VanguardConf) *Forwarder {
@@ -25,6 +27,7 @@ func NewForwarder(conf *config.VanguardConf) *Forwarder {
 
 func (fwder *Forwarder) ReloadConfig(conf *config.VanguardConf) {
 	fwder.viewFwder.ReloadConfig(conf)
+	fwder.subnetPrefix = ecs.NewSourcePrefixes(conf)
 }
 
 func (fwder *Forwarder) Resolve(client *core.Client) {
@@ -50,8 +53,10 @@ func (fwder *Forwarder) processRequest(client *core.Client) {
 		if err := f.SetQuerySource(querysource.GetQuerySource(client.View)); err != nil {
 			logger.GetLogger().Error("view fwder failed:" + err.Error())
 		} else {
-			if resp, _, err := f.Forward(client.Request); err == nil {
+			request, subnet := fwder.requestWithSubnet(client)
+			if resp, _, err := f.Forward(request); err == nil {
 				logger.GetLogger().Debug("send query %s to fwder %s succeed", client.Request.Question.String(), f.RemoteAddr())
+				ecs.SetResponseSubnet(resp, ecs.FromEdns(client.Request.Edns), subnet)
 				client.Response = resp
 			} else {
 				logger.GetLogger().Error("send query %s to fwder %s failed: %s", client.Request.Question.String(), f.RemoteAddr(), err.Error())
@@ -59,3 +64,22 @@ func (fwder *Forwarder) processRequest(client *core.Client) {
 		}
 	}
 }
+
+func (fwder *Forwarder) requestWithSubnet(client *core.Client) (*g53.Message, *ecs.ClientSubnet) {
+	clientSubnet := ecs.FromEdns(client.Request.Edns)
+	subnet := fwder.subnetPrefix.Get(client.View).ClientSubnet(client)
+	if clientSubnet == nil && subnet == nil {
+		return client.Request, nil
+	}
+
+	request := *client.Request
+	if request.Edns == nil {
+		request.Edns = &g53.EDNS{UdpSize: 4096}
+	} else {
+		edns := *request.Edns
+		request.Edns = &edns
+	}
+	ecs.SetEdns(request.Edns, subnet)
+	request.RecalculateSectionRRCount()
+	return &request, subnet
+}
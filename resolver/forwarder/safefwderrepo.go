@@ -1,6 +1,7 @@
 package forwarder
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/ben-han-cn/vanguard/config"
@@ -17,17 +18,26 @@ type SafeFwderRepo struct {
 	fwderTimeout   time.Duration
 	timeoutLasting time.Duration
 
-	fwders map[string]SafeFwder
-	prober *Prober
+	fwders     map[string]SafeFwder
+	tlsCAFiles map[string]string
+	prober     *Prober
 }
 
-func NewSafeFwderRepo(conf *config.ForwardProberConf) *SafeFwderRepo {
+func NewSafeFwderRepo(conf *config.ForwarderConf) (*SafeFwderRepo, error) {
 	repo := &SafeFwderRepo{}
-	repo.ReloadConf(conf)
-	return repo
+	if err := repo.ReloadConf(conf); err != nil {
+		return nil, err
+	}
+	return repo, nil
 }
 
-func (repo *SafeFwderRepo) ReloadConf(conf *config.ForwardProberConf) {
+func (repo *SafeFwderRepo) ReloadConf(fwderConf *config.ForwarderConf) error {
+	tlsCAFiles, err := loadTLSCAFiles(fwderConf)
+	if err != nil {
+		return err
+	}
+
+	conf := &fwderConf.Prober
 	if repo.prober != nil {
 		repo.prober.Stop()
 	}
@@ -51,16 +61,47 @@ func (repo *SafeFwderRepo) ReloadConf(conf *config.ForwardProberConf) {
 	repo.fwderTimeout = time.Duration(fwderTimeout) * time.Second
 	repo.timeoutLasting = time.Duration(timeoutLasting) * time.Second
 	repo.fwders = make(map[string]SafeFwder)
+	repo.tlsCAFiles = tlsCAFiles
 	repo.prober = NewProber(repo.probeInterval)
+	return nil
+}
+
+func loadTLSCAFiles(conf *config.ForwarderConf) (map[string]string, error) {
+	//ca file is bound to server address, sni name isn't part of the key
+	tlsFwders := make(map[string]bool)
+	for _, c := range conf.ForwardZones {
+		for _, zone := range c.Zones {
+			for _, addr := range zone.Forwarders {
+				if isTLSFwder(addr) {
+					if serverAddr, _, err := parseTLSFwderAddr(addr); err == nil {
+						tlsFwders[serverAddr] = true
+					}
+				}
+			}
+		}
+	}
+
+	caFiles := make(map[string]string)
+	for _, c := range conf.TLSForwarders {
+		serverAddr, _, err := parseTLSFwderAddr(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if tlsFwders[serverAddr] == false {
+			return nil, fmt.Errorf("tls forwarder %s isn't used by any forward zone", c.Addr)
+		}
+		caFiles[serverAddr] = c.CAFile
+	}
+	return caFiles, nil
 }
 
 func (repo *SafeFwderRepo) GetOrCreateFwder(addr string) (fwder SafeFwder, err error) {
 	if fwder, ok := repo.fwders[addr]; ok {
 		return fwder, nil
 	} else {
-		udpFwder, err := NewSafeUDPFwder(addr, repo.fwderTimeout, repo.timeoutLasting)
+		safeFwder, err := repo.newFwder(addr)
 		if err == nil {
-			fwder := NewRecoverableFwder(udpFwder, repo.prober)
+			fwder := NewRecoverableFwder(safeFwder, repo.prober)
 			repo.fwders[addr] = fwder
 			return fwder, nil
 		} else {
@@ -68,3 +109,15 @@ func (repo *SafeFwderRepo) GetOrCreateFwder(addr string) (fwder SafeFwder, err e
 		}
 	}
 }
+
+func (repo *SafeFwderRepo) newFwder(addr string) (SafeFwder, error) {
+	if isTLSFwder(addr) == false {
+		return NewSafeUDPFwder(addr, repo.fwderTimeout, repo.timeoutLasting)
+	}
+
+	serverAddr, _, err := parseTLSFwderAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSafeTLSFwder(addr, repo.tlsCAFiles[serverAddr], repo.fwderTimeout, repo.timeoutLasting)
+}
@@ -0,0 +1,119 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	vutil "github.com/ben-han-cn/vanguard/util"
+)
+
+const (
+	tlsFwderScheme  = "tls://"
+	defaultTLSPort  = "853"
+	tlsAuthNameMark = "#"
+)
+
+var errInvalidCAFile = errors.New("no certificate in ca file")
+
+type SafeTLSFwder struct {
+	fwderStatus
+	fwder *vutil.TCPSender
+
+	remoteAddr   string
+	serverAddr   string
+	tlsConf      *tls.Config
+	fwderTimeout time.Duration
+}
+
+func isTLSFwder(addr string) bool {
+	return strings.HasPrefix(addr, tlsFwderScheme)
+}
+
+func parseTLSFwderAddr(addr string) (string, string, error) {
+	//addr is like tls://1.1.1.1:853#cloudflare-dns.com, the name after # is
+	//used as sni and to authenticate the server, ip is used if it's omitted
+	addr = strings.TrimPrefix(addr, tlsFwderScheme)
+	authName := ""
+	if i := strings.Index(addr, tlsAuthNameMark); i != -1 {
+		addr, authName = addr[:i], addr[i+1:]
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), defaultTLSPort
+	}
+	if net.ParseIP(host) == nil {
+		return "", "", errors.New("invalid tls forwarder address " + addr)
+	}
+	if authName == "" {
+		authName = host
+	}
+	return net.JoinHostPort(host, port), authName, nil
+}
+
+func NewSafeTLSFwder(addr string, caFile string, fwderTimeout, bearableFailInterval time.Duration) (*SafeTLSFwder, error) {
+	serverAddr, authName, err := parseTLSFwderAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := &tls.Config{
+		ServerName: authName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		//only the pinned ca is trusted instead of system roots
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			return nil, errInvalidCAFile
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	return &SafeTLSFwder{
+		fwderStatus: fwderStatus{
+			bearableFailInterval: int64(bearableFailInterval.Seconds()),
+		},
+		remoteAddr:   addr,
+		serverAddr:   serverAddr,
+		tlsConf:      tlsConf,
+		fwderTimeout: fwderTimeout,
+	}, nil
+}
+
+func (f *SafeTLSFwder) SetQuerySource(ip string) error {
+	sender, err := vutil.NewTLSSender(ip, f.fwderTimeout, f.tlsConf)
+	if err != nil {
+		return err
+	} else {
+		f.fwder = sender
+		return nil
+	}
+}
+
+func (f *SafeTLSFwder) Forward(query *g53.Message) (*g53.Message, time.Duration, error) {
+	originalQueryId := query.Header.Id
+	query.Header.Id = util.GenMessageId()
+	resp, rtt, err := f.fwder.Query(f.serverAddr, g53.NewMsgRender(), query)
+	f.checkStatus(rtt, err)
+	query.Header.Id = originalQueryId
+	if resp != nil {
+		resp.Header.Id = originalQueryId
+	}
+	return resp, rtt, err
+}
+
+func (f *SafeTLSFwder) RemoteAddr() string {
+	return f.remoteAddr
+}
@@ -0,0 +1,148 @@
+package forwarder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/config"
+	vutil "github.com/ben-han-cn/vanguard/util"
+)
+
+func runTLSServer(t *testing.T, name string) (string, string, *int32) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ut.Assert(t, err == nil, "create certificate failed %v", err)
+
+	caFile, _ := ioutil.TempFile("", "ca")
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	caFile.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	ut.Assert(t, err == nil, "listen tls failed %v", err)
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				render := g53.NewMsgRender()
+				for {
+					buf, err := vutil.TCPRead(conn, time.Second)
+					if err != nil {
+						return
+					}
+					query, _ := g53.MessageFromWire(util.NewInputBuffer(buf))
+					resp := query.MakeResponse()
+					rrset, _ := g53.RRsetFromString(query.Question.Name.String(false) + " 300 IN A 1.1.1.1")
+					resp.AddRRset(g53.AnswerSection, rrset)
+					resp.RecalculateSectionRRCount()
+					resp.Rend(render)
+					vutil.TCPWrite(render.Data(), conn, time.Second)
+					render.Clear()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), caFile.Name(), &accepted
+}
+
+func TestParseTLSFwderAddr(t *testing.T) {
+	addr, name, err := parseTLSFwderAddr("tls://1.1.1.1:853#cloudflare-dns.com")
+	ut.Assert(t, err == nil, "parse address failed %v", err)
+	ut.Equal(t, addr, "1.1.1.1:853")
+	ut.Equal(t, name, "cloudflare-dns.com")
+
+	addr, name, _ = parseTLSFwderAddr("tls://2606:4700::1111")
+	ut.Equal(t, addr, "[2606:4700::1111]:853")
+	ut.Equal(t, name, "2606:4700::1111")
+
+	_, _, err = parseTLSFwderAddr("tls://dns.example.com:853")
+	ut.Assert(t, err != nil, "server should be an ip address")
+}
+
+func TestLoadTLSCAFiles(t *testing.T) {
+	conf := &config.ForwarderConf{
+		ForwardZones: []config.ForwardZoneInView{{
+			View: "default",
+			Zones: []config.ForwardZoneConf{{
+				Name:       "com.",
+				Forwarders: []string{"tls://1.1.1.1:853#cloudflare-dns.com", "tls://2606:4700::1111", "8.8.8.8:53"},
+			}},
+		}},
+		TLSForwarders: []config.TLSForwarderConf{
+			{Addr: "1.1.1.1", CAFile: "cloudflare.pem"},
+			{Addr: "tls://[2606:4700::1111]:853", CAFile: "cloudflare6.pem"},
+		},
+	}
+	caFiles, err := loadTLSCAFiles(conf)
+	ut.Assert(t, err == nil, "load ca files failed %v", err)
+	ut.Equal(t, caFiles["1.1.1.1:853"], "cloudflare.pem")
+	ut.Equal(t, caFiles["[2606:4700::1111]:853"], "cloudflare6.pem")
+
+	conf.TLSForwarders = append(conf.TLSForwarders, config.TLSForwarderConf{Addr: "8.8.8.8:853", CAFile: "google.pem"})
+	_, err = loadTLSCAFiles(conf)
+	ut.Assert(t, err != nil, "ca file of unused forwarder should be rejected")
+}
+
+func TestSafeTLSFwder(t *testing.T) {
+	addr, caFile, accepted := runTLSServer(t, "dns.example.com")
+	defer os.Remove(caFile)
+
+	fwder, err := NewSafeTLSFwder("tls://"+addr+"#dns.example.com", caFile, defaultTimeout, 10*time.Second)
+	ut.Assert(t, err == nil, "create tls forwarder failed %v", err)
+	for i := 0; i < 3; i++ {
+		fwder.SetQuerySource("")
+		query := g53.MakeQuery(g53.NameFromStringUnsafe("www.knet.cn."), g53.RR_A, 1024, false)
+		resp, _, err := fwder.Forward(query)
+		ut.Assert(t, err == nil, "forward over tls failed %v", err)
+		ut.Equal(t, resp.Header.Id, query.Header.Id)
+		ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+	}
+	ut.Equal(t, atomic.LoadInt32(accepted), int32(1))
+	ut.Assert(t, fwder.GetLastRtt() < defaultTimeout, "rtt should be tracked")
+
+	fwder, _ = NewSafeTLSFwder("tls://"+addr+"#other.example.com", caFile, defaultTimeout, 10*time.Second)
+	fwder.SetQuerySource("")
+	_, _, err = fwder.Forward(g53.MakeQuery(g53.NameFromStringUnsafe("www.knet.cn."), g53.RR_A, 1024, false))
+	ut.Assert(t, err != nil, "server with mismatched name shouldn't be trusted")
+
+	fwder, _ = NewSafeTLSFwder("tls://"+addr+"#dns.example.com", "", defaultTimeout, 10*time.Second)
+	fwder.SetQuerySource("")
+	_, _, err = fwder.Forward(g53.MakeQuery(g53.NameFromStringUnsafe("www.knet.cn."), g53.RR_A, 1024, false))
+	ut.Assert(t, err != nil, "server not signed by system ca shouldn't be trusted")
+
+	_, err = NewSafeTLSFwder("tls://"+addr, "/nonexist/ca.pem", defaultTimeout, 10*time.Second)
+	ut.Assert(t, err != nil, "missing ca file should fail")
+}
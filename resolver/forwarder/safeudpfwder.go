@@ -10,24 +10,29 @@ import (
 	vutil "github.com/ben-han-cn/vanguard/util"
 )
 
+type fwderStatus struct {
+	lastRtt              time.Duration
+	bearableFailInterval int64 //seconds
+	lastFailTime         int64 //unix seconds format
+	isDown               bool
+	statusLock           sync.Mutex
+}
+
 type SafeUDPFwder struct {
+	fwderStatus
 	fwder *vutil.SafeUDPSender
 
 	remoteAddr   string
-	lastRtt      time.Duration
 	fwderTimeout time.Duration
-
-	bearableFailInterval int64 //seconds
-	lastFailTime         int64 //unix seconds format
-	isDown               bool
-	statusLock           sync.Mutex
 }
 
 func NewSafeUDPFwder(addr string, fwderTimeout, bearableFailInterval time.Duration) (*SafeUDPFwder, error) {
 	return &SafeUDPFwder{
-		remoteAddr:           addr,
-		fwderTimeout:         fwderTimeout,
-		bearableFailInterval: int64(bearableFailInterval.Seconds()),
+		fwderStatus: fwderStatus{
+			bearableFailInterval: int64(bearableFailInterval.Seconds()),
+		},
+		remoteAddr:   addr,
+		fwderTimeout: fwderTimeout,
 	}, nil
 }
 
@@ -45,8 +50,7 @@ func (f *SafeUDPFwder) Forward(query *g53.Message) (*g53.Message, time.Duration,
 	originalQueryId := query.Header.Id
 	query.Header.Id = util.GenMessageId()
 	resp, rtt, err := f.fwder.Query(f.remoteAddr, query)
-	atomic.StoreInt64((*int64)(&f.lastRtt), int64(rtt))
-	f.checkStatus(err)
+	f.checkStatus(rtt, err)
 	query.Header.Id = originalQueryId
 	if resp != nil {
 		resp.Header.Id = originalQueryId
@@ -54,7 +58,8 @@ func (f *SafeUDPFwder) Forward(query *g53.Message) (*g53.Message, time.Duration,
 	return resp, rtt, err
 }
 
-func (f *SafeUDPFwder) checkStatus(err error) {
+func (f *fwderStatus) checkStatus(rtt time.Duration, err error) {
+	atomic.StoreInt64((*int64)(&f.lastRtt), int64(rtt))
 	f.statusLock.Lock()
 	defer f.statusLock.Unlock()
 	if err == nil {
@@ -68,13 +73,13 @@ func (f *SafeUDPFwder) checkStatus(err error) {
 	}
 }
 
-func (f *SafeUDPFwder) IsDown() bool {
+func (f *fwderStatus) IsDown() bool {
 	f.statusLock.Lock()
 	defer f.statusLock.Unlock()
 	return f.isDown
 }
 
-func (f *SafeUDPFwder) GetLastRtt() time.Duration {
+func (f *fwderStatus) GetLastRtt() time.Duration {
 	return time.Duration(atomic.LoadInt64((*int64)(&f.lastRtt)))
 }
 
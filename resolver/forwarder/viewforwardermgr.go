@@ -36,10 +36,14 @@ func NewViewFwderMgr(conf *config.VanguardConf) *ViewFwderMgr {
 }
 
 func (mgr *ViewFwderMgr) ReloadConfig(conf *config.VanguardConf) {
+	var err error
 	if mgr.repo == nil {
-		mgr.repo = NewSafeFwderRepo(&conf.Forwarder.Prober)
+		mgr.repo, err = NewSafeFwderRepo(&conf.Forwarder)
 	} else {
-		mgr.repo.ReloadConf(&conf.Forwarder.Prober)
+		err = mgr.repo.ReloadConf(&conf.Forwarder)
+	}
+	if err != nil {
+		panic("load forwarder failed:" + err.Error())
 	}
 
 	viewFwders := make(map[string]*ViewFwder)
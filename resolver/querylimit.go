@@ -4,6 +4,7 @@ import (
 	"github.com/ben-han-cn/cement/singleflight"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/ecs"
 	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/resolver/chain"
 
@@ -14,6 +15,7 @@ type QueryLimit struct {
 	chain.DefaultResolver
 	resolver      chain.Resolver
 	outQueryGroup *singleflight.Group
+	subnetPrefix  ecs.SourcePrefixes
 }
 
 func NewQueryLimit(resolver chain.Resolver, conf *config.VanguardConf) *QueryLimit {
@@ -29,6 +31,16 @@ func (limit *QueryLimit) ReloadConfig(conf *config.VanguardConf) {
 
 func (limit *QueryLimit) reloadConfig(conf *config.VanguardConf) {
 	limit.outQueryGroup = singleflight.New(uint32(conf.Server.HandlerCount * 8 / 10))
+	limit.subnetPrefix = ecs.NewSourcePrefixes(conf)
+}
+
+func (limit *QueryLimit) queryKey(client *core.Client) uint64 {
+	//answer tailored to one subnet shouldn't be shared with another
+	key := client.QueryKey()
+	if subnet := limit.subnetPrefix.Get(client.View).ClientSubnet(client); subnet != nil {
+		key ^= subnet.Hash()
+	}
+	return key
 }
 
 type resolveResponse struct {
@@ -37,7 +49,7 @@ type resolveResponse struct {
 }
 
 func (limit *QueryLimit) Resolve(client *core.Client) {
-	r_, err := limit.outQueryGroup.Do(client.QueryKey(), func() (interface{}, error) {
+	r_, err := limit.outQueryGroup.Do(limit.queryKey(client), func() (interface{}, error) {
 		limit.resolver.Resolve(client)
 		return resolveResponse{client.Response, client.CacheAnswer}, nil
 	})
@@ -59,6 +71,7 @@ func (limit *QueryLimit) Resolve(client *core.Client) {
 	if client.Request.Question.Name.Equals(r.resp.Question.Name) {
 		respCopy := *r.resp
 		respCopy.Header.Id = client.Request.Header.Id
+		ecs.Echo(&respCopy, ecs.FromEdns(client.Request.Edns))
 		client.Response = &respCopy
 		client.CacheAnswer = r.cacheAnswer
 	} else {
@@ -6,15 +6,19 @@ import (
 	"time"
 )
 
+const unreachableRtt = time.Duration(math.MaxInt64)
+
 type AddressEntry struct {
-	addr string
-	rtt  int64
+	addr   string
+	family AddrFamily
+	rtt    int64
 }
 
 func newAddressEntry(addr string, rtt time.Duration) *AddressEntry {
 	return &AddressEntry{
-		addr: addr,
-		rtt:  rtt.Nanoseconds(),
+		addr:   addr,
+		family: addrFamily(addr),
+		rtt:    rtt.Nanoseconds(),
 	}
 }
 
@@ -22,6 +26,10 @@ func (ae *AddressEntry) getAddr() string {
 	return ae.addr
 }
 
+func (ae *AddressEntry) getFamily() AddrFamily {
+	return ae.family
+}
+
 func (ae *AddressEntry) getRtt() time.Duration {
 	return time.Duration(atomic.LoadInt64(&ae.rtt)) * time.Nanosecond
 }
@@ -33,7 +41,7 @@ func (ae *AddressEntry) updateRtt(rtt time.Duration) {
 }
 
 func (ae *AddressEntry) setUnreachable() {
-	ae.updateRtt(time.Nanosecond * time.Duration(math.MaxInt64))
+	ae.updateRtt(unreachableRtt)
 }
 
 func (ae *AddressEntry) String() string {
@@ -0,0 +1,133 @@
+package recursor
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+)
+
+var errUnknownAddressFamily = errors.New("address family should be prefer_ipv4, prefer_ipv6, ipv4_only or ipv6_only")
+
+type AddrFamily uint8
+
+const (
+	IPv4 AddrFamily = 4
+	IPv6 AddrFamily = 6
+)
+
+const familyPenalty = 200 * time.Millisecond //non preferred family is used only if preferred ones are much slower
+
+func addrFamily(addr string) AddrFamily {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return IPv6
+	}
+	return IPv4
+}
+
+func (f AddrFamily) rrType() g53.RRType {
+	if f == IPv6 {
+		return g53.RR_AAAA
+	} else {
+		return g53.RR_A
+	}
+}
+
+func (f AddrFamily) String() string {
+	if f == IPv6 {
+		return "AAAA"
+	} else {
+		return "A"
+	}
+}
+
+type FamilyPolicy uint8
+
+const (
+	FamilyAny FamilyPolicy = iota
+	PreferIPv4
+	PreferIPv6
+	IPv4Only
+	IPv6Only
+)
+
+func familyPolicyFromString(s string) (FamilyPolicy, error) {
+	switch s {
+	case "":
+		return FamilyAny, nil
+	case "prefer_ipv4":
+		return PreferIPv4, nil
+	case "prefer_ipv6":
+		return PreferIPv6, nil
+	case "ipv4_only":
+		return IPv4Only, nil
+	case "ipv6_only":
+		return IPv6Only, nil
+	default:
+		return FamilyAny, errUnknownAddressFamily
+	}
+}
+
+func (p FamilyPolicy) allow(f AddrFamily) bool {
+	switch p {
+	case IPv4Only:
+		return f == IPv4
+	case IPv6Only:
+		return f == IPv6
+	default:
+		return true
+	}
+}
+
+func (p FamilyPolicy) prefer(f AddrFamily) bool {
+	switch p {
+	case PreferIPv4, IPv4Only:
+		return f == IPv4
+	case PreferIPv6, IPv6Only:
+		return f == IPv6
+	default:
+		return true
+	}
+}
+
+func (p FamilyPolicy) families() []AddrFamily {
+	switch p {
+	case IPv4Only:
+		return []AddrFamily{IPv4}
+	case IPv6Only:
+		return []AddrFamily{IPv6}
+	case PreferIPv6:
+		return []AddrFamily{IPv6, IPv4}
+	default:
+		return []AddrFamily{IPv4, IPv6}
+	}
+}
+
+func (p FamilyPolicy) weightRtt(f AddrFamily, rtt time.Duration) time.Duration {
+	//unreachable server stays unreachable
+	if p.prefer(f) || rtt > unreachableRtt-familyPenalty {
+		return rtt
+	}
+	return rtt + familyPenalty
+}
+
+func filterNameServers(servers []*NameServer, policy FamilyPolicy) []*NameServer {
+	var filtered []*NameServer
+	for _, server := range servers {
+		family := addrFamily(server.addr)
+		if policy.allow(family) {
+			filtered = append(filtered, &NameServer{
+				zone: server.zone,
+				name: server.name,
+				addr: server.addr,
+				rtt:  policy.weightRtt(family, server.rtt),
+			})
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,143 @@
+package recursor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/httpcmd"
+)
+
+type ListNsasZones struct {
+	View string `json:"view_name"`
+}
+
+func (c *ListNsasZones) String() string {
+	return fmt.Sprintf("name: list zones in nsas cache and params:{view:%s}", c.View)
+}
+
+type ListNsasNameServers struct {
+	View string `json:"view_name"`
+	Zone string `json:"zone"`
+}
+
+func (c *ListNsasNameServers) String() string {
+	return fmt.Sprintf("name: list name servers in nsas cache and params:{view:%s, zone:%s}", c.View, c.Zone)
+}
+
+type FlushNsasZone struct {
+	View string `json:"view_name"`
+	Zone string `json:"zone"`
+}
+
+func (c *FlushNsasZone) String() string {
+	return fmt.Sprintf("name: flush zone in nsas cache and params:{view:%s, zone:%s}", c.View, c.Zone)
+}
+
+type ZoneInNsas struct {
+	Zone        string   `json:"zone"`
+	NameServers []string `json:"name_servers"`
+	Ttl         int      `json:"ttl"`
+	TrustLevel  int      `json:"trust_level"`
+}
+
+type NameServerInNsas struct {
+	Name  string  `json:"name"`
+	Addr  string  `json:"addr"`
+	RttMs float64 `json:"rtt_ms"`
+	Ttl   int     `json:"ttl"`
+}
+
+func (r *Recursor) HandleCmd(cmd httpcmd.Command) (interface{}, *httpcmd.Error) {
+	switch c := cmd.(type) {
+	case *ListNsasZones:
+		return r.listNsasZones(c.View)
+	case *ListNsasNameServers:
+		return r.listNsasNameServers(c.View, c.Zone)
+	case *FlushNsasZone:
+		return r.flushNsasZone(c.View, c.Zone)
+	default:
+		panic("shouldn't be here")
+	}
+}
+
+func (r *Recursor) getNsasCache(view string) (*NsasCache, *httpcmd.Error) {
+	if nsasCache, ok := r.nsasForView[view]; ok {
+		return nsasCache, nil
+	} else {
+		return nil, ErrUnknownRecursorView.AddDetail(view)
+	}
+}
+
+func (r *Recursor) listNsasZones(view string) (interface{}, *httpcmd.Error) {
+	nsasCache, err := r.getNsasCache(view)
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []ZoneInNsas
+	for _, e := range nsasCache.GetZones() {
+		if e.isExpired() {
+			continue
+		}
+		zone := ZoneInNsas{
+			Zone:       e.zone.String(false),
+			Ttl:        int(time.Until(e.expireTime).Seconds()),
+			TrustLevel: int(e.trustLevel),
+		}
+		for _, name := range e.nameServers {
+			zone.NameServers = append(zone.NameServers, name.String(false))
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+func (r *Recursor) listNsasNameServers(view, zone string) (interface{}, *httpcmd.Error) {
+	var zoneName *g53.Name
+	if zone != "" {
+		var err error
+		if zoneName, err = g53.NameFromString(zone); err != nil {
+			return nil, httpcmd.ErrInvalidName.AddDetail(err.Error())
+		}
+	}
+
+	nsasCache, cmdErr := r.getNsasCache(view)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+
+	var servers []NameServerInNsas
+	for _, e := range nsasCache.GetNameServers(zoneName) {
+		if e.isExpired() {
+			continue
+		}
+		ttl := int(time.Until(e.expireTime).Seconds())
+		for _, addr := range e.addrEntrys {
+			servers = append(servers, NameServerInNsas{
+				Name:  e.name.String(false),
+				Addr:  addr.getAddr(),
+				RttMs: addr.getRtt().Seconds() * 1000,
+				Ttl:   ttl,
+			})
+		}
+	}
+	return servers, nil
+}
+
+func (r *Recursor) flushNsasZone(view, zone string) (interface{}, *httpcmd.Error) {
+	zoneName, err := g53.NameFromString(zone)
+	if err != nil {
+		return nil, httpcmd.ErrInvalidName.AddDetail(err.Error())
+	}
+
+	nsasCache, cmdErr := r.getNsasCache(view)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+
+	if nsasCache.FlushZone(zoneName) == false {
+		return nil, ErrNonExistNsasZone.AddDetail(zone)
+	}
+	return nil, nil
+}
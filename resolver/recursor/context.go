@@ -5,65 +5,127 @@ import (
 	"time"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
+	"github.com/ben-han-cn/vanguard/metrics"
 	"github.com/ben-han-cn/vanguard/util"
 )
 
 type RecursorCtx struct {
-	sender        *util.SafeUDPSender
-	question      *g53.Question
-	clientAddress string
-	depth         uint32
-	startTime     time.Time
-	nameServers   []*NameServer
+	sender       *util.SafeUDPSender
+	timeout      time.Duration
+	limits       *recursorLimits
+	question     *g53.Question
+	clientSubnet *ecs.ClientSubnet
+	depth        uint32
+	startTime    time.Time
+	nameServers  []*NameServer
+	dnssec       bool
+	validator    *Validator
+	minimise     bool
+	family       FamilyPolicy
+	nsasCache    *NsasCache
+	probeZone    *g53.Name
+	probeLabels  uint
+	probeCount   int
 }
 
-func (ctx *RecursorCtx) init(queryTimeout time.Duration, querySource string, clientAddress string, question *g53.Question, nameServers []*NameServer) {
-	if ctx.sender == nil || ctx.sender.GetQuerySource() != querySource {
-		sender, _ := util.NewSafeUDPSender(querySource, queryTimeout)
+func (ctx *RecursorCtx) init(limits *recursorLimits, querySource string, clientSubnet *ecs.ClientSubnet, question *g53.Question, nameServers []*NameServer) {
+	if ctx.sender == nil || ctx.sender.GetQuerySource() != querySource || ctx.timeout != limits.singleQueryTimeout {
+		sender, _ := util.NewSafeUDPSender(querySource, limits.singleQueryTimeout)
 		ctx.sender = sender
+		ctx.timeout = limits.singleQueryTimeout
 	}
+	ctx.limits = limits
 	ctx.question = question
-	ctx.clientAddress = clientAddress
+	ctx.clientSubnet = clientSubnet
 	ctx.depth = 0
 	ctx.startTime = time.Now()
 	ctx.nameServers = nameServers
+	ctx.dnssec = false
+	ctx.validator = nil
+	ctx.minimise = false
+	ctx.family = FamilyAny
+	ctx.nsasCache = nil
+	ctx.probeZone = nil
+	ctx.probeLabels = 0
+	ctx.probeCount = 0
+}
+
+func (ctx *RecursorCtx) minimisedName(zone *g53.Name) (*g53.Name, bool) {
+	//expose one more label than the closest known zone, or than the last
+	//probed name if it turns out not to be a zone cut
+	qname := ctx.question.Name
+	labels := zone.LabelCount() + 1
+	if ctx.probeZone != nil && ctx.probeZone.Equals(zone) {
+		labels = ctx.probeLabels + 1
+	}
+	if labels >= qname.LabelCount() || ctx.question.Type == g53.RR_DS || ctx.probeCount >= maxMinimiseCount {
+		return qname, false
+	}
+
+	ctx.probeZone = zone
+	ctx.probeLabels = labels
+	ctx.probeCount += 1
+	name, _ := qname.Parent(qname.LabelCount() - labels)
+	return name, true
 }
 
 type RecursorCtxPool struct {
-	ctxes []*RecursorCtx
-	mu    sync.Mutex
+	ctxes    []*RecursorCtx
+	inflight int
+	max      int
+	mu       sync.Mutex
 }
 
 func newRecursorCtxPool(max int) *RecursorCtxPool {
-	ctxes := make([]*RecursorCtx, max)
-	for i := 0; i < max; i++ {
-		ctxes[i] = &RecursorCtx{}
-	}
-
 	return &RecursorCtxPool{
-		ctxes: ctxes,
+		max: max,
 	}
 }
 
 func (p *RecursorCtxPool) getCtx() *RecursorCtx {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for i, ctx := range p.ctxes {
-		if ctx != nil {
-			p.ctxes[i] = nil
-			return ctx
-		}
+	if p.inflight >= p.max {
+		metrics.RecordRecursorReject()
+		return nil
+	}
+
+	var ctx *RecursorCtx
+	if c := len(p.ctxes); c > 0 {
+		ctx = p.ctxes[c-1]
+		p.ctxes = p.ctxes[:c-1]
+	} else {
+		ctx = &RecursorCtx{}
 	}
-	return nil
+	p.inflight += 1
+	metrics.RecordRecursorInflight(p.inflight)
+	return ctx
 }
 
 func (p *RecursorCtxPool) putCtx(ctx *RecursorCtx) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for i, ctx_ := range p.ctxes {
-		if ctx_ == nil {
-			p.ctxes[i] = ctx
-			break
-		}
+	p.inflight -= 1
+	metrics.RecordRecursorInflight(p.inflight)
+	if len(p.ctxes) < p.max {
+		p.ctxes = append(p.ctxes, ctx)
 	}
 }
+
+func (p *RecursorCtxPool) resize(max int) {
+	//ctx in use is returned as usual, the pool only stops lending new
+	//ones when it's shrunk below the inflight count
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.max = max
+	if len(p.ctxes) > max {
+		p.ctxes = p.ctxes[:max]
+	}
+}
+
+func (p *RecursorCtxPool) inflightCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inflight
+}
@@ -37,3 +37,24 @@ func TestCtxPool(t *testing.T) {
 		ut.Assert(t, p.getCtx() == ctx, "")
 	}
 }
+
+func TestCtxPoolResize(t *testing.T) {
+	p := newRecursorCtxPool(2)
+	ctxes := []*RecursorCtx{p.getCtx(), p.getCtx()}
+	ut.Assert(t, ctxes[0] != nil && ctxes[1] != nil, "")
+	ut.Assert(t, p.getCtx() == nil, "")
+
+	p.resize(3)
+	ctxes = append(ctxes, p.getCtx())
+	ut.Assert(t, ctxes[2] != nil, "")
+	ut.Equal(t, p.inflightCount(), 3)
+
+	p.resize(1)
+	for _, ctx := range ctxes {
+		p.putCtx(ctx)
+	}
+	ut.Equal(t, p.inflightCount(), 0)
+	ut.Equal(t, len(p.ctxes), 1)
+	ut.Assert(t, p.getCtx() != nil, "")
+	ut.Assert(t, p.getCtx() == nil, "")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/util"
 )
 
 var errNameServerIsOutOfQuery = errors.New("name server isn't parent of query name")
@@ -11,10 +12,10 @@ var errAuthSectionIsNotValid = errors.New("auth section should has one ns rrset"
 
 func getAuthAndGlues(zone *g53.Name, msg *g53.Message) (*g53.RRset, []*g53.RRset, error) {
 	var auth g53.Section
-	if msg.Question.Type == g53.RR_NS && len(msg.Sections[g53.AnswerSection]) == 1 {
-		auth = msg.Sections[g53.AnswerSection]
+	if answer := util.WithoutDNSSECRRsets(msg.Sections[g53.AnswerSection], msg.Question.Type); msg.Question.Type == g53.RR_NS && len(answer) == 1 {
+		auth = answer
 	} else {
-		auth = msg.Sections[g53.AuthSection]
+		auth = util.WithoutDNSSECRRsets(msg.Sections[g53.AuthSection], msg.Question.Type)
 		if len(auth) != 1 {
 			return nil, nil, errAuthSectionIsNotValid
 		}
@@ -29,19 +30,21 @@ func getAuthAndGlues(zone *g53.Name, msg *g53.Message) (*g53.RRset, []*g53.RRset
 	validGlues := []*g53.RRset{}
 	for _, rdata := range nsRRset.Rdatas {
 		nameServerName := rdata.(*g53.NS).Name
-		validGlue := &g53.RRset{
-			Name:  nameServerName,
-			Type:  g53.RR_A,
-			Class: g53.CLASS_IN,
-		}
-		for _, glue := range glues {
-			if glue.Type == g53.RR_A && glue.Name.Equals(nameServerName) {
-				validGlue.Rdatas = append(validGlue.Rdatas, glue.Rdatas...)
-				validGlue.Ttl = glue.Ttl
+		for _, typ := range []g53.RRType{g53.RR_A, g53.RR_AAAA} {
+			validGlue := &g53.RRset{
+				Name:  nameServerName,
+				Type:  typ,
+				Class: g53.CLASS_IN,
+			}
+			for _, glue := range glues {
+				if glue.Type == typ && glue.Name.Equals(nameServerName) {
+					validGlue.Rdatas = append(validGlue.Rdatas, glue.Rdatas...)
+					validGlue.Ttl = glue.Ttl
+				}
+			}
+			if len(validGlue.Rdatas) > 0 {
+				validGlues = append(validGlues, validGlue)
 			}
-		}
-		if len(validGlue.Rdatas) > 0 {
-			validGlues = append(validGlues, validGlue)
 		}
 	}
 
@@ -53,9 +56,9 @@ func isValidResponse(msg *g53.Message) bool {
 }
 
 //ns glue shouldn't have cname
-func getARRsetFromAnswer(msg *g53.Message) *g53.RRset {
+func getAddrRRsetFromAnswer(msg *g53.Message) *g53.RRset {
 	answer := msg.Sections[g53.AnswerSection]
-	if len(answer) == 1 && answer[0].Type == g53.RR_A && answer[0].Name.Equals(msg.Question.Name) {
+	if len(answer) == 1 && (answer[0].Type == g53.RR_A || answer[0].Type == g53.RR_AAAA) && answer[0].Type == msg.Question.Type && answer[0].Name.Equals(msg.Question.Name) {
 		return answer[0]
 	} else {
 		return nil
@@ -10,4 +10,6 @@ var (
 	ErrHintZoneExist       = httpcmd.NewError(httpcmd.RecursorErrCodeStart+2, "already has root configuration")
 	ErrRootZoneNameInvalid = httpcmd.NewError(httpcmd.RecursorErrCodeStart+3, "root zone NS name must be (.) ")
 	ErrNonExistHintZone    = httpcmd.NewError(httpcmd.RecursorErrCodeStart+4, "operate non-exist root zone")
+	ErrUnknownRecursorView = httpcmd.NewError(httpcmd.RecursorErrCodeStart+5, "view has no recursor")
+	ErrNonExistNsasZone    = httpcmd.NewError(httpcmd.RecursorErrCodeStart+6, "zone isn't in nsas cache")
 )
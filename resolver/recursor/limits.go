@@ -0,0 +1,55 @@
+package recursor
+
+import (
+	"time"
+
+	"github.com/ben-han-cn/vanguard/config"
+)
+
+const (
+	defaultMaxQueryDepth      = 20
+	defaultMaxInflightQuery   = 100
+	defaultSingleQueryTimeout = 3 * time.Second
+	defaultQueryTimeout       = 15 * time.Second
+	defaultBatchQueryCount    = 3 //max server to query in parallel
+)
+
+type recursorLimits struct {
+	maxQueryDepth      uint32
+	maxInflightQuery   int
+	singleQueryTimeout time.Duration
+	queryTimeout       time.Duration
+	batchQueryCount    int
+	maxZoneCount       int
+}
+
+func newRecursorLimits(conf *config.RecursorConf) *recursorLimits {
+	limits := &recursorLimits{
+		maxQueryDepth:      defaultMaxQueryDepth,
+		maxInflightQuery:   defaultMaxInflightQuery,
+		singleQueryTimeout: defaultSingleQueryTimeout,
+		queryTimeout:       defaultQueryTimeout,
+		batchQueryCount:    defaultBatchQueryCount,
+		maxZoneCount:       DefaultMaxCacheSize,
+	}
+
+	if conf.MaxQueryDepth > 0 {
+		limits.maxQueryDepth = conf.MaxQueryDepth
+	}
+	if conf.MaxInflightQuery > 0 {
+		limits.maxInflightQuery = conf.MaxInflightQuery
+	}
+	if conf.SingleQueryTimeout > 0 {
+		limits.singleQueryTimeout = time.Duration(conf.SingleQueryTimeout) * time.Second
+	}
+	if conf.QueryTimeout > 0 {
+		limits.queryTimeout = time.Duration(conf.QueryTimeout) * time.Second
+	}
+	if conf.BatchQueryCount > 0 {
+		limits.batchQueryCount = conf.BatchQueryCount
+	}
+	if conf.MaxZoneCount > 0 {
+		limits.maxZoneCount = conf.MaxZoneCount
+	}
+	return limits
+}
@@ -22,7 +22,7 @@ type NameServer struct {
 }
 
 func (ns *NameServer) String() string {
-	return fmt.Sprintf("[%s ns %s A %s]", ns.zone.String(true), ns.name.String(true), ns.addr)
+	return fmt.Sprintf("[%s ns %s %s %s]", ns.zone.String(true), ns.name.String(true), addrFamily(ns.addr).String(), ns.addr)
 }
 
 type NameServerEntry struct {
@@ -66,16 +66,22 @@ func (ns *NameServerEntry) getNameServers(zone *g53.Name) []*NameServer {
 	return nameServers
 }
 
-func (ns *NameServerEntry) selectNameServer() *NameServer {
-	minRtt := ns.addrEntrys[0].getRtt()
-	selectEntry := ns.addrEntrys[0]
-	for i := 1; i < len(ns.addrEntrys); i++ {
-		rtt := ns.addrEntrys[i].getRtt()
-		if rtt < minRtt {
+func (ns *NameServerEntry) selectNameServer(policy FamilyPolicy) *NameServer {
+	var selectEntry *AddressEntry
+	var minRtt time.Duration
+	for _, entry := range ns.addrEntrys {
+		if policy.allow(entry.family) == false {
+			continue
+		}
+		rtt := policy.weightRtt(entry.family, entry.getRtt())
+		if selectEntry == nil || rtt < minRtt {
 			minRtt = rtt
-			selectEntry = ns.addrEntrys[i]
+			selectEntry = entry
 		}
 	}
+	if selectEntry == nil {
+		return nil
+	}
 
 	return &NameServer{
 		name: ns.name,
@@ -84,6 +90,24 @@ func (ns *NameServerEntry) selectNameServer() *NameServer {
 	}
 }
 
+func (ns *NameServerEntry) hasFamily(family AddrFamily) bool {
+	for _, entry := range ns.addrEntrys {
+		if entry.family == family {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns *NameServerEntry) hasAddr(addr string) bool {
+	for _, entry := range ns.addrEntrys {
+		if entry.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
 func (ns *NameServerEntry) updateRtt(nameServer *NameServer, rtt time.Duration) error {
 	for _, server := range ns.addrEntrys {
 		if server.addr == nameServer.addr {
@@ -94,6 +118,29 @@ func (ns *NameServerEntry) updateRtt(nameServer *NameServer, rtt time.Duration)
 	return errAddrIsUnknown
 }
 
+func (ns *NameServerEntry) merge(other *NameServerEntry) *NameServerEntry {
+	var newEntrys []*AddressEntry
+	for _, entry := range other.addrEntrys {
+		if ns.hasAddr(entry.addr) == false {
+			newEntrys = append(newEntrys, entry)
+		}
+	}
+	if len(newEntrys) == 0 {
+		return nil
+	}
+
+	merged := &NameServerEntry{
+		name:       ns.name,
+		addrEntrys: append(append([]*AddressEntry{}, ns.addrEntrys...), newEntrys...),
+		expireTime: ns.expireTime,
+		trustLevel: ns.trustLevel,
+	}
+	if other.expireTime.Before(merged.expireTime) {
+		merged.expireTime = other.expireTime
+	}
+	return merged
+}
+
 func (nse *NameServerEntry) isExpired() bool {
 	return nse.expireTime.Before(time.Now())
 }
@@ -118,9 +165,15 @@ func (ns *NameServerManager) addNameServer(name *g53.Name, ttl time.Duration, ad
 	key := name.Hash(false)
 	ns.lock.Lock()
 	defer ns.lock.Unlock()
-	if oldEntry, ok := ns.nsEntrys[key]; ok {
-		if oldEntry.isExpired() == false && oldEntry.trustLevel >= trustLevel {
+	if oldEntry, ok := ns.nsEntrys[key]; ok && oldEntry.isExpired() == false {
+		if oldEntry.trustLevel > trustLevel {
 			return
+		} else if oldEntry.trustLevel == trustLevel {
+			//a and aaaa glue arrive separately, merge them and keep the rtt
+			//of known addresses
+			if e = oldEntry.merge(e); e == nil {
+				return
+			}
 		}
 	}
 	ns.nsEntrys[name.Hash(false)] = e
@@ -137,6 +190,16 @@ func (ns *NameServerManager) getNameServer(name *g53.Name) *NameServerEntry {
 	}
 }
 
+func (ns *NameServerManager) getNames() []*g53.Name {
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+	names := make([]*g53.Name, 0, len(ns.nsEntrys))
+	for _, e := range ns.nsEntrys {
+		names = append(names, e.name)
+	}
+	return names
+}
+
 func (ns *NameServerManager) updateRtt(nameServer *NameServer, rtt time.Duration) error {
 	ns.lock.Lock()
 	defer ns.lock.Unlock()
@@ -2,6 +2,7 @@ package recursor
 
 import (
 	"container/list"
+	"net"
 	"sync"
 	"time"
 
@@ -39,7 +40,7 @@ func NewNsasCache(maxCacheSize int) *NsasCache {
 	return cache
 }
 
-func (nc *NsasCache) AddZoneNameServer(zone *g53.Name, msg *g53.Message) ([]*g53.Name, []*g53.Name) {
+func (nc *NsasCache) AddZoneNameServer(zone *g53.Name, msg *g53.Message, policy FamilyPolicy) ([]*g53.Name, []*g53.Name) {
 	nsRRset, glues, err := getAuthAndGlues(zone, msg)
 	if err != nil {
 		return nil, nil
@@ -57,14 +58,24 @@ func (nc *NsasCache) AddZoneNameServer(zone *g53.Name, msg *g53.Message) ([]*g53
 	if msg.Header.GetFlag(g53.FLAG_AA) {
 		trustLevel = FromAuth
 	}
-	return nc.addZone(nsRRset, trustLevel)
+	return nc.addZone(nsRRset, trustLevel, policy)
+}
+
+func (nc *NsasCache) SetMaxCacheSize(maxCacheSize int) {
+	if maxCacheSize <= 0 {
+		maxCacheSize = DefaultMaxCacheSize
+	}
+
+	nc.zonesLock.Lock()
+	defer nc.zonesLock.Unlock()
+	nc.maxCacheSize = maxCacheSize
 }
 
 func (nc *NsasCache) zoneCount() int {
 	return nc.visitedZone.Len()
 }
 
-func (nc *NsasCache) addZone(nsRRset *g53.RRset, trustLevel TrustLevel) ([]*g53.Name, []*g53.Name) {
+func (nc *NsasCache) addZone(nsRRset *g53.RRset, trustLevel TrustLevel, policy FamilyPolicy) ([]*g53.Name, []*g53.Name) {
 	zone := nsRRset.Name
 	serverNames := []*g53.Name{}
 	missingServerNames := []*g53.Name{}
@@ -73,10 +84,11 @@ func (nc *NsasCache) addZone(nsRRset *g53.RRset, trustLevel TrustLevel) ([]*g53.
 		serverName := nsRdata.(*g53.NS).Name
 		serverNames = append(serverNames, serverName)
 		e := nc.nameServers.getNameServer(serverName)
-		if e == nil || e.isExpired() {
+		if e == nil || e.isExpired() || e.selectNameServer(policy) == nil {
 			missingServerNames = append(missingServerNames, serverName)
-		} else if e.trustLevel == OutOfZone {
-			//we will use the server this time, but probe the server in backend thread
+		} else if e.trustLevel == OutOfZone || (policy != FamilyAny && e.hasFamily(policy.families()[0]) == false) {
+			//we will use the server this time, but probe the server or its
+			//preferred address in backend thread
 			missingServerNames = append(missingServerNames, serverName)
 			knownServerNames = append(knownServerNames, serverName)
 		} else {
@@ -102,13 +114,13 @@ func (nc *NsasCache) addZone(nsRRset *g53.RRset, trustLevel TrustLevel) ([]*g53.
 	return missingServerNames, knownServerNames
 }
 
-func (nc *NsasCache) SelectNameServers(zone *g53.Name) []*NameServer {
+func (nc *NsasCache) SelectNameServers(zone *g53.Name, policy FamilyPolicy) []*NameServer {
 	nc.zonesLock.Lock()
 	defer nc.zonesLock.Unlock()
-	return nc.selectNameServers(zone)
+	return nc.selectNameServers(zone, policy)
 }
 
-func (nc *NsasCache) selectNameServers(zone *g53.Name) []*NameServer {
+func (nc *NsasCache) selectNameServers(zone *g53.Name, policy FamilyPolicy) []*NameServer {
 	_, node, searchResult := nc.zones.Search(zone)
 	if searchResult == domaintree.NotFound {
 		return nil
@@ -118,12 +130,12 @@ func (nc *NsasCache) selectNameServers(zone *g53.Name) []*NameServer {
 	e := elem.Value.(*ZoneEntry)
 	if e.isExpired() {
 		nc.removeZone(elem)
-		return nc.selectNameServers(zone)
+		return nc.selectNameServers(zone, policy)
 	} else {
-		servers := e.selectNameServer(nc.nameServers)
+		servers := e.selectNameServer(nc.nameServers, policy)
 		if len(servers) == 0 {
 			nc.removeZone(elem)
-			return nc.selectNameServers(zone)
+			return nc.selectNameServers(zone, policy)
 		} else {
 			nc.visitedZone.MoveToFront(elem)
 			return servers
@@ -138,6 +150,49 @@ func (nc *NsasCache) removeZone(elem *list.Element) {
 	nc.nameServers.deleteNameServers(elem.Value.(*ZoneEntry).nameServers)
 }
 
+func (nc *NsasCache) GetZones() []*ZoneEntry {
+	nc.zonesLock.Lock()
+	defer nc.zonesLock.Unlock()
+	zones := make([]*ZoneEntry, 0, nc.zoneCount())
+	for elem := nc.visitedZone.Front(); elem != nil; elem = elem.Next() {
+		zones = append(zones, elem.Value.(*ZoneEntry))
+	}
+	return zones
+}
+
+func (nc *NsasCache) GetNameServers(zone *g53.Name) []*NameServerEntry {
+	var names []*g53.Name
+	if zone == nil {
+		names = nc.nameServers.getNames()
+	} else {
+		nc.zonesLock.Lock()
+		_, node, searchResult := nc.zones.Search(zone)
+		if searchResult == domaintree.ExactMatch {
+			names = node.(*list.Element).Value.(*ZoneEntry).nameServers
+		}
+		nc.zonesLock.Unlock()
+	}
+
+	var entries []*NameServerEntry
+	for _, name := range names {
+		if e := nc.nameServers.getNameServer(name); e != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func (nc *NsasCache) FlushZone(zone *g53.Name) bool {
+	nc.zonesLock.Lock()
+	defer nc.zonesLock.Unlock()
+	_, node, searchResult := nc.zones.Search(zone)
+	if searchResult != domaintree.ExactMatch {
+		return false
+	}
+	nc.removeZone(node.(*list.Element))
+	return true
+}
+
 func (nc *NsasCache) UpdateRtt(server *NameServer, rtt time.Duration) error {
 	return nc.nameServers.updateRtt(server, rtt)
 }
@@ -145,7 +200,7 @@ func (nc *NsasCache) UpdateRtt(server *NameServer, rtt time.Duration) error {
 func (nc *NsasCache) addNameServer(glue *g53.RRset, trustLevel TrustLevel) {
 	addrs := []string{}
 	for _, rdata := range glue.Rdatas {
-		addrs = append(addrs, rdata.String()+":53")
+		addrs = append(addrs, net.JoinHostPort(rdata.String(), "53"))
 	}
 	nc.nameServers.addNameServer(glue.Name, time.Duration(glue.Ttl)*time.Second, addrs, trustLevel)
 }
@@ -1,7 +1,10 @@
 package recursor
 
 import (
+	"sort"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	ut "github.com/ben-han-cn/cement/unittest"
 	"github.com/ben-han-cn/g53"
@@ -37,7 +40,7 @@ func buildISCORGNSMessage() *g53.Message {
 
 func TestNSASCacheSelectNameServer(t *testing.T) {
 	cache := NewNsasCache(10)
-	missing, known := cache.AddZoneNameServer(g53.NameFromStringUnsafe("isc.org."), buildISCORGNSMessage())
+	missing, known := cache.AddZoneNameServer(g53.NameFromStringUnsafe("isc.org."), buildISCORGNSMessage(), FamilyAny)
 	ut.Equal(t, len(missing), 1)
 	ut.Assert(t, missing[0].Equals(g53.NameFromStringUnsafe("ns.isc.afilias-nst.info.")), "")
 	ut.Equal(t, len(known), 3)
@@ -45,20 +48,77 @@ func TestNSASCacheSelectNameServer(t *testing.T) {
 	cache.EnforceMemoryLimit()
 	ut.Equal(t, cache.zoneCount(), 1)
 
-	nameServers := cache.SelectNameServers(g53.NameFromStringUnsafe("xxx.isc.org."))
+	nameServers := cache.SelectNameServers(g53.NameFromStringUnsafe("xxx.isc.org."), FamilyAny)
 	ut.Equal(t, len(nameServers), 3)
-	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("xxx.isc.org."))
+	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("xxx.isc.org."), FamilyAny)
 	ut.Equal(t, len(nameServers), 3)
-	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("org."))
+	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("org."), FamilyAny)
 	ut.Equal(t, len(nameServers), 0)
 
 	for _, ns := range []string{"ord.sns-pb.isc.org.", "ams.sns-pb.isc.org.", "sfba.sns-pb.isc.org."} {
 		nameServer := cache.nameServers.getNameServer(g53.NameFromStringUnsafe(ns))
-		//only v4 address is added
-		ut.Equal(t, len(nameServer.addrEntrys), 1)
+		//both v4 and v6 address are added
+		ut.Equal(t, len(nameServer.addrEntrys), 2)
+		ut.Assert(t, nameServer.hasFamily(IPv4) && nameServer.hasFamily(IPv6), "")
 	}
 }
 
+func TestNSASCacheAddressFamily(t *testing.T) {
+	cache := NewNsasCache(10)
+	missing, known := cache.AddZoneNameServer(g53.NameFromStringUnsafe("isc.org."), buildISCORGNSMessage(), IPv6Only)
+	ut.Equal(t, len(missing), 1)
+	ut.Equal(t, len(known), 3)
+
+	zone := g53.NameFromStringUnsafe("xxx.isc.org.")
+	for _, server := range cache.SelectNameServers(zone, IPv6Only) {
+		ut.Equal(t, addrFamily(server.addr), IPv6)
+	}
+	for _, server := range cache.SelectNameServers(zone, PreferIPv4) {
+		ut.Equal(t, addrFamily(server.addr), IPv4)
+	}
+
+	//non preferred family is used once preferred one is unreachable
+	ams := cache.nameServers.getNameServer(g53.NameFromStringUnsafe("ams.sns-pb.isc.org."))
+	for _, entry := range ams.addrEntrys {
+		if entry.getFamily() == IPv6 {
+			ut.Equal(t, entry.getAddr(), "[2001:500:60::30]:53")
+			atomic.StoreInt64(&entry.rtt, int64(unreachableRtt))
+		}
+	}
+	ut.Equal(t, ams.selectNameServer(PreferIPv6).addr, "199.6.1.30:53")
+	ut.Assert(t, ams.selectNameServer(IPv6Only).rtt == unreachableRtt, "")
+
+	//a and aaaa glue added separately are merged
+	name := g53.NameFromStringUnsafe("ns.isc.afilias-nst.info.")
+	cache.nameServers.addNameServer(name, time.Hour, []string{"199.254.63.254:53"}, FromAuth)
+	cache.nameServers.addNameServer(name, time.Hour, []string{"[2001:500:2c::254]:53"}, FromAuth)
+	cache.nameServers.addNameServer(name, time.Hour, []string{"199.254.63.254:53"}, FromAuth)
+	ut.Equal(t, len(cache.nameServers.getNameServer(name).addrEntrys), 2)
+	cache.nameServers.addNameServer(name, time.Hour, []string{"1.1.1.1:53"}, OutOfZone)
+	ut.Equal(t, len(cache.nameServers.getNameServer(name).addrEntrys), 2)
+
+	servers := filterNameServers(getDefaultRootServers(), IPv6Only)
+	ut.Equal(t, len(servers), len(rootServers))
+	servers = filterNameServers(getDefaultRootServers(), PreferIPv4)
+	ut.Equal(t, len(servers), len(rootServers)*2)
+	sort.Sort(ServerByRtt(servers))
+	ut.Equal(t, addrFamily(servers[0].addr), IPv4)
+	ut.Equal(t, addrFamily(servers[len(servers)-1].addr), IPv6)
+}
+
+func TestLoadRootServer(t *testing.T) {
+	servers, err := loadRootServer(`. 3600000 IN NS a.root-servers.net.
+a.root-servers.net. 3600000 IN A 198.41.0.4
+a.root-servers.net. 3600000 IN AAAA 2001:503:ba3e::2:30`)
+	ut.Assert(t, err == nil, "")
+	ut.Equal(t, len(servers), 2)
+	ut.Equal(t, servers[0].addr, "198.41.0.4:53")
+	ut.Equal(t, servers[1].addr, "[2001:503:ba3e::2:30]:53")
+
+	_, err = loadRootServer("a.root-servers.net. 3600000 IN TXT abc")
+	ut.Equal(t, err, errUnsupportRRType)
+}
+
 func buildHeader(id uint16, setFlag []g53.FlagField, counts []uint16, opcode g53.Opcode, rcode g53.Rcode) g53.Header {
 	h := g53.Header{
 		Id:      id,
@@ -131,7 +191,7 @@ func TestNSASCacheMemoryEnforce(t *testing.T) {
 	cache := NewNsasCache(4)
 	for _, name := range []string{"knet.cn.", "knet.com", "com.", "cn.", "org."} {
 		zone := g53.NameFromStringUnsafe(name)
-		missing, known := cache.AddZoneNameServer(zone, buildFackNSResponse(zone))
+		missing, known := cache.AddZoneNameServer(zone, buildFackNSResponse(zone), FamilyAny)
 		ut.Equal(t, len(missing), 0)
 		ut.Equal(t, len(known), 2)
 	}
@@ -140,7 +200,7 @@ func TestNSASCacheMemoryEnforce(t *testing.T) {
 	ut.Equal(t, len(nameServer.addrEntrys), 2)
 	cache.EnforceMemoryLimit()
 	ut.Equal(t, cache.zoneCount(), 4)
-	nameServers := cache.SelectNameServers(g53.NameFromStringUnsafe("a.knet.cn"))
+	nameServers := cache.SelectNameServers(g53.NameFromStringUnsafe("a.knet.cn"), FamilyAny)
 	ut.Equal(t, len(nameServers), 2)
 	ut.Assert(t, nameServers[0].zone.Equals(g53.NameFromStringUnsafe("cn.")), "")
 	ut.Assert(t, nameServers[1].zone.Equals(g53.NameFromStringUnsafe("cn.")), "")
@@ -148,11 +208,11 @@ func TestNSASCacheMemoryEnforce(t *testing.T) {
 	ut.Assert(t, nameServer == nil, "")
 
 	knet_cn := g53.NameFromStringUnsafe("knet.cn.")
-	cache.AddZoneNameServer(knet_cn, buildFackNSResponse(knet_cn))
-	cache.SelectNameServers(g53.NameFromStringUnsafe("knet.com"))
+	cache.AddZoneNameServer(knet_cn, buildFackNSResponse(knet_cn), FamilyAny)
+	cache.SelectNameServers(g53.NameFromStringUnsafe("knet.com"), FamilyAny)
 	cache.EnforceMemoryLimit()
 	ut.Equal(t, cache.zoneCount(), 4)
-	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("a.com."))
+	nameServers = cache.SelectNameServers(g53.NameFromStringUnsafe("a.com."), FamilyAny)
 	ut.Equal(t, len(nameServers), 0)
 	nameServer = cache.nameServers.getNameServer(g53.NameFromStringUnsafe("ns1.com."))
 	ut.Assert(t, nameServer == nil, "")
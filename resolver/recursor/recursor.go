@@ -3,13 +3,19 @@ package recursor
 import (
 	"errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ben-han-cn/g53"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/ecs"
+	"github.com/ben-han-cn/vanguard/httpcmd"
 	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/resolver/chain"
 	"github.com/ben-han-cn/vanguard/resolver/querysource"
@@ -21,57 +27,79 @@ var errTooDepQuery = errors.New("query exceed depth limit")
 var errInvalidResponse = errors.New("response is invalid")
 var errQueryTimeout = errors.New("query time out")
 var errDumbNameServer = errors.New("auth name server is dumb")
+var errQueryExceedLimit = errors.New("out recusive query exceed limit")
 
-const maxQueryDep = 20
-const maxInflightQuery = 100
-const singleQueryTimeout = 3 * time.Second
-const queryTimeout = 15 * time.Second
-const batchQueryCount = 3 //max server to query in parallel
 const memoryCheckInterval = 10 * time.Second
-
-var rootServers = map[string]string{
-	"a.root-servers.net.": "198.41.0.4:53",
-	"b.root-servers.net.": "192.228.79.201:53",
-	"c.root-servers.net.": "192.33.4.12:53",
-	"d.root-servers.net.": "199.7.91.13:53",
-	"e.root-servers.net.": "192.203.230.10:53",
-	"f.root-servers.net.": "192.5.5.241:53",
-	"g.root-servers.net.": "192.112.36.4:53",
-	"h.root-servers.net.": "198.97.190.53:53",
-	"i.root-servers.net.": "192.36.148.17:53",
-	"j.root-servers.net.": "192.58.128.30:53",
-	"k.root-servers.net.": "193.0.14.129:53",
-	"l.root-servers.net.": "199.7.83.42:53",
-	"m.root-servers.net.": "202.12.27.33:53",
+const maxMinimiseCount = 10 //rfc9156 MAX_MINIMISE_COUNT
+
+var rootServers = map[string][]string{
+	"a.root-servers.net.": []string{"198.41.0.4", "2001:503:ba3e::2:30"},
+	"b.root-servers.net.": []string{"192.228.79.201", "2001:500:200::b"},
+	"c.root-servers.net.": []string{"192.33.4.12", "2001:500:2::c"},
+	"d.root-servers.net.": []string{"199.7.91.13", "2001:500:2d::d"},
+	"e.root-servers.net.": []string{"192.203.230.10", "2001:500:a8::e"},
+	"f.root-servers.net.": []string{"192.5.5.241", "2001:500:2f::f"},
+	"g.root-servers.net.": []string{"192.112.36.4", "2001:500:12::d0d"},
+	"h.root-servers.net.": []string{"198.97.190.53", "2001:500:1::53"},
+	"i.root-servers.net.": []string{"192.36.148.17", "2001:7fe::53"},
+	"j.root-servers.net.": []string{"192.58.128.30", "2001:503:c27::2:30"},
+	"k.root-servers.net.": []string{"193.0.14.129", "2001:7fd::1"},
+	"l.root-servers.net.": []string{"199.7.83.42", "2001:500:9f::42"},
+	"m.root-servers.net.": []string{"202.12.27.33", "2001:dc3::35"},
 }
 
 type Recursor struct {
 	chain.DefaultResolver
-	nsasCache        *NsasCache
-	ednsSubnetEnable map[string]bool
-	resolverEnable   map[string]bool
-	rootForView      map[string][]*NameServer
-	ctxPool          *RecursorCtxPool
-	stopCh           chan struct{}
+	nsasCaches     map[string]*NsasCache
+	nsasForView    map[string]*NsasCache
+	subnetPrefix   ecs.SourcePrefixes
+	resolverEnable map[string]bool
+	qnameMinimise  map[string]bool
+	addressFamily  map[string]FamilyPolicy
+	rootForView    map[string][]*NameServer
+	rootLock       sync.RWMutex
+	validators     map[string]*Validator
+	ctxPool        *RecursorCtxPool
+	limits         *recursorLimits
+	stopCh         chan struct{}
 }
 
 func NewRecursor(conf *config.VanguardConf) *Recursor {
 	r := &Recursor{
-		ctxPool: newRecursorCtxPool(maxInflightQuery),
+		ctxPool: newRecursorCtxPool(defaultMaxInflightQuery),
 		stopCh:  make(chan struct{}),
 	}
 	r.ReloadConfig(conf)
+	httpcmd.RegisterHandler(r, []httpcmd.Command{&ListNsasZones{}, &ListNsasNameServers{}, &FlushNsasZone{}})
 	return r
 }
 
 func (r *Recursor) ReloadConfig(conf *config.VanguardConf) {
-	r.stopMemoryEnforce()
-	ednsSubnetEnable := make(map[string]bool)
+	r.stopBackgroundTask()
 	resolverEnable := make(map[string]bool)
+	qnameMinimise := make(map[string]bool)
+	addressFamily := make(map[string]FamilyPolicy)
 	rootServers := make(map[string][]*NameServer)
+	validators := make(map[string]*Validator)
 	for _, c := range conf.Recursor {
 		resolverEnable[c.View] = c.Enable
-		ednsSubnetEnable[c.View] = c.EdnsSubnetEnable
+		qnameMinimise[c.View] = c.QnameMinimisation
+		policy, err := familyPolicyFromString(c.AddressFamily)
+		if err != nil {
+			panic("invalid address family of view " + c.View + ":" + err.Error())
+		}
+		addressFamily[c.View] = policy
+
+		if c.DnssecEnable {
+			anchors := dnssec.DefaultTrustAnchors()
+			if c.TrustAnchorFile != "" {
+				var err error
+				if anchors, err = dnssec.LoadTrustAnchors(c.TrustAnchorFile); err != nil {
+					panic("load trust anchor file " + c.TrustAnchorFile + " failed " + err.Error())
+				}
+			}
+			validators[c.View] = newValidator(anchors, r.subQuery)
+		}
 
 		if c.RootHintFile != "" {
 			f, err := os.OpenFile(c.RootHintFile, os.O_RDONLY, 0755)
@@ -97,11 +125,55 @@ func (r *Recursor) ReloadConfig(conf *config.VanguardConf) {
 			rootServers[view] = defaultRootServers
 		}
 	}
-	r.ednsSubnetEnable = ednsSubnetEnable
+	limits := newRecursorLimits(&conf.Resolver.Recursor)
+	nsasCaches := make(map[string]*NsasCache)
+	nsasForView := make(map[string]*NsasCache)
+	for view, servers := range rootServers {
+		//views with same root hints share delegations and rtt learned
+		key := rootHintKey(servers)
+		nsasCache, ok := nsasCaches[key]
+		if ok == false {
+			if nsasCache, ok = r.nsasCaches[key]; ok {
+				nsasCache.SetMaxCacheSize(limits.maxZoneCount)
+			} else {
+				nsasCache = NewNsasCache(limits.maxZoneCount)
+			}
+			nsasCaches[key] = nsasCache
+		}
+		nsasForView[view] = nsasCache
+
+		if servers = filterNameServers(servers, addressFamily[view]); len(servers) == 0 {
+			panic("view " + view + " has no root server of allowed address family")
+		}
+		rootServers[view] = servers
+	}
+	var primers []rootPrimer
+	for view, servers := range rootServers {
+		if resolverEnable[view] {
+			primers = append(primers, rootPrimer{
+				view:        view,
+				hints:       servers,
+				querySource: querysource.GetQuerySource(view),
+				family:      addressFamily[view],
+				nsasCache:   nsasForView[view],
+			})
+		}
+	}
+
+	r.subnetPrefix = ecs.NewSourcePrefixes(conf)
+	r.rootLock.Lock()
 	r.rootForView = rootServers
+	r.rootLock.Unlock()
 	r.resolverEnable = resolverEnable
-	r.nsasCache = NewNsasCache(0)
-	go r.enforceMemoryUsage(r.stopCh)
+	r.qnameMinimise = qnameMinimise
+	r.addressFamily = addressFamily
+	r.validators = validators
+	r.limits = limits
+	r.ctxPool.resize(limits.maxInflightQuery)
+	r.nsasCaches = nsasCaches
+	r.nsasForView = nsasForView
+	go r.enforceMemoryUsage(nsasCaches, r.stopCh)
+	go r.primeRootServers(primers, limits, r.stopCh)
 }
 
 func (r *Recursor) Resolve(client *core.Client) {
@@ -125,12 +197,22 @@ func (r *Recursor) resolver(client *core.Client) {
 	}
 	defer r.ctxPool.putCtx(ctx)
 
-	clientAddress := ""
-	if r.ednsSubnetEnable[client.View] {
-		clientAddress = client.Addr.String()
+	var clientSubnet *ecs.ClientSubnet
+	if prefix := r.subnetPrefix.Get(client.View); prefix.Enable {
+		clientSubnet = prefix.ClientSubnet(client)
 	}
 
-	ctx.init(singleQueryTimeout, querysource.GetQuerySource(client.View), clientAddress, client.Request.Question, r.getRootServers(client.View))
+	ctx.init(r.limits, querysource.GetQuerySource(client.View), clientSubnet, client.Request.Question, r.getRootServers(client.View))
+	clientDnssecAware := client.Request.Edns != nil && client.Request.Edns.DnssecAware
+	validator := r.validators[client.View]
+	checkDisabled := client.Request.Header.GetFlag(g53.FLAG_CD)
+	if validator != nil && checkDisabled == false {
+		ctx.validator = validator
+	}
+	ctx.dnssec = validator != nil || clientDnssecAware
+	ctx.minimise = r.qnameMinimise[client.View]
+	ctx.family = r.addressFamily[client.View]
+	ctx.nsasCache = r.nsasForView[client.View]
 
 	var response *g53.Message
 	var err error
@@ -144,15 +226,27 @@ func (r *Recursor) resolver(client *core.Client) {
 	if err == nil {
 		finalResponse := *response
 		finalResponse.Header.Id = client.Request.Header.Id
+		ecs.SetResponseSubnet(&finalResponse, ecs.FromEdns(client.Request.Edns), ctx.clientSubnet)
+		//unvalidated answer shouldn't be served to client which wants validation
+		if validator != nil && checkDisabled {
+			client.CacheAnswer = false
+		}
 		client.Response = &finalResponse
 		logger.GetLogger().Debug("query %s succeed and take %.1f milliseconds", client.Request.Question.String(), time.Since(ctx.startTime).Seconds()*1000)
+	} else if err == errBogusResponse {
+		client.Response = client.Request.MakeResponse()
+		client.Response.Header.Rcode = g53.R_SERVFAIL
+		client.CacheAnswer = false
+		logger.GetLogger().Error("query %s failed %s", client.Request.Question.String(), err.Error())
 	} else {
 		logger.GetLogger().Error("query %s failed %s", client.Request.Question.String(), err.Error())
 	}
 }
 
 func (r *Recursor) getRootServers(view string) []*NameServer {
+	r.rootLock.RLock()
 	nameServers, ok := r.rootForView[view]
+	r.rootLock.RUnlock()
 	if ok == false {
 		panic("unkown view " + view)
 	}
@@ -161,25 +255,64 @@ func (r *Recursor) getRootServers(view string) []*NameServer {
 
 func (r *Recursor) handleQuery(ctx *RecursorCtx) (*g53.Message, error) {
 	ctx.depth += 1
-	if ctx.depth >= maxQueryDep || (ctx.depth > 1 && time.Since(ctx.startTime) > queryTimeout) {
+	if ctx.depth >= ctx.limits.maxQueryDepth || (ctx.depth > 1 && time.Since(ctx.startTime) > ctx.limits.queryTimeout) {
 		return nil, errTooDepQuery
 	}
 
-	nameServers := r.nsasCache.SelectNameServers(ctx.question.Name)
+	//ds is served by the parent zone
+	zone := ctx.question.Name
+	if ctx.question.Type == g53.RR_DS && zone.IsRoot() == false {
+		zone, _ = zone.Parent(1)
+	}
+	nameServers := ctx.nsasCache.SelectNameServers(zone, ctx.family)
 	if nameServers == nil {
 		nameServers = ctx.nameServers
 	}
 
-	request := g53.MakeQuery(ctx.question.Name, ctx.question.Type, 4096, false)
-	request.Edns.AddSubnetV4(ctx.clientAddress)
+	qname, qtype := ctx.question.Name, ctx.question.Type
+	minimised := false
+	if ctx.minimise {
+		qname, minimised = ctx.minimisedName(nameServers[0].zone)
+		if minimised {
+			qtype = g53.RR_NS
+		}
+	}
+
+	request := g53.MakeQuery(qname, qtype, 4096, ctx.dnssec)
+	if ctx.clientSubnet != nil {
+		ecs.SetEdns(request.Edns, ctx.clientSubnet)
+	}
 	request.Header.SetFlag(g53.FLAG_RD, false)
 	request.RecalculateSectionRRCount()
-	response, err := r.doQuery(ctx.sender, nameServers, request)
-	if err == nil {
-		return r.handleResponse(ctx, nameServers[0].zone, response)
+	response, err := r.doQuery(ctx, nameServers, request)
+	if err != nil {
+		//broken server may not understand the minimised query
+		ctx.minimise = false
+		return r.handleQuery(ctx)
+	} else if minimised {
+		return r.handleMinimisedResponse(ctx, nameServers[0].zone, response)
 	} else {
+		return r.handleResponse(ctx, nameServers[0].zone, response)
+	}
+}
+
+func (r *Recursor) handleMinimisedResponse(ctx *RecursorCtx, zone *g53.Name, response *g53.Message) (*g53.Message, error) {
+	switch util.ClassifyResponse(response) {
+	case util.REFERRAL:
+		return r.handleReferal(ctx, zone, response)
+	case util.ANSWER:
+		//the server is authoritative for the child zone too
+		if response.Sections[g53.AnswerSection][0].Type == g53.RR_NS {
+			return r.handleReferal(ctx, zone, response)
+		}
+	case util.NXRRSET:
+		//no zone cut at the name, continue with one more label
 		return r.handleQuery(ctx)
 	}
+
+	//nxdomain for empty non-terminal or other weird answer, rfc9156 2.3
+	ctx.minimise = false
+	return r.handleQuery(ctx)
 }
 
 type Responder struct {
@@ -187,20 +320,21 @@ type Responder struct {
 	response *g53.Message
 }
 
-func (r *Recursor) doQuery(sender *util.SafeUDPSender, servers []*NameServer, request *g53.Message) (response *g53.Message, err error) {
+func (r *Recursor) doQuery(ctx *RecursorCtx, servers []*NameServer, request *g53.Message) (response *g53.Message, err error) {
+	limits := ctx.limits
 	serverCount := len(servers)
 	if serverCount == 1 {
-		return r.doSingleQuery(sender, servers[0], request)
+		return r.doSingleQuery(ctx, servers[0], request)
 	} else {
-		if serverCount > batchQueryCount {
+		if serverCount > limits.batchQueryCount {
 			sort.Sort(ServerByRtt(servers))
-			servers = servers[:batchQueryCount]
-			serverCount = batchQueryCount
+			servers = servers[:limits.batchQueryCount]
+			serverCount = limits.batchQueryCount
 		}
 		resultChan := make(chan Responder, serverCount)
 		for _, server := range servers {
 			go func(s *NameServer) {
-				msg, err := r.doSingleQuery(sender, s, request)
+				msg, err := r.doSingleQuery(ctx, s, request)
 				if err == nil {
 					resultChan <- Responder{s, msg}
 				}
@@ -211,14 +345,15 @@ func (r *Recursor) doQuery(sender *util.SafeUDPSender, servers []*NameServer, re
 		case responder := <-resultChan:
 			response = responder.response
 			logger.GetLogger().Debug("from [%s] get response:\n%s", responder.server.String(), response.String())
-		case <-time.After(singleQueryTimeout):
+		case <-time.After(limits.singleQueryTimeout):
 			err = errQueryTimeout
 		}
 	}
 	return
 }
 
-func (r *Recursor) doSingleQuery(sender *util.SafeUDPSender, server *NameServer, request *g53.Message) (*g53.Message, error) {
+func (r *Recursor) doSingleQuery(ctx *RecursorCtx, server *NameServer, request *g53.Message) (*g53.Message, error) {
+	sender := ctx.sender
 	logger.GetLogger().Debug("send query %s to name server %s", request.Question.String(), server.String())
 
 	response, rtt, err := sender.Query(server.addr, request)
@@ -233,24 +368,35 @@ func (r *Recursor) doSingleQuery(sender *util.SafeUDPSender, server *NameServer,
 			requstWithoutEdns.RecalculateSectionRRCount()
 			response, rtt, err = sender.Query(server.addr, &requstWithoutEdns)
 		} else if isValidResponse(response) == false {
-			rtt = queryTimeout
+			rtt = ctx.limits.queryTimeout
 			err = errDumbNameServer
 		}
 	}
 
-	r.nsasCache.UpdateRtt(server, rtt)
+	ctx.nsasCache.UpdateRtt(server, rtt)
 	return response, err
 }
 
+func rootHintKey(servers []*NameServer) string {
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		addrs = append(addrs, server.name.String(true)+"/"+server.addr)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
 func getDefaultRootServers() []*NameServer {
-	roots := make([]*NameServer, 0, len(rootServers))
-	for name, addr := range rootServers {
+	roots := make([]*NameServer, 0, len(rootServers)*2)
+	for name, addrs := range rootServers {
 		serverName, _ := g53.NameFromString(name)
-		roots = append(roots, &NameServer{
-			zone: g53.Root,
-			name: serverName,
-			addr: addr,
-		})
+		for _, addr := range addrs {
+			roots = append(roots, &NameServer{
+				zone: g53.Root,
+				name: serverName,
+				addr: net.JoinHostPort(addr, "53"),
+			})
+		}
 	}
 	return roots
 }
@@ -267,13 +413,26 @@ func (r *Recursor) handleResponse(ctx *RecursorCtx, zone *g53.Name, response *g5
 }
 
 func (r *Recursor) handleFinalAnswer(ctx *RecursorCtx, zone *g53.Name, response *g53.Message) (*g53.Message, error) {
-	r.nsasCache.AddZoneNameServer(zone, response)
+	ctx.nsasCache.AddZoneNameServer(zone, response, ctx.family)
 	response.Question = ctx.question
+	if ctx.validator != nil {
+		switch ctx.validator.validateResponse(ctx, zone, response) {
+		case secure:
+			response.Header.SetFlag(g53.FLAG_AD, true)
+		case bogus:
+			return nil, errBogusResponse
+		default:
+			response.Header.SetFlag(g53.FLAG_AD, false)
+		}
+	}
 	return response, nil
 }
 
 func (r *Recursor) handleReferal(ctx *RecursorCtx, zone *g53.Name, response *g53.Message) (*g53.Message, error) {
-	missingServers, knownServers := r.nsasCache.AddZoneNameServer(zone, response)
+	if ctx.validator != nil {
+		ctx.validator.checkReferral(ctx, zone, response)
+	}
+	missingServers, knownServers := ctx.nsasCache.AddZoneNameServer(zone, response, ctx.family)
 	if len(missingServers) > 0 {
 		r.getMissingNameServer(ctx, missingServers, len(knownServers) == 0)
 	}
@@ -282,60 +441,87 @@ func (r *Recursor) handleReferal(ctx *RecursorCtx, zone *g53.Name, response *g53
 
 func (r *Recursor) getMissingNameServer(ctx *RecursorCtx, serverNames []*g53.Name, wait bool) {
 	queryDepth := ctx.depth
-	if queryDepth > maxQueryDep {
+	if queryDepth > ctx.limits.maxQueryDepth {
 		return
 	}
 
 	doneChan := make(chan struct{})
 	outQuery := 0
 	for i := 0; i < len(serverNames); i++ {
-		newCtx := r.ctxPool.getCtx()
-		if newCtx == nil {
-			logger.GetLogger().Error("out recusive query exceed limit")
-			continue
-		}
-		newCtx.init(singleQueryTimeout, ctx.sender.GetQuerySource(), ctx.clientAddress,
-			&g53.Question{
-				Name:  serverNames[i],
-				Type:  g53.RR_A,
-				Class: g53.CLASS_IN,
-			}, cloneNameServers(ctx.nameServers))
-		newCtx.depth = queryDepth
-		outQuery += 1
-		go func(ctx_ *RecursorCtx) {
-			defer r.ctxPool.putCtx(ctx_)
-			response, err := r.handleQuery(ctx_)
-			if err != nil {
-				return
+		for _, family := range ctx.family.families() {
+			newCtx := r.ctxPool.getCtx()
+			if newCtx == nil {
+				logger.GetLogger().Error("out recusive query exceed limit")
+				continue
 			}
+			newCtx.init(ctx.limits, ctx.sender.GetQuerySource(), nil,
+				&g53.Question{
+					Name:  serverNames[i],
+					Type:  family.rrType(),
+					Class: g53.CLASS_IN,
+				}, cloneNameServers(ctx.nameServers))
+			newCtx.depth = queryDepth
+			newCtx.family = ctx.family
+			newCtx.nsasCache = ctx.nsasCache
+			outQuery += 1
+			go func(ctx_ *RecursorCtx) {
+				defer r.ctxPool.putCtx(ctx_)
+				response, err := r.handleQuery(ctx_)
+				if err != nil {
+					return
+				}
 
-			glue := getARRsetFromAnswer(response)
-			if glue == nil {
-				return
-			}
+				glue := getAddrRRsetFromAnswer(response)
+				if glue == nil {
+					return
+				}
 
-			r.nsasCache.addNameServer(glue, FromAuth)
-			select {
-			case doneChan <- struct{}{}:
-			default:
-			}
-		}(newCtx)
+				ctx_.nsasCache.addNameServer(glue, FromAuth)
+				select {
+				case doneChan <- struct{}{}:
+				default:
+				}
+			}(newCtx)
+		}
 	}
 
 	if wait && outQuery > 0 {
 		select {
 		case <-doneChan:
-		case <-time.After(singleQueryTimeout):
+		case <-time.After(ctx.limits.singleQueryTimeout):
 		}
 	}
 }
 
-func (r *Recursor) stopMemoryEnforce() {
+func (r *Recursor) subQuery(ctx *RecursorCtx, name *g53.Name, typ g53.RRType) (*g53.Message, error) {
+	//query issued by validator to fetch ds and dnskey, it isn't validated itself
+	newCtx := r.ctxPool.getCtx()
+	if newCtx == nil {
+		return nil, errQueryExceedLimit
+	}
+	defer r.ctxPool.putCtx(newCtx)
+
+	newCtx.init(ctx.limits, ctx.sender.GetQuerySource(), nil,
+		&g53.Question{
+			Name:  name,
+			Type:  typ,
+			Class: g53.CLASS_IN,
+		}, cloneNameServers(ctx.nameServers))
+	newCtx.depth = ctx.depth
+	newCtx.family = ctx.family
+	newCtx.nsasCache = ctx.nsasCache
+	newCtx.dnssec = true
+	return r.handleQuery(newCtx)
+}
+
+func (r *Recursor) stopBackgroundTask() {
+	r.rootLock.Lock()
+	defer r.rootLock.Unlock()
 	close(r.stopCh)
 	r.stopCh = make(chan struct{})
 }
 
-func (r *Recursor) enforceMemoryUsage(stopCh <-chan struct{}) {
+func (r *Recursor) enforceMemoryUsage(nsasCaches map[string]*NsasCache, stopCh <-chan struct{}) {
 	ticker := time.NewTicker(memoryCheckInterval)
 	defer ticker.Stop()
 	for {
@@ -344,6 +530,8 @@ func (r *Recursor) enforceMemoryUsage(stopCh <-chan struct{}) {
 			return
 		case <-ticker.C:
 		}
-		r.nsasCache.EnforceMemoryLimit()
+		for _, nsasCache := range nsasCaches {
+			nsasCache.EnforceMemoryLimit()
+		}
 	}
 }
@@ -9,9 +9,11 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	ut "github.com/ben-han-cn/cement/unittest"
 	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/core"
 	"github.com/ben-han-cn/vanguard/logger"
@@ -81,3 +83,320 @@ func TestRecursive(t *testing.T) {
 
 	ut.Assert(t, len(failedNames) == 0, "failed names is %v", failedNames)
 }
+
+type fakeAuth struct {
+	zone        *g53.Name
+	rrsets      []*g53.RRset
+	delegations []*g53.RRset
+	brokenENT   bool //answer nxdomain for empty non-terminal
+	addr        string
+	queries     []string
+	lock        sync.Mutex
+}
+
+func newFakeAuth(t *testing.T, zone string, rrs []string, brokenENT bool) *fakeAuth {
+	auth := &fakeAuth{
+		zone:      g53.NameFromStringUnsafe(zone),
+		brokenENT: brokenENT,
+	}
+	rrs = append(rrs, zone+" 3600 IN SOA ns.test. root.test. 1 3600 600 86400 300")
+	for _, rr := range rrs {
+		rrset, err := g53.RRsetFromString(rr)
+		ut.Assert(t, err == nil, "invalid rr %s", rr)
+		if rrset.Type == g53.RR_NS && rrset.Name.Equals(auth.zone) == false {
+			auth.delegations = append(auth.delegations, rrset)
+		} else if last := len(auth.rrsets) - 1; last >= 0 && auth.rrsets[last].Name.Equals(rrset.Name) && auth.rrsets[last].Type == rrset.Type {
+			auth.rrsets[last].Rdatas = append(auth.rrsets[last].Rdatas, rrset.Rdatas...)
+		} else {
+			auth.rrsets = append(auth.rrsets, rrset)
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	ut.Assert(t, err == nil, "listen udp failed %v", err)
+	auth.addr = conn.LocalAddr().String()
+	go func() {
+		buf := make([]byte, 512)
+		render := g53.NewMsgRender()
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := g53.MessageFromWire(gutil.NewInputBuffer(buf[:n]))
+			if err != nil {
+				continue
+			}
+			auth.answer(query).Rend(render)
+			conn.WriteToUDP(render.Data(), addr)
+			render.Clear()
+		}
+	}()
+	return auth
+}
+
+func (a *fakeAuth) answer(query *g53.Message) *g53.Message {
+	a.lock.Lock()
+	a.queries = append(a.queries, query.Question.Name.String(false)+" "+query.Question.Type.String())
+	a.lock.Unlock()
+
+	name := query.Question.Name
+	resp := query.MakeResponse()
+	defer resp.RecalculateSectionRRCount()
+	for _, ns := range a.delegations {
+		if name.IsSubDomain(ns.Name) {
+			resp.AddRRset(g53.AuthSection, ns)
+			return resp
+		}
+	}
+
+	resp.Header.SetFlag(g53.FLAG_AA, true)
+	exist := false
+	for _, rrset := range a.rrsets {
+		if rrset.Name.Equals(name) {
+			exist = true
+			if rrset.Type == query.Question.Type {
+				resp.AddRRset(g53.AnswerSection, rrset)
+				if rrset.Type == g53.RR_NS {
+					a.addGlue(resp, rrset)
+				}
+				return resp
+			}
+		} else if rrset.Name.IsSubDomain(name) && a.brokenENT == false {
+			exist = true
+		}
+	}
+	if exist == false {
+		resp.Header.Rcode = g53.R_NXDOMAIN
+	}
+	resp.AddRRset(g53.AuthSection, a.rrsets[len(a.rrsets)-1])
+	return resp
+}
+
+func (a *fakeAuth) addGlue(resp *g53.Message, ns *g53.RRset) {
+	for _, rdata := range ns.Rdatas {
+		for _, rrset := range a.rrsets {
+			if (rrset.Type == g53.RR_A || rrset.Type == g53.RR_AAAA) && rrset.Name.Equals(rdata.(*g53.NS).Name) {
+				resp.AddRRset(g53.AdditionalSection, rrset)
+			}
+		}
+	}
+}
+
+func (a *fakeAuth) seen() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]string(nil), a.queries...)
+}
+
+func TestQnameMinimisation(t *testing.T) {
+	logger.UseDefaultLogger("error")
+	for _, brokenENT := range []bool{false, true} {
+		root := newFakeAuth(t, ".", []string{"example. 3600 IN NS ns.example.test."}, false)
+		example := newFakeAuth(t, "example.", []string{
+			"x.y.z.example. 3600 IN A 1.1.1.1",
+			"c.example. 3600 IN NS ns.c.test.",
+		}, brokenENT)
+		c := newFakeAuth(t, "c.example.", []string{"www.c.example. 3600 IN A 2.2.2.2"}, false)
+
+		conf := &config.VanguardConf{}
+		conf.Recursor = []config.RecursorInView{{
+			View:              "default",
+			Enable:            true,
+			QnameMinimisation: true,
+		}}
+		view.NewSelectorMgr(conf)
+		querysource.NewQuerySourceManager(conf)
+		r := NewRecursor(conf)
+		r.stopBackgroundTask()
+		r.rootForView["default"] = []*NameServer{&NameServer{
+			zone: g53.Root,
+			name: g53.NameFromStringUnsafe("ns.root."),
+			addr: root.addr,
+		}}
+		//servers are out of zone and without glue, so their port is kept
+		r.nsasForView["default"].nameServers.addNameServer(g53.NameFromStringUnsafe("ns.example.test."), time.Hour, []string{example.addr}, FromAuth)
+		r.nsasForView["default"].nameServers.addNameServer(g53.NameFromStringUnsafe("ns.c.test."), time.Hour, []string{c.addr}, FromAuth)
+
+		resolve := func(name string) *g53.Message {
+			var client core.Client
+			client.Request = g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 1232, false)
+			client.Addr, _ = net.ResolveUDPAddr("udp", "127.0.0.1:0")
+			client.View = "default"
+			r.Resolve(&client)
+			ut.Assert(t, client.Response != nil, "query %s should get response", name)
+			return client.Response
+		}
+
+		resp := resolve("www.c.example.")
+		ut.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "2.2.2.2")
+		ut.Equal(t, root.seen(), []string{"example. NS"})
+		ut.Equal(t, example.seen(), []string{"c.example. NS"})
+		ut.Equal(t, c.seen(), []string{"www.c.example. A"})
+
+		resp = resolve("x.y.z.example.")
+		ut.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+		if brokenENT {
+			ut.Equal(t, example.seen()[1:], []string{"z.example. NS", "x.y.z.example. A"})
+		} else {
+			ut.Equal(t, example.seen()[1:], []string{"z.example. NS", "y.z.example. NS", "x.y.z.example. A"})
+		}
+	}
+
+	root := newFakeAuth(t, ".", []string{"example. 3600 IN NS ns.example.test."}, false)
+	example := newFakeAuth(t, "example.", nil, false)
+	conf := &config.VanguardConf{}
+	conf.Recursor = []config.RecursorInView{{View: "default", Enable: true}}
+	r := NewRecursor(conf)
+	r.stopBackgroundTask()
+	r.rootForView["default"] = []*NameServer{&NameServer{zone: g53.Root, name: g53.NameFromStringUnsafe("ns.root."), addr: root.addr}}
+	r.nsasForView["default"].nameServers.addNameServer(g53.NameFromStringUnsafe("ns.example.test."), time.Hour, []string{example.addr}, FromAuth)
+	var client core.Client
+	client.Request = g53.MakeQuery(g53.NameFromStringUnsafe("www.c.example."), g53.RR_A, 1232, false)
+	client.Addr, _ = net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	client.View = "default"
+	r.Resolve(&client)
+	ut.Equal(t, root.seen(), []string{"www.c.example. A"})
+	ut.Equal(t, client.Response.Header.Rcode, g53.R_NXDOMAIN)
+}
+
+func TestRootPriming(t *testing.T) {
+	logger.UseDefaultLogger("error")
+	root := newFakeAuth(t, ".", []string{
+		". 86400 IN NS a.root.test.",
+		". 86400 IN NS b.root.test.",
+		"a.root.test. 86400 IN A 10.0.0.1",
+		"a.root.test. 86400 IN AAAA 2001:db8::1",
+		"b.root.test. 86400 IN A 10.0.0.2",
+	}, false)
+	conf := &config.VanguardConf{}
+	conf.Recursor = []config.RecursorInView{{View: "default", Enable: true, AddressFamily: "ipv4_only"}}
+	view.NewSelectorMgr(conf)
+	querysource.NewQuerySourceManager(conf)
+	r := NewRecursor(conf)
+	r.stopBackgroundTask()
+
+	hints := []*NameServer{&NameServer{zone: g53.Root, name: g53.NameFromStringUnsafe("ns.root."), addr: root.addr}}
+	primer := rootPrimer{view: "default", hints: hints, family: IPv4Only, nsasCache: r.nsasForView["default"]}
+	roots, ttl, err := r.primeRoot(primer, r.limits)
+	ut.Assert(t, err == nil, "prime root failed %v", err)
+	ut.Equal(t, ttl, 24*time.Hour)
+	ut.Equal(t, len(roots), 2)
+	for _, server := range roots {
+		ut.Equal(t, addrFamily(server.addr), IPv4)
+	}
+	ut.Equal(t, root.seen(), []string{". NS"})
+
+	stopCh := make(chan struct{})
+	go r.primeRootServers([]rootPrimer{primer}, r.limits, stopCh)
+	for i := 0; i < 100 && len(r.getRootServers("default")) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ut.Equal(t, r.getRootServers("default")[0].addr, "10.0.0.1:53")
+	close(stopCh)
+	ut.Equal(t, r.setRootServers("default", hints, stopCh), false)
+
+	//fall back to hints on failure
+	dead := []*NameServer{&NameServer{zone: g53.Root, name: g53.NameFromStringUnsafe("ns.root."), addr: "127.0.0.1:1"}}
+	primer.hints = dead
+	_, _, err = r.primeRoot(primer, r.limits)
+	ut.Assert(t, err != nil, "")
+	stopCh = make(chan struct{})
+	defer close(stopCh)
+	go r.primeRootServers([]rootPrimer{primer}, r.limits, stopCh)
+	for i := 0; i < 100 && len(r.getRootServers("default")) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ut.Equal(t, r.getRootServers("default")[0].addr, "127.0.0.1:1")
+}
+
+func TestReloadRecursorLimits(t *testing.T) {
+	logger.UseDefaultLogger("error")
+	conf := &config.VanguardConf{}
+	conf.Recursor = []config.RecursorInView{{View: "default", Enable: true}}
+	view.NewSelectorMgr(conf)
+	querysource.NewQuerySourceManager(conf)
+	r := NewRecursor(conf)
+	r.stopBackgroundTask()
+	ut.Equal(t, r.limits.maxInflightQuery, defaultMaxInflightQuery)
+	ut.Equal(t, r.limits.queryTimeout, defaultQueryTimeout)
+
+	nsasCache := r.nsasForView["default"]
+	zone := g53.NameFromStringUnsafe("knet.cn.")
+	nsasCache.AddZoneNameServer(zone, buildFackNSResponse(zone), FamilyAny)
+	conf.Resolver.Recursor = config.RecursorConf{
+		MaxQueryDepth:      10,
+		MaxInflightQuery:   1000,
+		SingleQueryTimeout: 1,
+		BatchQueryCount:    5,
+		MaxZoneCount:       10,
+	}
+	r.ReloadConfig(conf)
+	r.stopBackgroundTask()
+	ut.Assert(t, r.nsasForView["default"] == nsasCache, "nsas cache should be kept")
+	ut.Equal(t, len(nsasCache.SelectNameServers(zone, FamilyAny)), 2)
+	ut.Equal(t, nsasCache.maxCacheSize, 10)
+	ut.Equal(t, r.ctxPool.max, 1000)
+	ut.Equal(t, r.limits.maxQueryDepth, uint32(10))
+	ut.Equal(t, r.limits.singleQueryTimeout, time.Second)
+	ut.Equal(t, r.limits.queryTimeout, defaultQueryTimeout)
+	ut.Equal(t, r.limits.batchQueryCount, 5)
+}
+
+func TestNsasCachePerRootHint(t *testing.T) {
+	logger.UseDefaultLogger("error")
+	hintFile, err := ioutil.TempFile("", "root.hint")
+	ut.Assert(t, err == nil, "")
+	defer os.Remove(hintFile.Name())
+	hintFile.WriteString(". 3600000 IN NS a.root.test.\na.root.test. 3600000 IN A 10.0.0.1\n")
+	hintFile.Close()
+
+	conf := &config.VanguardConf{}
+	conf.Recursor = []config.RecursorInView{
+		{View: "default", Enable: true},
+		{View: "v1", Enable: true, RootHintFile: hintFile.Name()},
+		{View: "v2", Enable: true, RootHintFile: hintFile.Name()},
+	}
+	view.NewSelectorMgr(conf)
+	querysource.NewQuerySourceManager(conf)
+	r := NewRecursor(conf)
+	r.stopBackgroundTask()
+	ut.Assert(t, r.nsasForView["v1"] == r.nsasForView["v2"], "")
+	ut.Assert(t, r.nsasForView["default"] != r.nsasForView["v2"], "")
+	ut.Equal(t, len(r.nsasCaches), 2)
+
+	zone := g53.NameFromStringUnsafe("knet.cn.")
+	r.nsasForView["v2"].AddZoneNameServer(zone, buildFackNSResponse(zone), FamilyAny)
+	v2Cache := r.nsasForView["v2"]
+	r.ReloadConfig(conf)
+	r.stopBackgroundTask()
+	ut.Assert(t, r.nsasForView["v2"] == v2Cache, "")
+
+	zones, cmdErr := r.HandleCmd(&ListNsasZones{View: "v2"})
+	ut.Assert(t, cmdErr == nil, "")
+	ut.Equal(t, len(zones.([]ZoneInNsas)), 1)
+	ut.Equal(t, zones.([]ZoneInNsas)[0].Zone, "knet.cn.")
+	ut.Equal(t, zones.([]ZoneInNsas)[0].NameServers, []string{"ns1.knet.cn.", "ns2.knet.cn."})
+	zones, _ = r.HandleCmd(&ListNsasZones{View: "default"})
+	ut.Equal(t, len(zones.([]ZoneInNsas)), 0)
+
+	ut.Assert(t, r.nsasForView["v2"].nameServers.updateRtt(&NameServer{name: g53.NameFromStringUnsafe("ns1.knet.cn."), addr: "1.1.1.1:53"}, 10*time.Millisecond) == nil, "")
+	servers, cmdErr := r.HandleCmd(&ListNsasNameServers{View: "v2", Zone: "knet.cn"})
+	ut.Assert(t, cmdErr == nil, "")
+	ut.Equal(t, len(servers.([]NameServerInNsas)), 4)
+	for _, server := range servers.([]NameServerInNsas) {
+		if server.Addr == "1.1.1.1:53" {
+			ut.Assert(t, server.RttMs >= 3, "rtt should be updated but get %v", server.RttMs)
+		}
+	}
+	servers, _ = r.HandleCmd(&ListNsasNameServers{View: "v2"})
+	ut.Equal(t, len(servers.([]NameServerInNsas)), 4)
+
+	_, cmdErr = r.HandleCmd(&FlushNsasZone{View: "v2", Zone: "knet.cn"})
+	ut.Assert(t, cmdErr == nil, "")
+	ut.Equal(t, len(r.nsasForView["v2"].SelectNameServers(zone, FamilyAny)), 0)
+	_, cmdErr = r.HandleCmd(&FlushNsasZone{View: "v2", Zone: "knet.cn"})
+	ut.Equal(t, cmdErr.Code, ErrNonExistNsasZone.Code)
+	_, cmdErr = r.HandleCmd(&ListNsasZones{View: "unknown"})
+	ut.Equal(t, cmdErr.Code, ErrUnknownRecursorView.Code)
+}
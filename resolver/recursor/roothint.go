@@ -3,6 +3,7 @@ package recursor
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -11,7 +12,7 @@ import (
 
 var (
 	errInvalidRootHintZoneName = errors.New("root hint zone name should be root")
-	errUnsupportRRType         = errors.New("only ns and a is supported in root hint")
+	errUnsupportRRType         = errors.New("only ns, a and aaaa is supported in root hint")
 )
 
 func loadRootServer(content string) ([]*NameServer, error) {
@@ -28,11 +29,11 @@ func loadRootServer(content string) ([]*NameServer, error) {
 			if rrset.Name.Equals(g53.Root) == false {
 				return nil, errInvalidRootHintZoneName
 			}
-		} else if rrset.Type == g53.RR_A {
+		} else if rrset.Type == g53.RR_A || rrset.Type == g53.RR_AAAA {
 			nameServers = append(nameServers, &NameServer{
 				zone: g53.Root,
 				name: rrset.Name,
-				addr: rrset.Rdatas[0].String() + ":53",
+				addr: net.JoinHostPort(rrset.Rdatas[0].String(), "53"),
 				rtt:  time.Duration(rrset.Ttl) * time.Second,
 			})
 		} else {
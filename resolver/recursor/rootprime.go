@@ -0,0 +1,116 @@
+package recursor
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/util"
+)
+
+var errInvalidPrimingResponse = errors.New("priming response should answer root ns")
+var errNoRootServerAddr = errors.New("priming response has no usable root server address")
+var errInvalidQuerySource = errors.New("query source isn't valid")
+
+const primeRetryInterval = time.Minute
+const minPrimeInterval = time.Minute
+
+type rootPrimer struct {
+	view        string
+	hints       []*NameServer
+	querySource string
+	family      FamilyPolicy
+	nsasCache   *NsasCache
+}
+
+func (r *Recursor) primeRootServers(primers []rootPrimer, limits *recursorLimits, stopCh <-chan struct{}) {
+	//rfc8109, replace the hints with the servers root zone announces, and
+	//prime again when the root ns rrset expires
+	for {
+		var interval time.Duration
+		for _, p := range primers {
+			roots, ttl, err := r.primeRoot(p, limits)
+			if err != nil {
+				logger.GetLogger().Error("prime root servers for view %s failed %s, use root hints", p.view, err.Error())
+				roots, ttl = p.hints, primeRetryInterval
+			} else {
+				logger.GetLogger().Info("prime root servers for view %s get %d servers", p.view, len(roots))
+			}
+
+			if r.setRootServers(p.view, roots, stopCh) == false {
+				return
+			}
+			if interval == 0 || ttl < interval {
+				interval = ttl
+			}
+		}
+
+		if len(primers) == 0 {
+			return
+		} else if interval < minPrimeInterval {
+			interval = minPrimeInterval
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *Recursor) primeRoot(p rootPrimer, limits *recursorLimits) ([]*NameServer, time.Duration, error) {
+	request := g53.MakeQuery(g53.Root, g53.RR_NS, 4096, false)
+	request.Header.SetFlag(g53.FLAG_RD, false)
+	request.RecalculateSectionRRCount()
+
+	ctx := &RecursorCtx{}
+	ctx.init(limits, p.querySource, nil, request.Question, cloneNameServers(p.hints))
+	if ctx.sender == nil {
+		return nil, 0, errInvalidQuerySource
+	}
+	ctx.family = p.family
+	ctx.nsasCache = p.nsasCache
+	response, err := r.doQuery(ctx, ctx.nameServers, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	if util.ClassifyResponse(response) != util.ANSWER {
+		return nil, 0, errInvalidPrimingResponse
+	}
+
+	nsRRset, glues, err := getAuthAndGlues(g53.Root, response)
+	if err != nil {
+		return nil, 0, err
+	} else if nsRRset.Name.IsRoot() == false {
+		return nil, 0, errInvalidPrimingResponse
+	}
+
+	var roots []*NameServer
+	for _, glue := range glues {
+		for _, rdata := range glue.Rdatas {
+			roots = append(roots, &NameServer{
+				zone: g53.Root,
+				name: glue.Name,
+				addr: net.JoinHostPort(rdata.String(), "53"),
+			})
+		}
+	}
+	if roots = filterNameServers(roots, ctx.family); len(roots) == 0 {
+		return nil, 0, errNoRootServerAddr
+	}
+	return roots, time.Duration(nsRRset.Ttl) * time.Second, nil
+}
+
+func (r *Recursor) setRootServers(view string, servers []*NameServer, stopCh <-chan struct{}) bool {
+	r.rootLock.Lock()
+	defer r.rootLock.Unlock()
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+	r.rootForView[view] = servers
+	return true
+}
@@ -0,0 +1,505 @@
+package recursor
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/logger"
+)
+
+var (
+	errBogusResponse      = errors.New("response is bogus")
+	errNoTrustedKey       = errors.New("no trusted dnskey")
+	errNoValidSignature   = errors.New("no valid signature")
+	errMissingDenialProof = errors.New("missing nsec proof")
+	errInvalidSigner      = errors.New("signer isn't the parent of name")
+)
+
+type securityStatus int
+
+const (
+	secure securityStatus = iota
+	insecure
+	bogus
+	notZoneCut
+)
+
+const (
+	maxValidateDepth      = 16
+	bogusCacheTime        = time.Minute
+	maxSecurityCacheTime  = 24 * time.Hour
+	maxSecurityCacheCount = 4096
+)
+
+type zoneSecurity struct {
+	status     securityStatus
+	ds         *g53.RRset
+	keys       *g53.RRset
+	expireTime time.Time
+}
+
+type queryFunc func(ctx *RecursorCtx, name *g53.Name, typ g53.RRType) (*g53.Message, error)
+
+type Validator struct {
+	anchors dnssec.TrustAnchors
+	query   queryFunc
+	zones   map[string]*zoneSecurity
+	lock    sync.Mutex
+	now     func() time.Time
+}
+
+func newValidator(anchors dnssec.TrustAnchors, query queryFunc) *Validator {
+	return &Validator{
+		anchors: anchors,
+		query:   query,
+		zones:   make(map[string]*zoneSecurity),
+		now:     time.Now,
+	}
+}
+
+func securityKey(zone *g53.Name) string {
+	return strings.ToLower(zone.String(false))
+}
+
+func (v *Validator) getZone(zone *g53.Name) *zoneSecurity {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	e, ok := v.zones[securityKey(zone)]
+	if ok && e.expireTime.After(v.now()) {
+		return e
+	}
+	return nil
+}
+
+func (v *Validator) setZone(zone *g53.Name, e *zoneSecurity) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.zones) >= maxSecurityCacheCount {
+		now := v.now()
+		for key, e := range v.zones {
+			if e.expireTime.Before(now) {
+				delete(v.zones, key)
+			}
+		}
+		if len(v.zones) >= maxSecurityCacheCount {
+			v.zones = make(map[string]*zoneSecurity)
+		}
+	}
+	v.zones[securityKey(zone)] = e
+}
+
+func (v *Validator) cacheTime(status securityStatus, rrsets ...*g53.RRset) time.Time {
+	if status == bogus {
+		return v.now().Add(bogusCacheTime)
+	}
+
+	ttl := maxSecurityCacheTime
+	for _, rrset := range rrsets {
+		if rrset != nil && time.Duration(rrset.Ttl)*time.Second < ttl {
+			ttl = time.Duration(rrset.Ttl) * time.Second
+		}
+	}
+	return v.now().Add(ttl)
+}
+
+func (v *Validator) zoneStatus(ctx *RecursorCtx, zone *g53.Name, depth int) (securityStatus, *g53.RRset) {
+	//security status and validated dnskey of the zone
+	anchor := v.anchors.ClosestAnchor(zone)
+	if anchor == nil {
+		return insecure, nil
+	} else if depth > maxValidateDepth {
+		return bogus, nil
+	}
+
+	e := v.getZone(zone)
+	if e != nil && (e.status != secure || e.keys != nil) {
+		return e.status, e.keys
+	}
+
+	var ds *g53.RRset
+	var trust func(*dnssec.DNSKEY) bool
+	if anchor.Zone.Equals(zone) {
+		trust = anchor.Trust
+	} else {
+		if e != nil {
+			ds = e.ds
+		} else {
+			var status securityStatus
+			if status, ds = v.fetchDS(ctx, zone, depth); status != secure {
+				if status == notZoneCut {
+					status = bogus
+				}
+				return status, nil
+			}
+		}
+
+		if hasSupportedDS(ds) == false {
+			v.setZone(zone, &zoneSecurity{status: insecure, expireTime: v.cacheTime(insecure, ds)})
+			return insecure, nil
+		}
+		trust = func(key *dnssec.DNSKEY) bool {
+			for _, rdata := range ds.Rdatas {
+				if dnssec.MatchDS(zone, key, rdata.(*g53.DS)) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	keys, err := v.fetchKeys(ctx, zone, trust)
+	if err != nil {
+		logger.GetLogger().Warn("validate dnskey of zone %s failed: %s", zone.String(false), err.Error())
+		v.setZone(zone, &zoneSecurity{status: bogus, expireTime: v.cacheTime(bogus)})
+		return bogus, nil
+	}
+	v.setZone(zone, &zoneSecurity{status: secure, ds: ds, keys: keys, expireTime: v.cacheTime(secure, ds, keys)})
+	return secure, keys
+}
+
+func hasSupportedDS(ds *g53.RRset) bool {
+	for _, rdata := range ds.Rdatas {
+		d := rdata.(*g53.DS)
+		if dnssec.IsSupportedAlgorithm(d.Algorithm) && dnssec.IsSupportedDigest(d.DigestType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Validator) fetchKeys(ctx *RecursorCtx, zone *g53.Name, trust func(*dnssec.DNSKEY) bool) (*g53.RRset, error) {
+	response, err := v.query(ctx, zone, g53.RR_DNSKEY)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := response.Sections[g53.AnswerSection]
+	keys := findRRset(answer, zone, g53.RR_DNSKEY)
+	if keys == nil {
+		return nil, errNoTrustedKey
+	}
+
+	var trustedKeys []g53.Rdata
+	for _, rdata := range keys.Rdatas {
+		if key, ok := rdata.(*dnssec.DNSKEY); ok && trust(key) {
+			trustedKeys = append(trustedKeys, rdata)
+		}
+	}
+	if len(trustedKeys) == 0 {
+		return nil, errNoTrustedKey
+	}
+
+	trusted := &g53.RRset{Name: zone, Type: g53.RR_DNSKEY, Class: keys.Class, Ttl: keys.Ttl, Rdatas: trustedKeys}
+	if _, err := v.verifyRRset(keys, signaturesFor(answer, keys), trusted); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (v *Validator) fetchDS(ctx *RecursorCtx, zone *g53.Name, depth int) (securityStatus, *g53.RRset) {
+	//ds of the zone validated by its parent
+	response, err := v.query(ctx, zone, g53.RR_DS)
+	if err != nil {
+		return bogus, nil
+	}
+
+	status := bogus
+	answer := response.Sections[g53.AnswerSection]
+	ds := findRRset(answer, zone, g53.RR_DS)
+	if ds != nil {
+		sigs := signaturesFor(answer, ds)
+		if len(sigs) == 0 {
+			return bogus, nil
+		}
+		signer := sigs[0].Signer
+		if zone.IsSubDomain(signer) == false || zone.Equals(signer) {
+			return bogus, nil
+		}
+
+		parentStatus, keys := v.zoneStatus(ctx, signer, depth+1)
+		if parentStatus != secure {
+			status = parentStatus
+		} else if _, err := v.verifyRRset(ds, sigs, keys); err == nil {
+			status = secure
+		}
+	} else {
+		status = v.validateNoDS(ctx, zone, response, depth)
+	}
+
+	if status == notZoneCut {
+		return status, nil
+	}
+	v.setZone(zone, &zoneSecurity{status: status, ds: ds, expireTime: v.cacheTime(status, ds)})
+	return status, ds
+}
+
+func (v *Validator) validateNoDS(ctx *RecursorCtx, zone *g53.Name, response *g53.Message, depth int) securityStatus {
+	auth := response.Sections[g53.AuthSection]
+	signer := signerOfSection(auth)
+	if signer == nil || zone.IsSubDomain(signer) == false || zone.Equals(signer) {
+		return bogus
+	}
+
+	parentStatus, keys := v.zoneStatus(ctx, signer, depth+1)
+	if parentStatus != secure {
+		return parentStatus
+	}
+
+	records, err := v.validateDenialRecords(auth, keys)
+	if err != nil {
+		return bogus
+	}
+
+	if records.ProveInsecureDelegation(zone) {
+		return insecure
+	} else if records.ProveNoData(zone, g53.RR_DS) || records.ProveNXDomain(zone) {
+		return notZoneCut
+	}
+	return bogus
+}
+
+func (v *Validator) checkReferral(ctx *RecursorCtx, zone *g53.Name, response *g53.Message) {
+	//cache ds or insecure delegation of the child zone in referral
+	auth := response.Sections[g53.AuthSection]
+	var child *g53.Name
+	for _, rrset := range auth {
+		if rrset.Type == g53.RR_NS {
+			child = rrset.Name
+			break
+		}
+	}
+	if child == nil || child.IsSubDomain(zone) == false || child.Equals(zone) || v.getZone(child) != nil {
+		return
+	}
+
+	if signer := signerOfSection(auth); signer == nil || signer.Equals(zone) == false {
+		return
+	}
+
+	status, keys := v.zoneStatus(ctx, zone, 0)
+	if status == insecure {
+		v.setZone(child, &zoneSecurity{status: insecure, expireTime: v.cacheTime(insecure)})
+		return
+	} else if status != secure {
+		return
+	}
+
+	if ds := findRRset(auth, child, g53.RR_DS); ds != nil {
+		if _, err := v.verifyRRset(ds, signaturesFor(auth, ds), keys); err == nil {
+			v.setZone(child, &zoneSecurity{status: secure, ds: ds, expireTime: v.cacheTime(secure, ds)})
+		}
+	} else if records, err := v.validateDenialRecords(auth, keys); err == nil && records.ProveInsecureDelegation(child) {
+		v.setZone(child, &zoneSecurity{status: insecure, expireTime: v.cacheTime(insecure)})
+	}
+}
+
+func (v *Validator) validateResponse(ctx *RecursorCtx, zone *g53.Name, response *g53.Message) securityStatus {
+	question := response.Question
+	answer := response.Sections[g53.AnswerSection]
+	auth := response.Sections[g53.AuthSection]
+
+	result := secure
+	merge := func(status securityStatus) {
+		if status > result {
+			result = status
+		}
+	}
+
+	var wildcardNames []*g53.Name
+	var wildcardLabels []uint8
+	name := question.Name
+	answered := false
+	for _, rrset := range answer {
+		if rrset.Type == g53.RR_RRSIG {
+			continue
+		}
+
+		sigs := signaturesFor(answer, rrset)
+		if len(sigs) == 0 {
+			merge(v.unsignedStatus(ctx, zone, rrset.Name))
+		} else {
+			status, keys := v.signerStatus(ctx, rrset.Name, sigs[0].Signer)
+			if status == secure {
+				if sig, err := v.verifyRRset(rrset, sigs, keys); err != nil {
+					logger.GetLogger().Warn("validate %s %s failed: %s", rrset.Name.String(false), rrset.Type.String(), err.Error())
+					status = bogus
+				} else if uint(sig.Labels) < rrset.Name.LabelCount()-1 {
+					wildcardNames = append(wildcardNames, rrset.Name)
+					wildcardLabels = append(wildcardLabels, sig.Labels)
+				}
+			}
+			merge(status)
+		}
+
+		if rrset.Name.Equals(name) {
+			if rrset.Type == g53.RR_CNAME && question.Type != g53.RR_CNAME {
+				name = rrset.Rdatas[0].(*g53.CName).Name
+			} else if rrset.Type == question.Type {
+				answered = true
+			}
+		}
+	}
+
+	if result == bogus || (answered && len(wildcardNames) == 0) {
+		return result
+	}
+
+	//negative answer or wildcard expansion needs nsec proof
+	signer := signerOfSection(auth)
+	if signer == nil {
+		merge(v.unsignedStatus(ctx, zone, name))
+		if answered == false || result != insecure {
+			return result
+		}
+		return bogus
+	}
+
+	status, keys := v.signerStatus(ctx, name, signer)
+	if status != secure {
+		merge(status)
+		return result
+	}
+
+	records, err := v.validateDenialRecords(auth, keys)
+	if err != nil {
+		return bogus
+	}
+
+	for i, wildcard := range wildcardNames {
+		if records.ProveWildcardExpansion(wildcard, wildcardLabels[i]) == false {
+			return bogus
+		}
+	}
+
+	if answered == false {
+		proved := false
+		if response.Header.Rcode == g53.R_NXDOMAIN {
+			proved = records.ProveNXDomain(name)
+		} else {
+			proved = records.ProveNoData(name, question.Type)
+		}
+		if proved == false {
+			logger.GetLogger().Warn("no denial proof for %s %s", name.String(false), question.Type.String())
+			return bogus
+		}
+	}
+	return result
+}
+
+func (v *Validator) signerStatus(ctx *RecursorCtx, name, signer *g53.Name) (securityStatus, *g53.RRset) {
+	if name.IsSubDomain(signer) == false {
+		return bogus, nil
+	}
+	return v.zoneStatus(ctx, signer, 0)
+}
+
+func (v *Validator) unsignedStatus(ctx *RecursorCtx, zone, name *g53.Name) securityStatus {
+	//unsigned data is acceptable only if it's under an insecure delegation
+	anchor := v.anchors.ClosestAnchor(name)
+	if anchor == nil {
+		return insecure
+	}
+
+	start := anchor.Zone
+	if name.IsSubDomain(zone) && zone.IsSubDomain(start) {
+		start = zone
+	}
+	if status, _ := v.zoneStatus(ctx, start, 0); status != secure {
+		return status
+	}
+
+	for i := name.LabelCount() - start.LabelCount(); i > 0; i-- {
+		cut, err := name.Parent(i - 1)
+		if err != nil {
+			return bogus
+		}
+
+		status := notZoneCut
+		if e := v.getZone(cut); e != nil {
+			status = e.status
+		} else {
+			status, _ = v.fetchDS(ctx, cut, 0)
+		}
+
+		switch status {
+		case insecure:
+			return insecure
+		case secure, bogus:
+			return bogus
+		}
+	}
+	return bogus
+}
+
+func (v *Validator) validateDenialRecords(section g53.Section, keys *g53.RRset) (*dnssec.DenialRecords, error) {
+	var validated g53.Section
+	for _, rrset := range section {
+		if rrset.Type != g53.RR_NSEC && rrset.Type != g53.RR_NSEC3 && rrset.Type != g53.RR_SOA {
+			continue
+		}
+		if _, err := v.verifyRRset(rrset, signaturesFor(section, rrset), keys); err != nil {
+			return nil, err
+		}
+		validated = append(validated, rrset)
+	}
+
+	records := dnssec.NewDenialRecords(validated)
+	if records.IsEmpty() {
+		return nil, errMissingDenialProof
+	}
+	return records, nil
+}
+
+func (v *Validator) verifyRRset(rrset *g53.RRset, sigs []*g53.RRSig, keys *g53.RRset) (*g53.RRSig, error) {
+	now := v.now()
+	for _, sig := range sigs {
+		if sig.Signer.Equals(keys.Name) == false || dnssec.IsSignatureValidAt(sig, now) == false {
+			continue
+		}
+
+		for _, rdata := range keys.Rdatas {
+			key, ok := rdata.(*dnssec.DNSKEY)
+			if ok && dnssec.Verify(rrset, sig, key) == nil {
+				return sig, nil
+			}
+		}
+	}
+	return nil, errNoValidSignature
+}
+
+func findRRset(section g53.Section, name *g53.Name, typ g53.RRType) *g53.RRset {
+	for _, rrset := range section {
+		if rrset.Type == typ && rrset.Name.Equals(name) {
+			return rrset
+		}
+	}
+	return nil
+}
+
+func signaturesFor(section g53.Section, rrset *g53.RRset) []*g53.RRSig {
+	var sigs []*g53.RRSig
+	for _, sigRRset := range section {
+		if sigRRset.Type != g53.RR_RRSIG || sigRRset.Name.Equals(rrset.Name) == false {
+			continue
+		}
+		for _, rdata := range sigRRset.Rdatas {
+			if sig := rdata.(*g53.RRSig); sig.Covered == rrset.Type {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	return sigs
+}
+
+func signerOfSection(section g53.Section) *g53.Name {
+	for _, rrset := range section {
+		if rrset.Type == g53.RR_RRSIG && len(rrset.Rdatas) > 0 {
+			return rrset.Rdatas[0].(*g53.RRSig).Signer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package recursor
+
+import (
+	"crypto"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/cache"
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/dnssec"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/querysource"
+	vutil "github.com/ben-han-cn/vanguard/util"
+	view "github.com/ben-han-cn/vanguard/viewselector"
+)
+
+type recursorHandler struct {
+	core.DefaultHandler
+	r *Recursor
+}
+
+func (h *recursorHandler) HandleQuery(ctx *core.Context) {
+	h.r.Resolve(&ctx.Client)
+}
+
+type testZone struct {
+	name   *g53.Name
+	rrsets map[string][]*g53.RRset
+	sigs   map[string][]*g53.RRset
+	nsecs  []*g53.RRset
+	signed bool
+}
+
+func newTestZone(name string, key *dnssec.DNSKEY, priv crypto.Signer, rrs []string) *testZone {
+	z := &testZone{
+		name:   g53.NameFromStringUnsafe(name),
+		rrsets: make(map[string][]*g53.RRset),
+		sigs:   make(map[string][]*g53.RRset),
+		signed: key != nil,
+	}
+	if key != nil {
+		rrs = append(rrs, name+" 3600 IN DNSKEY "+key.String())
+	}
+
+	var names []*g53.Name
+	for _, rr := range rrs {
+		rrset, err := dnssec.RRsetFromString(rr)
+		if err != nil {
+			panic("invalid rr " + rr + ":" + err.Error())
+		}
+		owner := rrset.Name.String(false)
+		if _, ok := z.rrsets[owner]; ok == false {
+			names = append(names, rrset.Name)
+		}
+		z.rrsets[owner] = append(z.rrsets[owner], rrset)
+	}
+	if key == nil {
+		return z
+	}
+
+	now := time.Now()
+	sign := func(rrset *g53.RRset) {
+		sig, err := dnssec.Sign(rrset, z.name, key, priv, now.Add(-time.Hour), now.Add(time.Hour))
+		if err != nil {
+			panic("sign failed:" + err.Error())
+		}
+		owner := rrset.Name.String(false)
+		z.sigs[owner] = append(z.sigs[owner], &g53.RRset{
+			Name:   rrset.Name,
+			Type:   g53.RR_RRSIG,
+			Class:  g53.CLASS_IN,
+			Ttl:    rrset.Ttl,
+			Rdatas: []g53.Rdata{sig},
+		})
+	}
+
+	sort.Slice(names, func(i, j int) bool { return dnssec.CompareName(names[i], names[j]) < 0 })
+	for i, owner := range names {
+		types := []g53.RRType{g53.RR_RRSIG, g53.RR_NSEC}
+		for _, rrset := range z.rrsets[owner.String(false)] {
+			types = append(types, rrset.Type)
+			if rrset.Type != g53.RR_NS || owner.Equals(z.name) {
+				sign(rrset)
+			}
+		}
+		nsec := &g53.RRset{
+			Name:   owner,
+			Type:   g53.RR_NSEC,
+			Class:  g53.CLASS_IN,
+			Ttl:    3600,
+			Rdatas: []g53.Rdata{&dnssec.NSEC{NextName: names[(i+1)%len(names)], Types: types}},
+		}
+		sign(nsec)
+		z.nsecs = append(z.nsecs, nsec)
+	}
+	return z
+}
+
+func (z *testZone) find(name *g53.Name, typ g53.RRType) *g53.RRset {
+	for _, rrset := range z.rrsets[name.String(false)] {
+		if rrset.Type == typ {
+			return rrset
+		}
+	}
+	return nil
+}
+
+func (z *testZone) withSig(name *g53.Name, rrset *g53.RRset) g53.Section {
+	section := g53.Section{rrset}
+	for _, sig := range z.sigs[name.String(false)] {
+		if sig.Rdatas[0].(*g53.RRSig).Covered == rrset.Type {
+			sigRRset := *sig
+			sigRRset.Name = rrset.Name
+			section = append(section, &sigRRset)
+		}
+	}
+	return section
+}
+
+func (z *testZone) denial() g53.Section {
+	section := z.withSig(z.name, z.find(z.name, g53.RR_SOA))
+	for _, nsec := range z.nsecs {
+		section = append(section, z.withSig(nsec.Name, nsec)...)
+	}
+	return section
+}
+
+func (z *testZone) answer(query *g53.Message) *g53.Message {
+	resp := query.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_AA, true)
+	resp.Edns = query.Edns
+	name, typ := query.Question.Name, query.Question.Type
+	if rrset := z.find(name, typ); rrset != nil {
+		resp.Sections[g53.AnswerSection] = z.withSig(name, rrset)
+	} else if _, ok := z.rrsets[name.String(false)]; ok {
+		resp.Sections[g53.AuthSection] = z.denial()
+	} else {
+		parent, _ := name.Parent(1)
+		wildcard, _ := g53.NameFromStringUnsafe("*").Concat(parent)
+		if rrset := z.find(wildcard, typ); rrset != nil {
+			expanded := rrset.Clone()
+			expanded.Name = name
+			resp.Sections[g53.AnswerSection] = z.withSig(wildcard, expanded)
+			resp.Sections[g53.AuthSection] = z.denial()
+		} else {
+			resp.Header.Rcode = g53.R_NXDOMAIN
+			resp.Sections[g53.AuthSection] = z.denial()
+		}
+	}
+	resp.RecalculateSectionRRCount()
+	return resp
+}
+
+func runAuthServer(t *testing.T, zones []*testZone) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	ut.Assert(t, err == nil, "listen udp failed %v", err)
+
+	go func() {
+		buf := make([]byte, 512)
+		render := g53.NewMsgRender()
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := g53.MessageFromWire(util.NewInputBuffer(buf[:n]))
+			if err != nil {
+				continue
+			}
+
+			qname := query.Question.Name
+			if query.Question.Type == g53.RR_DS {
+				qname, _ = qname.Parent(1)
+			}
+			var zone *testZone
+			for _, z := range zones {
+				if qname.IsSubDomain(z.name) && (zone == nil || z.name.LabelCount() > zone.name.LabelCount()) {
+					zone = z
+				}
+			}
+			zone.answer(query).Rend(render)
+			conn.WriteToUDP(render.Data(), addr)
+			render.Clear()
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestValidator(t *testing.T) {
+	logger.UseDefaultLogger("error")
+	rootKey, rootPriv, _ := dnssec.GenerateKey(dnssec.ECDSAP256SHA256, dnssec.DNSKEY_FLAG_ZONE|dnssec.DNSKEY_FLAG_SEP)
+	exampleKey, examplePriv, _ := dnssec.GenerateKey(dnssec.ED25519, dnssec.DNSKEY_FLAG_ZONE|dnssec.DNSKEY_FLAG_SEP)
+	rootDS, _ := dnssec.MakeDS(g53.Root, rootKey, dnssec.DigestSHA256)
+	exampleDS, _ := dnssec.MakeDS(g53.NameFromStringUnsafe("example."), exampleKey, dnssec.DigestSHA256)
+
+	root := newTestZone(".", rootKey, rootPriv, []string{
+		". 3600 IN SOA ns.root. root.root. 1 3600 600 86400 300",
+		". 3600 IN NS ns.root.",
+		"example. 3600 IN NS ns.example.",
+		"example. 3600 IN DS " + exampleDS.String(),
+		"insecure. 3600 IN NS ns.insecure.",
+	})
+	example := newTestZone("example.", exampleKey, examplePriv, []string{
+		"example. 3600 IN SOA ns.example. root.example. 1 3600 600 86400 300",
+		"example. 3600 IN NS ns.example.",
+		"www.example. 3600 IN A 1.1.1.1",
+		"*.wild.example. 3600 IN A 2.2.2.2",
+		"bogus.example. 3600 IN A 3.3.3.3",
+	})
+	example.find(g53.NameFromStringUnsafe("bogus.example."), g53.RR_A).Rdatas[0], _ = g53.AFromString("4.4.4.4")
+	insecure := newTestZone("insecure.", nil, nil, []string{
+		"insecure. 3600 IN SOA ns.insecure. root.insecure. 1 3600 600 86400 300",
+		"host.insecure. 3600 IN A 5.5.5.5",
+	})
+	addr := runAuthServer(t, []*testZone{root, example, insecure})
+
+	anchorFile, _ := ioutil.TempFile("", "anchor")
+	defer os.Remove(anchorFile.Name())
+	anchorFile.WriteString(". IN DS " + rootDS.String() + "\n")
+	anchorFile.Close()
+
+	conf := &config.VanguardConf{}
+	conf.Recursor = []config.RecursorInView{{
+		View:            "default",
+		Enable:          true,
+		DnssecEnable:    true,
+		TrustAnchorFile: anchorFile.Name(),
+	}}
+	view.NewSelectorMgr(conf)
+	querysource.NewQuerySourceManager(conf)
+	r := NewRecursor(conf)
+	r.stopBackgroundTask()
+	r.rootForView["default"] = []*NameServer{&NameServer{
+		zone: g53.Root,
+		name: g53.NameFromStringUnsafe("ns.root."),
+		addr: addr,
+	}}
+
+	resolve := func(name string, dnssecAware bool) *g53.Message {
+		var client core.Client
+		client.Request = g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 1232, dnssecAware)
+		client.Addr, _ = net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		client.View = "default"
+		client.CacheAnswer = true
+		r.Resolve(&client)
+		ut.Assert(t, client.Response != nil, "query %s should get response", name)
+		return vutil.ResponseForClient(client.Request, client.Response)
+	}
+
+	resp := resolve("www.example.", true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD), "www.example. should be secure")
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+
+	resp = resolve("www.example.", false)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD) == false, "ad shouldn't be set for client without do bit")
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+
+	resp = resolve("nx.example.", true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NXDOMAIN)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD), "nxdomain should be secure")
+
+	resp = resolve("a.wild.example.", true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD), "wildcard answer should be secure")
+
+	resp = resolve("bogus.example.", true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_SERVFAIL)
+
+	resp = resolve("host.insecure.", true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD) == false, "insecure answer shouldn't be secure")
+	ut.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "5.5.5.5")
+
+	conf.Cache.MaxCacheSize = 100
+	c := cache.NewCache(conf)
+	core.BuildQueryChain(c, &recursorHandler{r: r})
+	cachedResolve := func(name string, dnssecAware, checkDisabled bool) *g53.Message {
+		ctx := core.NewContext()
+		ctx.Reset()
+		ctx.Client.Request = g53.MakeQuery(g53.NameFromStringUnsafe(name), g53.RR_A, 1232, dnssecAware)
+		ctx.Client.Request.Header.SetFlag(g53.FLAG_CD, checkDisabled)
+		ctx.Client.Addr, _ = net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		c.HandleQuery(ctx)
+		ut.Assert(t, ctx.Client.Response != nil, "query %s should get response", name)
+		return vutil.ResponseForClient(ctx.Client.Request, ctx.Client.Response)
+	}
+
+	resp = cachedResolve("bogus.example.", true, true)
+	ut.Equal(t, resp.Header.Rcode, g53.R_NOERROR)
+	resp = cachedResolve("bogus.example.", true, false)
+	ut.Equal(t, resp.Header.Rcode, g53.R_SERVFAIL)
+
+	resp = cachedResolve("www.example.", false, false)
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD) == false, "ad shouldn't be set for client without do bit")
+	resp = cachedResolve("www.example.", true, false)
+	ut.Assert(t, resp.Header.GetFlag(g53.FLAG_AD), "cached www.example. should be secure")
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+}
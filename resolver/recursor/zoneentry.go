@@ -31,14 +31,17 @@ func newZoneEntry(name *g53.Name, nameServers []*g53.Name, ttl time.Duration, tr
 	}
 }
 
-func (zone *ZoneEntry) selectNameServer(nameServers *NameServerManager) (servers []*NameServer) {
+func (zone *ZoneEntry) selectNameServer(nameServers *NameServerManager, policy FamilyPolicy) (servers []*NameServer) {
 	for _, name := range zone.nameServers {
 		ns := nameServers.getNameServer(name)
 		if ns == nil || ns.isExpired() {
 			continue
 		}
 
-		server := ns.selectNameServer()
+		server := ns.selectNameServer(policy)
+		if server == nil {
+			continue
+		}
 		server.zone = zone.zone
 		servers = append(servers, server)
 	}
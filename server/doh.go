@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ben-han-cn/vanguard/config"
+	"github.com/ben-han-cn/vanguard/logger"
+)
+
+const (
+	defaultDoHPath     = "/dns-query"
+	dohContentType     = "application/dns-message"
+	maxDoHMessageLen   = 65535
+	dohResponseTimeout = 5 * time.Second
+)
+
+var (
+	errDoHMissingQuery     = errors.New("missing dns query")
+	errDoHInvalidMethod    = errors.New("method not allowed")
+	errDoHInvalidMediaType = errors.New("unsupported media type")
+	errDoHMessageTooLong   = errors.New("dns message is too long")
+)
+
+type DoHServer struct {
+	server      *http.Server
+	certFile    string
+	keyFile     string
+	messageChan chan<- message
+}
+
+func newDoHServer(conf *config.VanguardConf) *DoHServer {
+	dohConf := &conf.Server.DoH
+	if dohConf.Addr == "" {
+		return nil
+	}
+
+	path := dohConf.Path
+	if path == "" {
+		path = defaultDoHPath
+	}
+
+	s := &DoHServer{
+		certFile: dohConf.CertFile,
+		keyFile:  dohConf.KeyFile,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.serveDNS)
+	s.server = &http.Server{
+		Addr:    dohConf.Addr,
+		Handler: mux,
+	}
+	return s
+}
+
+func (s *DoHServer) run(messageChan chan<- message) {
+	s.messageChan = messageChan
+	go func() {
+		var err error
+		if s.certFile != "" {
+			err = s.server.ListenAndServeTLS(s.certFile, s.keyFile)
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.GetLogger().Error("doh server stopped: %s", err.Error())
+		}
+	}()
+}
+
+func (s *DoHServer) Close() {
+	s.server.Close()
+}
+
+func (s *DoHServer) serveDNS(w http.ResponseWriter, r *http.Request) {
+	buf, err := dohRequestMessage(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == errDoHInvalidMethod {
+			status = http.StatusMethodNotAllowed
+		} else if err == errDoHInvalidMediaType {
+			status = http.StatusUnsupportedMediaType
+		} else if err == errDoHMessageTooLong {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var destAddr net.Addr
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		destAddr = localAddr
+	}
+
+	respChan := make(chan []byte, 1)
+	timer := time.NewTimer(dohResponseTimeout)
+	defer timer.Stop()
+	select {
+	case s.messageChan <- message{
+		usingTCP: true,
+		addr:     addr,
+		destAddr: destAddr,
+		buf:      buf,
+		respChan: respChan,
+	}:
+	case <-r.Context().Done():
+		return
+	case <-timer.C:
+		http.Error(w, "query timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	select {
+	case resp, ok := <-respChan:
+		if ok == false {
+			http.Error(w, "no response", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(resp)
+	case <-r.Context().Done():
+	case <-timer.C:
+		http.Error(w, "query timeout", http.StatusGatewayTimeout)
+	}
+}
+
+func dohRequestMessage(r *http.Request) ([]byte, error) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query().Get("dns")
+		if query == "" {
+			return nil, errDoHMissingQuery
+		}
+		if base64.RawURLEncoding.DecodedLen(len(query)) > maxDoHMessageLen {
+			return nil, errDoHMessageTooLong
+		}
+		return base64.RawURLEncoding.DecodeString(query)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			return nil, errDoHInvalidMediaType
+		}
+		buf, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDoHMessageLen+1))
+		if err != nil {
+			return nil, err
+		} else if len(buf) > maxDoHMessageLen {
+			return nil, errDoHMessageTooLong
+		} else if len(buf) == 0 {
+			return nil, errDoHMissingQuery
+		}
+		return buf, nil
+	default:
+		return nil, errDoHInvalidMethod
+	}
+}
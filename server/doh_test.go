@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+)
+
+func TestDoHRequestMessage(t *testing.T) {
+	qname, _ := g53.NameFromString("www.knet.cn.")
+	query := g53.MakeQuery(qname, g53.RR_A, 1024, false)
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	wire := render.Data()
+
+	req := httptest.NewRequest("GET", "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(wire), nil)
+	buf, err := dohRequestMessage(req)
+	ut.Assert(t, err == nil, "get request should be valid:%v", err)
+	ut.Equal(t, buf, wire)
+
+	req = httptest.NewRequest("GET", "/dns-query", nil)
+	_, err = dohRequestMessage(req)
+	ut.Equal(t, err, errDoHMissingQuery)
+
+	req = httptest.NewRequest("POST", "/dns-query", bytes.NewReader(wire))
+	req.Header.Set("Content-Type", dohContentType)
+	buf, err = dohRequestMessage(req)
+	ut.Assert(t, err == nil, "post request should be valid:%v", err)
+	ut.Equal(t, buf, wire)
+
+	req = httptest.NewRequest("POST", "/dns-query", bytes.NewReader(wire))
+	req.Header.Set("Content-Type", "application/json")
+	_, err = dohRequestMessage(req)
+	ut.Equal(t, err, errDoHInvalidMediaType)
+
+	req = httptest.NewRequest("PUT", "/dns-query", bytes.NewReader(wire))
+	_, err = dohRequestMessage(req)
+	ut.Equal(t, err, errDoHInvalidMethod)
+}
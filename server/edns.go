@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/ecs"
+)
+
+const (
+	minUDPPayloadSize    = 512
+	defaultMaxUDPPayload = 1232
+)
+
+func udpPayloadLimit(request *g53.Message, serverMax uint16) int {
+	if request.Edns == nil || request.Edns.UdpSize <= minUDPPayloadSize {
+		return minUDPPayloadSize
+	}
+
+	if request.Edns.UdpSize < serverMax {
+		return int(request.Edns.UdpSize)
+	} else {
+		return int(serverMax)
+	}
+}
+
+func setResponseEdns(request, response *g53.Message, serverMax uint16) {
+	if request.Edns == nil {
+		response.Edns = nil
+		return
+	}
+
+	edns := &g53.EDNS{
+		UdpSize:     serverMax,
+		DnssecAware: request.Edns.DnssecAware,
+	}
+	if response.Edns != nil {
+		edns.Options = response.Edns.Options
+		//subnet kept in response for cache isn't for the client without it
+		if ecs.FromEdns(request.Edns) == nil {
+			ecs.SetEdns(edns, nil)
+		}
+	}
+	response.Edns = edns
+}
+
+func makeTruncatedResponse(response *g53.Message) *g53.Message {
+	truncated := &g53.Message{
+		Header:   response.Header,
+		Question: response.Question,
+		Edns:     response.Edns,
+	}
+	truncated.Header.SetFlag(g53.FLAG_TC, true)
+	truncated.RecalculateSectionRRCount()
+	return truncated
+}
+
+func (s *Server) rendResponse(request, response *g53.Message, usingTCP bool, render *g53.MsgRender) {
+	setResponseEdns(request, response, s.maxUDPSize)
+	response.RecalculateSectionRRCount()
+	response.Rend(render)
+	if usingTCP == false && int(render.Len()) > udpPayloadLimit(request, s.maxUDPSize) {
+		render.Clear()
+		makeTruncatedResponse(response).Rend(render)
+	}
+}
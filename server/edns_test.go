@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+)
+
+func makeTXTResponse(request *g53.Message, count int) *g53.Message {
+	response := request.MakeResponse()
+	rrset := &g53.RRset{
+		Name:  request.Question.Name,
+		Type:  g53.RR_TXT,
+		Class: g53.CLASS_IN,
+		Ttl:   g53.RRTTL(3600),
+	}
+	for i := 0; i < count; i++ {
+		txt, _ := g53.TxtFromString("\"0123456789012345678901234567890123456789\"")
+		rrset.Rdatas = append(rrset.Rdatas, txt)
+	}
+	response.AddRRset(g53.AnswerSection, rrset)
+	return response
+}
+
+func TestUDPPayloadLimit(t *testing.T) {
+	qname, _ := g53.NameFromString("www.knet.cn.")
+	request := g53.MakeQuery(qname, g53.RR_TXT, 4096, false)
+	ut.Equal(t, udpPayloadLimit(request, 1232), 1232)
+	request.Edns.UdpSize = 1000
+	ut.Equal(t, udpPayloadLimit(request, 1232), 1000)
+	request.Edns.UdpSize = 100
+	ut.Equal(t, udpPayloadLimit(request, 1232), minUDPPayloadSize)
+	request.Edns = nil
+	ut.Equal(t, udpPayloadLimit(request, 1232), minUDPPayloadSize)
+}
+
+func TestRendResponseTruncate(t *testing.T) {
+	s := &Server{maxUDPSize: 1232}
+	render := g53.NewMsgRender()
+	qname, _ := g53.NameFromString("www.knet.cn.")
+	request := g53.MakeQuery(qname, g53.RR_TXT, 4096, true)
+
+	s.rendResponse(request, makeTXTResponse(request, 5), false, render)
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "response should be valid:%v", err)
+	ut.Equal(t, resp.Header.GetFlag(g53.FLAG_TC), false)
+	ut.Equal(t, resp.Header.ANCount, uint16(5))
+	ut.Assert(t, resp.Edns != nil, "opt should be echoed")
+	ut.Equal(t, resp.Edns.UdpSize, uint16(1232))
+	ut.Equal(t, resp.Edns.DnssecAware, true)
+	render.Clear()
+
+	s.rendResponse(request, makeTXTResponse(request, 50), false, render)
+	ut.Assert(t, render.Len() <= 1232, "response should be truncated")
+	resp, err = g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "response should be valid:%v", err)
+	ut.Equal(t, resp.Header.GetFlag(g53.FLAG_TC), true)
+	ut.Equal(t, resp.Header.ANCount, uint16(0))
+	ut.Assert(t, resp.Edns != nil, "opt should be echoed")
+	render.Clear()
+
+	s.rendResponse(request, makeTXTResponse(request, 50), true, render)
+	resp, err = g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "response should be valid:%v", err)
+	ut.Equal(t, resp.Header.GetFlag(g53.FLAG_TC), false)
+	ut.Equal(t, resp.Header.ANCount, uint16(50))
+	render.Clear()
+
+	request.Edns = nil
+	s.rendResponse(request, makeTXTResponse(request, 5), false, render)
+	resp, err = g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	ut.Assert(t, err == nil, "response should be valid:%v", err)
+	ut.Assert(t, resp.Edns == nil, "no opt for request without edns")
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/ben-han-cn/vanguard/httpcmd"
 	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/metrics"
+	vutil "github.com/ben-han-cn/vanguard/util"
 )
 
 const (
@@ -23,28 +24,39 @@ type message struct {
 	addr     net.Addr
 	destAddr net.Addr
 	conn     net.Conn
+	tcpConn  *tcpConn
 	buf      []byte
+	respChan chan []byte
 }
 
 type Server struct {
-	conf         *config.VanguardConf
-	transport    *Transport
-	queryHandler core.DNSQueryHandler
-	xfrHander    core.DNSQueryHandler
-	messageChan  chan message
+	conf            *config.VanguardConf
+	transport       *Transport
+	dohServer       *DoHServer
+	queryHandler    core.DNSQueryHandler
+	xfrHander       core.DNSQueryHandler
+	transferHandler TransferHandler
+	updateHandler   core.DNSQueryHandler
+	messageChan     chan message
 
 	handlerRoutineCount int
+	maxUDPSize          uint16
 	stopChan            chan struct{}
 	wg                  sync.WaitGroup
 }
 
-func NewServer(conf *config.VanguardConf, queryHandler core.DNSQueryHandler, xfrHander core.DNSQueryHandler) (*Server, error) {
+func NewServer(conf *config.VanguardConf, queryHandler, xfrHander, updateHandler core.DNSQueryHandler) (*Server, error) {
 	handlerCount := conf.Server.HandlerCount
 	if handlerCount == 0 {
 		handlerCount = defaultHandlerCount
 	}
 
-	transport, err := newTransport(conf, handlerCount)
+	maxUDPSize := conf.Server.MaxUDPSize
+	if maxUDPSize < minUDPPayloadSize {
+		maxUDPSize = defaultMaxUDPPayload
+	}
+
+	transport, err := newTransport(conf, handlerCount, int(maxUDPSize))
 	if err != nil {
 		return nil, err
 	}
@@ -52,13 +64,20 @@ func NewServer(conf *config.VanguardConf, queryHandler core.DNSQueryHandler, xfr
 	s := &Server{
 		conf:                conf,
 		transport:           transport,
+		dohServer:           newDoHServer(conf),
 		messageChan:         make(chan message, handlerCount),
 		queryHandler:        queryHandler,
 		xfrHander:           xfrHander,
+		updateHandler:       updateHandler,
 		handlerRoutineCount: handlerCount,
+		maxUDPSize:          maxUDPSize,
 		stopChan:            make(chan struct{}),
 	}
 
+	if transferHandler, ok := xfrHander.(TransferHandler); ok {
+		s.transferHandler = transferHandler
+	}
+
 	httpcmd.RegisterHandler(s, []httpcmd.Command{&Reconfig{}, &Stop{}, &Ping{}})
 
 	return s, nil
@@ -67,11 +86,22 @@ func NewServer(conf *config.VanguardConf, queryHandler core.DNSQueryHandler, xfr
 func (s *Server) Run() {
 	s.startHandlerRoutine(s.handlerRoutineCount)
 	s.transport.run(s.messageChan)
+	if s.dohServer != nil {
+		s.dohServer.run(s.messageChan)
+	}
 }
 
 func (s *Server) Shutdown() {
 	s.transport.Close()
+	if s.dohServer != nil {
+		s.dohServer.Close()
+	}
 	s.stop()
+	for h := s.queryHandler; h != nil; h = h.Next() {
+		if hook, ok := h.(core.ShutdownHook); ok {
+			hook.Shutdown()
+		}
+	}
 }
 
 func (s *Server) startHandlerRoutine(handlerCount int) {
@@ -95,34 +125,48 @@ func (s *Server) startHandlerRoutine(handlerCount int) {
 				case <-s.stopChan:
 					return
 				case message := <-s.messageChan:
-					inputBuff.SetData(message.buf)
-					err := request.FromWire(inputBuff)
-					if err == nil {
-						ctx.Reset()
-						ctx.Client.Addr = message.addr
-						ctx.Client.DestAddr = message.destAddr
-						ctx.Client.Request = &request
-						ctx.Client.UsingTCP = message.usingTCP
-						if request.Header.Opcode == g53.OP_QUERY {
-							s.queryHandler.HandleQuery(ctx)
-						} else if request.Header.Opcode == g53.OP_NOTIFY && s.xfrHander != nil {
-							s.xfrHander.HandleQuery(ctx)
-						} else {
-							logger.GetLogger().Error("invalid opcode")
-						}
-						metrics.RecordMetrics(ctx.Client)
-						if ctx.Client.Response != nil {
-							ctx.Client.Response.RecalculateSectionRRCount()
-							ctx.Client.Response.Rend(render)
-							s.transport.SendResponse(&message, render.Data())
-							render.Clear()
-						}
-					} else {
-						logger.GetLogger().Error("get invalid query %s", err.Error())
-					}
-					s.transport.FinishQuery(&message)
+					s.handleMessage(ctx, &message, &request, inputBuff, render)
 				}
 			}
 		}()
 	}
 }
+
+func (s *Server) handleMessage(ctx *core.Context, message *message, request *g53.Message, inputBuff *util.InputBuffer, render *g53.MsgRender) {
+	//query has to be finished even if handler crashed, otherwise doh
+	//will wait until timeout and tcp connection won't be released
+	defer s.transport.FinishQuery(message)
+
+	inputBuff.SetData(message.buf)
+	if err := request.FromWire(inputBuff); err != nil {
+		logger.GetLogger().Error("get invalid query %s", err.Error())
+		return
+	}
+
+	ctx.Reset()
+	ctx.Client.Addr = message.addr
+	ctx.Client.DestAddr = message.destAddr
+	ctx.Client.Request = request
+	ctx.Client.UsingTCP = message.usingTCP
+	responseSent := false
+	if s.transferHandler != nil && isTransferQuery(request) {
+		responseSent = s.handleTransfer(ctx, message, render)
+	} else if request.Header.Opcode == g53.OP_QUERY {
+		s.queryHandler.HandleQuery(ctx)
+		if ctx.Client.Response != nil {
+			ctx.Client.Response = vutil.ResponseForClient(request, ctx.Client.Response)
+		}
+	} else if request.Header.Opcode == g53.OP_NOTIFY && s.xfrHander != nil {
+		s.xfrHander.HandleQuery(ctx)
+	} else if request.Header.Opcode == g53.OP_UPDATE && s.updateHandler != nil {
+		s.updateHandler.HandleQuery(ctx)
+	} else {
+		logger.GetLogger().Error("invalid opcode")
+	}
+	metrics.RecordMetrics(ctx.Client)
+	if ctx.Client.Response != nil && responseSent == false {
+		s.rendResponse(request, ctx.Client.Response, message.usingTCP, render)
+		s.transport.SendResponse(message, render.Data())
+		render.Clear()
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/core"
+)
+
+type crashHandler struct {
+	core.DefaultHandler
+}
+
+func (h *crashHandler) HandleQuery(ctx *core.Context) {
+	panic("handler crashed")
+}
+
+func TestFinishQueryWhenHandlerCrash(t *testing.T) {
+	s := &Server{
+		transport:    &Transport{},
+		queryHandler: &crashHandler{},
+	}
+
+	qname, _ := g53.NameFromString("www.knet.cn.")
+	query := g53.MakeQuery(qname, g53.RR_A, 1024, false)
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	msg := message{
+		buf:      render.Data(),
+		addr:     &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5353},
+		respChan: make(chan []byte, 1),
+	}
+
+	func() {
+		defer func() {
+			ut.Assert(t, recover() != nil, "handler should crash")
+		}()
+		var request g53.Message
+		s.handleMessage(core.NewContext(), &msg, &request, util.NewInputBuffer(nil), g53.NewMsgRender())
+	}()
+
+	_, ok := <-msg.respChan
+	ut.Assert(t, ok == false, "query should be finished after handler crash")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ben-han-cn/vanguard/util"
+)
+
+type tcpConn struct {
+	conn        net.Conn
+	writeLock   sync.Mutex
+	stateLock   sync.Mutex
+	inflight    int
+	readingDone bool
+	onClose     func()
+}
+
+func newTCPConn(conn net.Conn, onClose func()) *tcpConn {
+	return &tcpConn{
+		conn:    conn,
+		onClose: onClose,
+	}
+}
+
+func (c *tcpConn) serve(messageChan chan<- message, idleTimeout time.Duration, maxQueries int) {
+	//queries are pipelined, response is sent back once it's ready,
+	//so the order may be different from the queries
+	for i := 0; maxQueries == 0 || i < maxQueries; i++ {
+		buf, err := util.TCPRead(c.conn, idleTimeout)
+		if err != nil {
+			break
+		}
+
+		c.stateLock.Lock()
+		c.inflight += 1
+		c.stateLock.Unlock()
+
+		messageChan <- message{
+			usingTCP: true,
+			addr:     c.conn.RemoteAddr(),
+			destAddr: c.conn.LocalAddr(),
+			conn:     c.conn,
+			tcpConn:  c,
+			buf:      buf,
+		}
+	}
+
+	c.stateLock.Lock()
+	c.readingDone = true
+	c.closeIfIdle()
+	c.stateLock.Unlock()
+}
+
+func (c *tcpConn) writeMessage(response []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return util.TCPWrite(response, c.conn, tcpTimeout)
+}
+
+func (c *tcpConn) finishQuery() {
+	c.stateLock.Lock()
+	c.inflight -= 1
+	c.closeIfIdle()
+	c.stateLock.Unlock()
+}
+
+func (c *tcpConn) closeIfIdle() {
+	if c.readingDone && c.inflight == 0 {
+		c.conn.Close()
+		c.onClose()
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/vanguard/util"
+)
+
+func TestTCPConnPipeline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	closed := make(chan struct{})
+	c := newTCPConn(server, func() { close(closed) })
+	messageChan := make(chan message, 3)
+	go c.serve(messageChan, 100*time.Millisecond, 3)
+
+	queries := []string{"q1", "q2", "q3"}
+	go func() {
+		for _, q := range queries {
+			util.TCPWrite([]byte(q), client, time.Second)
+		}
+	}()
+
+	var messages []message
+	for i := 0; i < len(queries); i++ {
+		messages = append(messages, <-messageChan)
+	}
+
+	go func() {
+		for i := len(messages) - 1; i >= 0; i-- {
+			messages[i].tcpConn.writeMessage(messages[i].buf)
+			messages[i].tcpConn.finishQuery()
+		}
+	}()
+
+	for i := len(queries) - 1; i >= 0; i-- {
+		resp, err := util.TCPRead(client, time.Second)
+		ut.Assert(t, err == nil, "read response failed:%v", err)
+		ut.Equal(t, string(resp), queries[i])
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("conn should be closed after reach max query count")
+	}
+}
+
+func TestTCPConnIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	closed := make(chan struct{})
+	c := newTCPConn(server, func() { close(closed) })
+	go c.serve(make(chan message), 50*time.Millisecond, 0)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("idle conn should be closed")
+	}
+}
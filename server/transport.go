@@ -1,32 +1,50 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 	"sync/atomic"
+	"time"
 
-	g53util "github.com/ben-han-cn/g53/util"
 	"github.com/ben-han-cn/vanguard/config"
 	"github.com/ben-han-cn/vanguard/logger"
 	"github.com/ben-han-cn/vanguard/util"
 )
 
 const (
-	maxConcurrentTCPConn = 512
-	maxQueryLen          = 512
-	udpReceiveBuf        = 1024 * maxQueryLen
-	maxBufferFullCount   = 5
+	defaultMaxTCPConn     = 512
+	defaultTCPIdleTimeout = 10
+	udpReceiveBuf         = 1024 * 512
+	maxBufferFullCount    = 5
+	tcpTimeout            = 5 * time.Second
 )
 
 type Transport struct {
-	udpConns        []*net.UDPConn
-	tcpListeners    []*net.TCPListener
-	tcpConnCount    int32
-	udpBufPool      *util.BytePool
-	bufferFullCount int
+	udpConns           []*net.UDPConn
+	tcpListeners       []*net.TCPListener
+	tlsListeners       []net.Listener
+	tcpConnCount       int32
+	maxTCPConn         int32
+	tcpIdleTimeout     time.Duration
+	maxQueryPerTCPConn int
+	udpBufPool         *util.BytePool
+	udpBufLen          int
+	bufferFullCount    int
 }
 
-func newTransport(conf *config.VanguardConf, handlerCount int) (*Transport, error) {
-	t := &Transport{}
+func newTransport(conf *config.VanguardConf, handlerCount int, udpBufLen int) (*Transport, error) {
+	t := &Transport{
+		udpBufLen:          udpBufLen,
+		maxTCPConn:         int32(conf.Server.MaxTCPConn),
+		tcpIdleTimeout:     time.Duration(conf.Server.TCPIdleTimeout) * time.Second,
+		maxQueryPerTCPConn: conf.Server.MaxQueryPerTCPConn,
+	}
+	if t.maxTCPConn == 0 {
+		t.maxTCPConn = defaultMaxTCPConn
+	}
+	if t.tcpIdleTimeout == 0 {
+		t.tcpIdleTimeout = defaultTCPIdleTimeout * time.Second
+	}
 	if err := t.openUDP(conf); err != nil {
 		t.Close()
 		return nil, err
@@ -37,7 +55,12 @@ func newTransport(conf *config.VanguardConf, handlerCount int) (*Transport, erro
 		return nil, err
 	}
 
-	t.udpBufPool = util.NewBytePool(handlerCount, maxQueryLen)
+	if err := t.openTLS(conf); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	t.udpBufPool = util.NewBytePool(handlerCount, t.udpBufLen)
 	return t, nil
 }
 
@@ -84,11 +107,19 @@ func (t *Transport) openTCP(conf *config.VanguardConf) error {
 		return nil
 	}
 
+	tcpAddrs, err := resolveTCPAddrs(conf.Server.Addrs)
+	if err != nil {
+		return err
+	}
+	return t.bindTCPAddresses(tcpAddrs)
+}
+
+func resolveTCPAddrs(addrs []string) ([]*net.TCPAddr, error) {
 	var tcpAddrs []*net.TCPAddr
-	for _, addr := range conf.Server.Addrs {
+	for _, addr := range addrs {
 		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if tcpAddr.IP.IsUnspecified() {
@@ -103,8 +134,7 @@ func (t *Transport) openTCP(conf *config.VanguardConf) error {
 			tcpAddrs = append(tcpAddrs, tcpAddr)
 		}
 	}
-
-	return t.bindTCPAddresses(tcpAddrs)
+	return tcpAddrs, nil
 }
 
 func (t *Transport) bindTCPAddresses(addrs []*net.TCPAddr) error {
@@ -120,49 +150,74 @@ func (t *Transport) bindTCPAddresses(addrs []*net.TCPAddr) error {
 	return nil
 }
 
+func (t *Transport) openTLS(conf *config.VanguardConf) error {
+	tlsConf := &conf.Server.TLS
+	if len(tlsConf.Addrs) == 0 {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(tlsConf.CertFile, tlsConf.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	tcpAddrs, err := resolveTCPAddrs(tlsConf.Addrs)
+	if err != nil {
+		return err
+	}
+
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	for _, addr := range tcpAddrs {
+		listener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+		t.tlsListeners = append(t.tlsListeners, tls.NewListener(listener, serverConf))
+	}
+	return nil
+}
+
 func (t *Transport) run(messageChan chan<- message) {
 	t.runTCP(messageChan)
+	t.runTLS(messageChan)
 	t.runUDP(messageChan)
 }
 
 func (t *Transport) runTCP(messageChan chan<- message) {
 	for _, l := range t.tcpListeners {
-		go func(listener *net.TCPListener) {
-			for {
-				conn, err := listener.AcceptTCP()
-				if err != nil {
-					return
-				}
-
-				if atomic.LoadInt32(&t.tcpConnCount) < maxConcurrentTCPConn {
-					atomic.AddInt32(&t.tcpConnCount, 1)
-					go t.handleTCPConn(conn, messageChan)
-				} else {
-					conn.Close()
-				}
-			}
-		}(l)
+		go t.acceptConn(l, messageChan)
 	}
 }
 
-func (t *Transport) handleTCPConn(conn *net.TCPConn, messageChan chan<- message) {
-	buf, err := g53util.TCPRead(conn)
-	if err != nil {
-		t.releaseConn(conn)
-		return
+func (t *Transport) runTLS(messageChan chan<- message) {
+	//client address of tls conn is still tcp address, so view and acl
+	//works unchanged
+	for _, l := range t.tlsListeners {
+		go t.acceptConn(l, messageChan)
 	}
+}
+
+func (t *Transport) acceptConn(listener net.Listener, messageChan chan<- message) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
 
-	messageChan <- message{
-		usingTCP: true,
-		addr:     conn.RemoteAddr(),
-		destAddr: conn.LocalAddr(),
-		conn:     conn,
-		buf:      buf,
+		if atomic.LoadInt32(&t.tcpConnCount) < t.maxTCPConn {
+			atomic.AddInt32(&t.tcpConnCount, 1)
+			c := newTCPConn(conn, t.releaseConn)
+			go c.serve(messageChan, t.tcpIdleTimeout, t.maxQueryPerTCPConn)
+		} else {
+			conn.Close()
+		}
 	}
 }
 
-func (t *Transport) releaseConn(conn *net.TCPConn) {
-	conn.Close()
+func (t *Transport) releaseConn() {
 	atomic.AddInt32(&t.tcpConnCount, -1)
 }
 
@@ -172,7 +227,7 @@ func (t *Transport) runUDP(messageChan chan<- message) {
 			for {
 				buf := t.udpBufPool.Get()
 				n, addr, err := conn_.ReadFromUDP(buf)
-				if err == nil && n > 0 && n < maxQueryLen {
+				if err == nil && n > 0 && n < t.udpBufLen {
 					select {
 					case messageChan <- message{
 						usingTCP: false,
@@ -183,10 +238,10 @@ func (t *Transport) runUDP(messageChan chan<- message) {
 					}:
 					default:
 						logger.GetLogger().Warn("!!!udp buffer is full")
-						t.udpBufPool.Put(buf[:maxQueryLen])
+						t.udpBufPool.Put(buf[:t.udpBufLen])
 					}
 				} else {
-					t.udpBufPool.Put(buf[:maxQueryLen])
+					t.udpBufPool.Put(buf[:t.udpBufLen])
 				}
 			}
 		}(conn)
@@ -201,20 +256,31 @@ func (t *Transport) Close() {
 	for _, l := range t.tcpListeners {
 		l.Close()
 	}
+
+	for _, l := range t.tlsListeners {
+		l.Close()
+	}
 }
 
 func (t *Transport) SendResponse(q *message, response []byte) {
-	if q.usingTCP {
-		g53util.TCPWrite(response, q.conn.(*net.TCPConn))
-		t.releaseConn(q.conn.(*net.TCPConn))
+	if q.respChan != nil {
+		resp := make([]byte, len(response))
+		copy(resp, response)
+		q.respChan <- resp
+	} else if q.usingTCP {
+		q.tcpConn.writeMessage(response)
 	} else {
 		q.conn.(*net.UDPConn).WriteTo(response, q.addr)
 	}
 }
 
 func (t *Transport) FinishQuery(q *message) {
-	if q.usingTCP == false {
-		t.udpBufPool.Put(q.buf[:maxQueryLen])
+	if q.respChan != nil {
+		close(q.respChan)
+	} else if q.usingTCP {
+		q.tcpConn.finishQuery()
+	} else {
+		t.udpBufPool.Put(q.buf[:t.udpBufLen])
 	}
 }
 
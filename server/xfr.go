@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/logger"
+)
+
+type TransferHandler interface {
+	HandleTransfer(*core.Context) []*g53.Message
+	SignTransferMessage(*g53.TSIG, *g53.MsgRender, []byte) error
+}
+
+func isTransferQuery(request *g53.Message) bool {
+	return request.Header.Opcode == g53.OP_QUERY && request.Question != nil &&
+		(request.Question.Type == g53.RR_AXFR || request.Question.Type == g53.RR_IXFR)
+}
+
+func (s *Server) handleTransfer(ctx *core.Context, message *message, render *g53.MsgRender) bool {
+	client := &ctx.Client
+	//doh carries one response per request
+	if message.respChan != nil {
+		client.Response = client.Request.MakeResponse()
+		client.Response.Header.Rcode = g53.R_REFUSED
+		return false
+	}
+
+	responses := s.transferHandler.HandleTransfer(ctx)
+	if len(responses) == 0 {
+		return false
+	}
+
+	var priorMac []byte
+	for i, response := range responses {
+		tsig := response.Tsig
+		if i > 0 && tsig != nil {
+			response.Tsig = nil
+		}
+		s.rendResponse(client.Request, response, message.usingTCP, render)
+		if i > 0 && tsig != nil {
+			response.Tsig = tsig
+			if err := s.transferHandler.SignTransferMessage(tsig, render, priorMac); err != nil {
+				logger.GetLogger().Error("sign transfer message failed: %s", err.Error())
+				render.Clear()
+				break
+			}
+		}
+		if tsig != nil {
+			priorMac = tsig.MAC
+		}
+		s.transport.SendResponse(message, render.Data())
+		render.Clear()
+	}
+	client.Response = responses[len(responses)-1]
+	return true
+}
@@ -55,3 +55,35 @@ func NameStripFirstWildcard(name string) (bool, *g53.Name, error) {
 	dname, err := g53.NameFromString(name)
 	return hasWildcard, dname, err
 }
+
+func IsDNSSECType(typ g53.RRType) bool {
+	return typ == g53.RR_RRSIG || typ == g53.RR_DS || typ == g53.RR_NSEC || typ == g53.RR_NSEC3
+}
+
+func WithoutDNSSECRRsets(section g53.Section, keepType g53.RRType) g53.Section {
+	var rrsets g53.Section
+	for _, rrset := range section {
+		if rrset.Type == keepType || IsDNSSECType(rrset.Type) == false {
+			rrsets = append(rrsets, rrset)
+		}
+	}
+	return rrsets
+}
+
+func ResponseForClient(request, response *g53.Message) *g53.Message {
+	//client without do bit shouldn't get dnssec records it doesn't ask for,
+	//and ad bit is only returned to client which sets ad or do bit
+	if request.Edns != nil && request.Edns.DnssecAware {
+		return response
+	}
+
+	resp := *response
+	for _, st := range []g53.SectionType{g53.AnswerSection, g53.AuthSection, g53.AdditionalSection} {
+		resp.Sections[st] = WithoutDNSSECRRsets(response.Sections[st], request.Question.Type)
+	}
+	if request.Header.GetFlag(g53.FLAG_AD) == false {
+		resp.Header.SetFlag(g53.FLAG_AD, false)
+	}
+	resp.RecalculateSectionRRCount()
+	return &resp
+}
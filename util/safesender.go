@@ -9,6 +9,7 @@ import (
 
 type SafeUDPSender struct {
 	sender     *UDPSender
+	tcpSender  *TCPSender
 	renders    []*g53.MsgRender
 	renderLock sync.Mutex
 }
@@ -19,9 +20,15 @@ func NewSafeUDPSender(querySource string, timeout time.Duration) (*SafeUDPSender
 		return nil, err
 	}
 
+	tcpSender, err := NewTCPSender(querySource, timeout)
+	if err != nil {
+		return nil, err
+	}
+
 	return &SafeUDPSender{
-		sender:  sender,
-		renders: []*g53.MsgRender{},
+		sender:    sender,
+		tcpSender: tcpSender,
+		renders:   []*g53.MsgRender{},
 	}, nil
 }
 
@@ -32,6 +39,13 @@ func (f *SafeUDPSender) GetQuerySource() string {
 func (f *SafeUDPSender) Query(server string, query *g53.Message) (*g53.Message, time.Duration, error) {
 	render := f.getRender()
 	resp, rtt, err := f.sender.Query(server, render, query)
+	if err == nil && resp.Header.GetFlag(g53.FLAG_TC) {
+		//retry truncated answer over tcp, keep it if tcp doesn't work,
+		//rtt is measured by udp only since tcp includes the handshake
+		if tcpResp, _, tcpErr := f.tcpSender.Query(server, render, query); tcpErr == nil {
+			resp = tcpResp
+		}
+	}
 	f.releaseRender(render)
 	return resp, rtt, err
 }
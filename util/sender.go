@@ -7,10 +7,13 @@ import (
 
 	"github.com/ben-han-cn/g53"
 	gutil "github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/dnssec"
 )
 
 var errMalformedResponse = errors.New("response format error")
 
+const minRecvBufferSize = 1024
+
 type UDPSender struct {
 	dialer  *net.Dialer
 	timeout time.Duration
@@ -74,7 +77,11 @@ func (f *UDPSender) Query(server string, render *g53.MsgRender, query *g53.Messa
 
 	sendTime := time.Now()
 	conn.SetReadDeadline(sendTime.Add(f.timeout))
-	buf := make([]byte, 1024)
+	bufSize := minRecvBufferSize
+	if query.Edns != nil && int(query.Edns.UdpSize) > bufSize {
+		bufSize = int(query.Edns.UdpSize)
+	}
+	buf := make([]byte, bufSize)
 
 retry:
 	n, _, err := conn.ReadFromUDP(buf)
@@ -83,7 +90,7 @@ retry:
 	}
 
 	buffer := gutil.NewInputBuffer(buf[0:n])
-	msg, err := g53.MessageFromWire(buffer)
+	msg, err := responseFromWire(query, buffer)
 	if err != nil {
 		return nil, f.timeout, err
 	} else if msg.Header.Id == query.Header.Id {
@@ -99,6 +106,15 @@ retry:
 	}
 }
 
+func responseFromWire(query *g53.Message, buf *gutil.InputBuffer) (*g53.Message, error) {
+	//dnskey and nsec which g53 doesn't support only show up in response
+	//to query with do bit, which is sent when validating
+	if query.Edns != nil && query.Edns.DnssecAware {
+		return dnssec.MessageFromWire(buf)
+	}
+	return g53.MessageFromWire(buf)
+}
+
 func isResponseValid(req *g53.Message, resp *g53.Message) error {
 	if resp.Header.Rcode == g53.R_FORMERR {
 		return nil
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
+)
+
+const (
+	maxIdleConnPerServer = 4
+	tcpIdleTimeout       = 10 * time.Second
+)
+
+type idleConn struct {
+	conn     net.Conn
+	idleTime time.Time
+}
+
+type tcpConnPool struct {
+	conns     map[string][]idleConn
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+var connPool = &tcpConnPool{ //shared by all senders, keyed with query source
+	conns: make(map[string][]idleConn),
+}
+
+func (p *tcpConnPool) get(key string) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.conns[key]
+	for len(conns) > 0 {
+		c := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(c.idleTime) < tcpIdleTimeout {
+			p.conns[key] = conns
+			return c.conn
+		}
+		c.conn.Close()
+	}
+	delete(p.conns, key)
+	return nil
+}
+
+func (p *tcpConnPool) put(key string, conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastSweep) > tcpIdleTimeout {
+		p.sweep(now)
+	}
+
+	conns := p.conns[key]
+	if len(conns) >= maxIdleConnPerServer {
+		conn.Close()
+		return
+	}
+	p.conns[key] = append(conns, idleConn{conn: conn, idleTime: now})
+}
+
+func (p *tcpConnPool) sweep(now time.Time) {
+	//upstream closes idle connection too, don't keep them around
+	p.lastSweep = now
+	for key, conns := range p.conns {
+		alive := conns[:0]
+		for _, c := range conns {
+			if now.Sub(c.idleTime) < tcpIdleTimeout {
+				alive = append(alive, c)
+			} else {
+				c.conn.Close()
+			}
+		}
+		if len(alive) == 0 {
+			delete(p.conns, key)
+		} else {
+			p.conns[key] = alive
+		}
+	}
+}
+
+func (p *tcpConnPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, conns := range p.conns {
+		count += len(conns)
+	}
+	return count
+}
+
+type TCPSender struct {
+	dialer  *net.Dialer
+	tlsConf *tls.Config
+	timeout time.Duration
+}
+
+func NewTCPSender(querySource string, timeout time.Duration) (*TCPSender, error) {
+	sender := &TCPSender{
+		dialer: &net.Dialer{
+			Timeout: timeout,
+		},
+		timeout: timeout,
+	}
+
+	if querySource != "" {
+		localAddr, err := net.ResolveTCPAddr("tcp", querySource)
+		if err != nil {
+			return nil, err
+		}
+		//let system pick the port, udp query source port may be fixed
+		localAddr.Port = 0
+		sender.dialer.LocalAddr = localAddr
+	}
+	return sender, nil
+}
+
+func NewTLSSender(querySource string, timeout time.Duration, conf *tls.Config) (*TCPSender, error) {
+	sender, err := NewTCPSender(querySource, timeout)
+	if err != nil {
+		return nil, err
+	}
+	sender.tlsConf = conf
+	return sender, nil
+}
+
+func (f *TCPSender) poolKey(server string) string {
+	key := server
+	if f.dialer.LocalAddr != nil {
+		key = f.dialer.LocalAddr.String() + "-" + key
+	}
+	if f.tlsConf != nil {
+		//connection authenticated with one config isn't for another
+		key = fmt.Sprintf("tls-%p-%s", f.tlsConf, key)
+	}
+	return key
+}
+
+func (f *TCPSender) dial(server string) (net.Conn, error) {
+	if f.tlsConf == nil {
+		return f.dialer.Dial("tcp", server)
+	} else {
+		return tls.DialWithDialer(f.dialer, "tcp", server, f.tlsConf)
+	}
+}
+
+func (f *TCPSender) Query(server string, render *g53.MsgRender, query *g53.Message) (*g53.Message, time.Duration, error) {
+	query.Rend(render)
+	data := make([]byte, render.Len())
+	copy(data, render.Data())
+	render.Clear()
+
+	sendTime := time.Now()
+	key := f.poolKey(server)
+	conn := connPool.get(key)
+	if conn != nil {
+		//reused connection may be closed by server, retry with a new one
+		if msg, err := f.exchange(conn, data, query); err == nil {
+			connPool.put(key, conn)
+			return msg, time.Since(sendTime), nil
+		}
+		conn.Close()
+	}
+
+	conn, err := f.dial(server)
+	if err != nil {
+		return nil, f.timeout, err
+	}
+	msg, err := f.exchange(conn, data, query)
+	if err != nil {
+		conn.Close()
+		return nil, f.timeout, err
+	}
+	connPool.put(key, conn)
+	return msg, time.Since(sendTime), nil
+}
+
+func (f *TCPSender) exchange(conn net.Conn, data []byte, query *g53.Message) (*g53.Message, error) {
+	if err := TCPWrite(data, conn, f.timeout); err != nil {
+		return nil, err
+	}
+
+	buf, err := TCPRead(conn, f.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := responseFromWire(query, gutil.NewInputBuffer(buf))
+	if err != nil {
+		return nil, err
+	} else if msg.Header.Id != query.Header.Id {
+		return nil, errMalformedResponse
+	} else if err := isResponseValid(query, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
+)
+
+func runTruncateServer(t *testing.T, tcpDelay time.Duration) (string, *int32) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	ut.Assert(t, err == nil, "listen udp failed %v", err)
+	addr := udpConn.LocalAddr().String()
+	listener, err := net.Listen("tcp", addr)
+	ut.Assert(t, err == nil, "listen tcp failed %v", err)
+
+	answer := func(query *g53.Message) *g53.Message {
+		resp := query.MakeResponse()
+		rrset, _ := g53.RRsetFromString(query.Question.Name.String(false) + " 300 IN A 1.1.1.1")
+		resp.AddRRset(g53.AnswerSection, rrset)
+		resp.RecalculateSectionRRCount()
+		return resp
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		render := g53.NewMsgRender()
+		for {
+			n, remote, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, _ := g53.MessageFromWire(gutil.NewInputBuffer(buf[:n]))
+			resp := query.MakeResponse()
+			resp.Header.SetFlag(g53.FLAG_TC, true)
+			resp.Rend(render)
+			udpConn.WriteToUDP(render.Data(), remote)
+			render.Clear()
+		}
+	}()
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				render := g53.NewMsgRender()
+				for {
+					buf, err := TCPRead(conn, time.Second)
+					if err != nil {
+						return
+					}
+					query, _ := g53.MessageFromWire(gutil.NewInputBuffer(buf))
+					time.Sleep(tcpDelay)
+					answer(query).Rend(render)
+					TCPWrite(render.Data(), conn, time.Second)
+					render.Clear()
+				}
+			}()
+		}
+	}()
+	return addr, &accepted
+}
+
+func TestSafeUDPSenderTCPFallback(t *testing.T) {
+	server, accepted := runTruncateServer(t, 0)
+	sender, err := NewSafeUDPSender("", time.Second)
+	ut.Assert(t, err == nil, "create sender failed %v", err)
+
+	for i := 0; i < 3; i++ {
+		query := g53.MakeQuery(g53.NameFromStringUnsafe("www.knet.cn."), g53.RR_A, 512, false)
+		resp, _, err := sender.Query(server, query)
+		ut.Assert(t, err == nil, "query failed %v", err)
+		ut.Equal(t, resp.Header.GetFlag(g53.FLAG_TC), false)
+		ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+	}
+	ut.Equal(t, atomic.LoadInt32(accepted), int32(1))
+	ut.Equal(t, connPool.len(), 1)
+}
+
+func TestSafeUDPSenderTCPFallbackRtt(t *testing.T) {
+	tcpDelay := 200 * time.Millisecond
+	server, _ := runTruncateServer(t, tcpDelay)
+	sender, err := NewSafeUDPSender("", time.Second)
+	ut.Assert(t, err == nil, "create sender failed %v", err)
+
+	query := g53.MakeQuery(g53.NameFromStringUnsafe("www.knet.cn."), g53.RR_A, 512, false)
+	resp, rtt, err := sender.Query(server, query)
+	ut.Assert(t, err == nil, "query failed %v", err)
+	ut.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+	ut.Assert(t, rtt < tcpDelay, "rtt %v shouldn't include tcp fallback", rtt)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+func TCPRead(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func TCPWrite(data []byte, conn net.Conn, timeout time.Duration) error {
+	//send length and message in one write, so tls won't split them
+	buf := make([]byte, len(data)+2)
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := conn.Write(buf)
+	return err
+}
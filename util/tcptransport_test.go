@@ -0,0 +1,31 @@
+package util
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+)
+
+func TestTCPReadWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	msgs := [][]byte{[]byte("a"), []byte("hello"), make([]byte, 1000)}
+	go func() {
+		for _, msg := range msgs {
+			TCPWrite(msg, client, time.Second)
+		}
+	}()
+
+	for _, msg := range msgs {
+		buf, err := TCPRead(server, time.Second)
+		ut.Assert(t, err == nil, "read message failed:%v", err)
+		ut.Equal(t, buf, msg)
+	}
+
+	_, err := TCPRead(server, 10*time.Millisecond)
+	ut.Assert(t, err != nil, "read should timeout")
+}
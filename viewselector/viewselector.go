@@ -74,6 +74,40 @@ func (mgr *SelectorMgr) SelectView(ctx *core.Context) bool {
 	}
 }
 
+func (mgr *SelectorMgr) SelectTSIGView(ctx *core.Context) bool {
+	if tsigView := mgr.tsigSelector(); tsigView != nil {
+		if view, found := tsigView.ViewForQuery(&ctx.Client); found && view != "" {
+			ctx.Client.View = view
+			ctx.Client.ViewId = viewAndIds[view]
+			return true
+		}
+	}
+	return false
+}
+
+func (mgr *SelectorMgr) tsigSelector() *TSIGKeyBasedView {
+	for _, vs := range mgr.selectors {
+		if tsigView, ok := vs.(*TSIGKeyBasedView); ok {
+			return tsigView
+		}
+	}
+	return nil
+}
+
+func (mgr *SelectorMgr) KeyForView(view string) *TSIGKey {
+	if tsigView := mgr.tsigSelector(); tsigView != nil {
+		return tsigView.KeyForView(view)
+	}
+	return nil
+}
+
+func (mgr *SelectorMgr) GetKey(name string) *TSIGKey {
+	if tsigView := mgr.tsigSelector(); tsigView != nil {
+		return tsigView.keys[name]
+	}
+	return nil
+}
+
 func (mgr *SelectorMgr) allocateIdForView() {
 	viewAndIds = map[string]uint16{DefaultView: uint16(0)}
 	id := uint16(1)
@@ -94,7 +94,7 @@ func (g *XFRSMGenertor) genIXFRStateMachine() *fsm.FSM {
 			"after_get_none_soa": func(e *fsm.Event) {
 				rrset := e.Args[0].(*g53.RRset)
 				if e.Src == "delete_section" {
-					g.updator.DeleteRRset(g.tx, rrset)
+					g.updator.DeleteRr(g.tx, rrset)
 				} else {
 					g.updator.Add(g.tx, rrset)
 				}
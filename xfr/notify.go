@@ -0,0 +1,99 @@
+package xfr
+
+import (
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+	"github.com/ben-han-cn/vanguard/util"
+	"github.com/ben-han-cn/vanguard/viewselector"
+)
+
+const (
+	notifyTimeout    = 2 * time.Second
+	notifyRetryCount = 3
+)
+
+type notifier struct {
+	viewSelector *viewselector.SelectorMgr
+}
+
+func newNotifier(viewSelector *viewselector.SelectorMgr) *notifier {
+	return &notifier{
+		viewSelector: viewSelector,
+	}
+}
+
+func (n *notifier) zoneChanged(view string, z zone.Zone) {
+	targets := z.Notifies()
+	if len(targets) == 0 {
+		return
+	}
+
+	soa := z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+	if soa == nil {
+		return
+	}
+
+	key := n.viewSelector.KeyForView(view)
+	for _, target := range targets {
+		go n.sendNotify(target, soa, key)
+	}
+}
+
+func makeNotify(soa *g53.RRset, key *viewselector.TSIGKey) (*g53.Message, error) {
+	h := g53.Header{
+		Id:     gutil.GenMessageId(),
+		Opcode: g53.OP_NOTIFY,
+	}
+	h.SetFlag(g53.FLAG_AA, true)
+	notify := &g53.Message{
+		Header: h,
+		Question: &g53.Question{
+			Name:  soa.Name,
+			Type:  g53.RR_SOA,
+			Class: g53.CLASS_IN,
+		},
+	}
+	notify.AddRRset(g53.AnswerSection, soa)
+	notify.RecalculateSectionRRCount()
+
+	//the view is selected by key on secondary
+	if key != nil {
+		tsig, err := g53.NewTSIG(key.Name, key.Secret, key.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		notify.SetTSIG(tsig)
+	}
+	return notify, nil
+}
+
+func (n *notifier) sendNotify(target string, soa *g53.RRset, key *viewselector.TSIGKey) {
+	sender, err := util.NewUDPSender("", notifyTimeout)
+	if err != nil {
+		logger.GetLogger().Error("create sender to notify %s failed: %s", target, err.Error())
+		return
+	}
+	render := g53.NewMsgRender()
+	zoneName := soa.Name.String(false)
+	for i := 0; i < notifyRetryCount; i++ {
+		notify, err := makeNotify(soa, key)
+		if err != nil {
+			logger.GetLogger().Error("create notify for zone %s failed: %s", zoneName, err.Error())
+			return
+		}
+
+		resp, _, err := sender.Query(target, render, notify)
+		render.Clear()
+		if err == nil {
+			if resp.Header.Rcode != g53.R_NOERROR {
+				logger.GetLogger().Warn("notify zone %s to %s get rcode %s", zoneName, target, resp.Header.Rcode.String())
+			}
+			return
+		}
+	}
+	logger.GetLogger().Warn("notify zone %s to %s timeout", zoneName, target)
+}
@@ -0,0 +1,199 @@
+package xfr
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+	"github.com/ben-han-cn/vanguard/util"
+)
+
+const (
+	refreshCheckInterval = 5 * time.Second
+	soaQueryTimeout      = 3 * time.Second
+	minRetryInterval     = 10 * time.Second
+	maxRetryInterval     = time.Hour
+)
+
+type zoneRefreshState struct {
+	zone        zone.Zone
+	nextCheck   time.Time
+	lastSuccess time.Time
+	failures    uint
+}
+
+type zoneTimers struct {
+	refresh time.Duration
+	retry   time.Duration
+	expire  time.Duration
+}
+
+func refreshKey(view string, name *g53.Name) string {
+	return view + "/" + strings.ToLower(name.String(false))
+}
+
+func getZoneTimers(z zone.Zone) (zoneTimers, bool) {
+	soa := z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+	if soa == nil {
+		return zoneTimers{retry: minRetryInterval, refresh: maxRetryInterval}, false
+	}
+
+	rdata := soa.Rdatas[0].(*g53.SOA)
+	timers := zoneTimers{
+		refresh: time.Duration(rdata.Refresh) * time.Second,
+		retry:   time.Duration(rdata.Retry) * time.Second,
+		expire:  time.Duration(rdata.Expire) * time.Second,
+	}
+	if timers.retry < minRetryInterval {
+		timers.retry = minRetryInterval
+	}
+	if timers.refresh < timers.retry {
+		timers.refresh = timers.retry
+	}
+	return timers, true
+}
+
+func retryInterval(timers zoneTimers, failures uint) time.Duration {
+	interval := timers.retry
+	for i := uint(1); i < failures && interval < timers.refresh && interval < maxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > timers.refresh {
+		interval = timers.refresh
+	}
+	if interval > maxRetryInterval {
+		interval = maxRetryInterval
+	}
+	return interval
+}
+
+func (h *XFRRunner) runRefresh() {
+	ticker := time.NewTicker(refreshCheckInterval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		h.checkZones(time.Now())
+	}
+}
+
+func (h *XFRRunner) checkZones(now time.Time) {
+	type dueZone struct {
+		view string
+		zone zone.Zone
+	}
+
+	var dueZones []dueZone
+	seen := make(map[string]bool)
+	h.auth.ForEachZone(func(view string, z zone.Zone) {
+		if z.IsMaster() {
+			return
+		}
+
+		key := refreshKey(view, z.GetOrigin())
+		seen[key] = true
+		h.mu.Lock()
+		state, ok := h.refreshStates[key]
+		if ok == false || state.zone != z {
+			state = &zoneRefreshState{zone: z, nextCheck: now}
+			if timers, hasData := getZoneTimers(z); hasData {
+				state.lastSuccess = now
+				state.nextCheck = now.Add(timers.refresh)
+			}
+			h.refreshStates[key] = state
+		}
+		due := now.Before(state.nextCheck) == false
+		h.mu.Unlock()
+
+		if due {
+			dueZones = append(dueZones, dueZone{view, z})
+		}
+	})
+
+	h.mu.Lock()
+	for key := range h.refreshStates {
+		if seen[key] == false {
+			delete(h.refreshStates, key)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, due := range dueZones {
+		if h.addZoneToTransfer(due.view, due.zone.GetOrigin()) {
+			go h.refreshZone(due.view, due.zone)
+		}
+	}
+}
+
+func (h *XFRRunner) refreshZone(view string, z zone.Zone) {
+	name := z.GetOrigin()
+	defer h.removeZoneFromTransfer(view, name)
+
+	for _, master := range z.Masters() {
+		serial, err := querySOASerial(master, name)
+		if err != nil {
+			logger.GetLogger().Warn("query soa of zone: %s from master %s failed: %s", name.String(false), master, err.Error())
+			continue
+		}
+
+		if err := h.doXFR(view, z, serial, master); err == nil {
+			h.zoneRefreshed(view, z)
+			return
+		}
+	}
+	h.zoneRefreshFailed(view, z)
+}
+
+func querySOASerial(master string, name *g53.Name) (uint32, error) {
+	sender, err := util.NewUDPSender("", soaQueryTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, _, err := sender.Query(master, g53.NewMsgRender(), g53.MakeQuery(name, g53.RR_SOA, 512, false))
+	if err != nil {
+		return 0, err
+	}
+
+	answers := resp.GetSection(g53.AnswerSection)
+	if resp.Header.Rcode != g53.R_NOERROR || len(answers) == 0 || answers[0].Type != g53.RR_SOA {
+		return 0, errNoSOAInResponse
+	}
+	return answers[0].Rdatas[0].(*g53.SOA).Serial, nil
+}
+
+func (h *XFRRunner) zoneRefreshed(view string, z zone.Zone) {
+	now := time.Now()
+	timers, _ := getZoneTimers(z)
+	h.mu.Lock()
+	if state, ok := h.refreshStates[refreshKey(view, z.GetOrigin())]; ok && state.zone == z {
+		state.lastSuccess = now
+		state.failures = 0
+		state.nextCheck = now.Add(timers.refresh)
+	}
+	h.mu.Unlock()
+
+	if z.IsExpired() {
+		logger.GetLogger().Info("zone: %s in view: %s is refreshed and served again", z.GetOrigin().String(false), view)
+		z.SetExpired(false)
+	}
+}
+
+func (h *XFRRunner) zoneRefreshFailed(view string, z zone.Zone) {
+	now := time.Now()
+	timers, hasData := getZoneTimers(z)
+	expired := false
+	h.mu.Lock()
+	if state, ok := h.refreshStates[refreshKey(view, z.GetOrigin())]; ok && state.zone == z {
+		state.failures += 1
+		state.nextCheck = now.Add(retryInterval(timers, state.failures))
+		expired = hasData && state.lastSuccess.IsZero() == false && now.Sub(state.lastSuccess) >= timers.expire
+	}
+	h.mu.Unlock()
+
+	if expired && z.IsExpired() == false {
+		logger.GetLogger().Error("zone: %s in view: %s expired, stop serving it", z.GetOrigin().String(false), view)
+		z.SetExpired(true)
+	}
+}
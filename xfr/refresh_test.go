@@ -0,0 +1,66 @@
+package xfr
+
+import (
+	"testing"
+	"time"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+)
+
+func TestRetryInterval(t *testing.T) {
+	timers := zoneTimers{
+		refresh: 100 * time.Second,
+		retry:   15 * time.Second,
+		expire:  1000 * time.Second,
+	}
+	ut.Equal(t, retryInterval(timers, 1), 15*time.Second)
+	ut.Equal(t, retryInterval(timers, 2), 30*time.Second)
+	ut.Equal(t, retryInterval(timers, 3), 60*time.Second)
+	ut.Equal(t, retryInterval(timers, 4), 100*time.Second)
+	ut.Equal(t, retryInterval(timers, 100), 100*time.Second)
+}
+
+func TestZoneRefreshExpire(t *testing.T) {
+	z := createTransferZone(transferZoneData)
+	runner := newXFRRunner(nil)
+	key := refreshKey("default", z.GetOrigin())
+	runner.refreshStates[key] = &zoneRefreshState{
+		zone:        z,
+		lastSuccess: time.Now(),
+	}
+
+	runner.zoneRefreshFailed("default", z)
+	state := runner.refreshStates[key]
+	ut.Equal(t, state.failures, uint(1))
+	ut.Assert(t, state.nextCheck.After(time.Now()), "next check should be delayed")
+	ut.Equal(t, z.IsExpired(), false)
+
+	timers, _ := getZoneTimers(z)
+	state.lastSuccess = time.Now().Add(-timers.expire)
+	runner.zoneRefreshFailed("default", z)
+	ut.Equal(t, state.failures, uint(2))
+	ut.Equal(t, z.IsExpired(), true)
+
+	runner.zoneRefreshed("default", z)
+	ut.Equal(t, state.failures, uint(0))
+	ut.Equal(t, z.IsExpired(), false)
+}
+
+func TestIsXFRDone(t *testing.T) {
+	soa := func(serial string) *g53.RRset {
+		return rrsetFromString("example.com. 86400 IN SOA ns1.example.com. hostmaster.example.com. " + serial + " 10800 15 604800 10800")
+	}
+	a := rrsetFromString("www.example.com. 86400 IN A 192.168.0.2")
+
+	ut.Equal(t, isXFRDone(IXFR, g53.Section{soa("2")}), true)
+	ut.Equal(t, isXFRDone(IXFR, g53.Section{soa("2"), a}), false)
+	ut.Equal(t, isXFRDone(IXFR, g53.Section{soa("2"), a, soa("2")}), true)
+	ut.Equal(t, isXFRDone(IXFR, g53.Section{soa("2"), soa("1"), a, soa("2")}), false)
+	ut.Equal(t, isXFRDone(IXFR, g53.Section{soa("2"), soa("1"), a, soa("2"), a, soa("2")}), true)
+
+	//axfr isn't done until the closing soa
+	ut.Equal(t, isXFRDone(AXFR, g53.Section{soa("2")}), false)
+	ut.Equal(t, isXFRDone(AXFR, g53.Section{soa("2"), a}), false)
+	ut.Equal(t, isXFRDone(AXFR, g53.Section{soa("2"), a, soa("2")}), true)
+}
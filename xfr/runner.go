@@ -1,6 +1,8 @@
 package xfr
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/ben-han-cn/cement/domaintree"
@@ -12,6 +14,11 @@ import (
 	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
 )
 
+var (
+	errEmptyXFRResponse = errors.New("xfr response has empty answer section")
+	errNoSOAInResponse  = errors.New("soa response has no soa in answer section")
+)
+
 type xfrType string
 
 const (
@@ -22,14 +29,16 @@ const (
 type XFRRunner struct {
 	auth *auth.AuthDataSource
 
-	mu           sync.Mutex
-	inFlightIXFR map[string][]*g53.Name
+	mu            sync.Mutex
+	inFlightIXFR  map[string][]*g53.Name
+	refreshStates map[string]*zoneRefreshState
 }
 
 func newXFRRunner(auth *auth.AuthDataSource) *XFRRunner {
 	return &XFRRunner{
-		auth:         auth,
-		inFlightIXFR: make(map[string][]*g53.Name),
+		auth:          auth,
+		inFlightIXFR:  make(map[string][]*g53.Name),
+		refreshStates: make(map[string]*zoneRefreshState),
 	}
 }
 
@@ -50,12 +59,6 @@ func (h *XFRRunner) HandleNotify(ctx *core.Context) {
 		return
 	}
 
-	if h.addZoneToTransfer(view, targetZoneName) == false {
-		logger.GetLogger().Warn("zone: %s in view: %s is under ixfr", targetZoneName.String(false), view)
-		//let the master, notify the slave later
-		return
-	}
-
 	latestSerial := answers[0].Rdatas[0].(*g53.SOA).Serial
 	if targetZone.IsMaster() {
 		logger.GetLogger().Warn("zone: %s in view: %s is master", targetZoneName.String(false), view)
@@ -75,8 +78,19 @@ func (h *XFRRunner) HandleNotify(ctx *core.Context) {
 		return
 	}
 
+	if h.addZoneToTransfer(view, targetZoneName) == false {
+		logger.GetLogger().Warn("zone: %s in view: %s is under ixfr", targetZoneName.String(false), view)
+		//let the master, notify the slave later
+		return
+	}
+
 	ctx.Client.Response = notify.MakeResponse()
-	go h.doXFR(view, targetZone, latestSerial, master)
+	go func() {
+		defer h.removeZoneFromTransfer(view, targetZoneName)
+		if err := h.doXFR(view, targetZone, latestSerial, master); err == nil {
+			h.zoneRefreshed(view, targetZone)
+		}
+	}()
 }
 
 func (h *XFRRunner) addZoneToTransfer(view string, zone *g53.Name) bool {
@@ -111,77 +125,136 @@ func (h *XFRRunner) removeZoneFromTransfer(view string, zone *g53.Name) {
 	h.inFlightIXFR[view] = zones
 }
 
-func (h *XFRRunner) doXFR(view string, z zone.Zone, latestSerial uint32, master string) {
+func (h *XFRRunner) doXFR(view string, z zone.Zone, latestSerial uint32, master string) error {
 	name := z.GetOrigin()
-	defer h.removeZoneFromTransfer(view, name)
-
 	var request *g53.Message
 	var currentSerial uint32
 	var xfrType xfrType
 	soa := z.Find(name, g53.RR_SOA, zone.DefaultFind).GetResult().RRset
 	if soa == nil {
-		logger.GetLogger().Info("get notify for zone: %s in view: %s and vanguard have not data and will do axfr", name.String(false), view)
+		logger.GetLogger().Info("zone: %s in view: %s has no data and vanguard will do axfr", name.String(false), view)
 		xfrType = AXFR
 		request = g53.MakeAXFR(name, nil)
 	} else {
 		currentSerial = soa.Rdatas[0].(*g53.SOA).Serial
 		if g53.CompareSerial(currentSerial, latestSerial) != -1 {
-			logger.GetLogger().Warn("get notify for zone: %s in view: %s, which serial number is smaller than us", name.String(false), view)
-			return
+			logger.GetLogger().Warn("zone: %s in view: %s, serial number of master isn't larger than us", name.String(false), view)
+			return nil
 		}
 		xfrType = IXFR
-		logger.GetLogger().Info("get notify for zone: %s in view: %s and vanguard will do ixfr", name.String(false), view)
+		logger.GetLogger().Info("zone: %s in view: %s is outdated and vanguard will do ixfr", name.String(false), view)
 		request = g53.MakeIXFR(name, soa, nil)
 	}
 
+	answers, err := transferFromMaster(xfrType, master, request)
+	if err != nil {
+		logger.GetLogger().Error("%s zone: %s from server %s failed: %s", xfrType, name.String(false), master, err.Error())
+		return err
+	}
+
+	return h.updateZoneUseXFR(xfrType, z, currentSerial, latestSerial, answers)
+}
+
+func transferFromMaster(typ xfrType, master string, request *g53.Message) (g53.Section, error) {
 	conn, err := util.NewTCPConn(master)
 	if err != nil {
-		logger.GetLogger().Error("connect to server %s failed: %s", master, err.Error())
-		return
+		return nil, err
 	}
+	defer conn.Close()
 
 	render := g53.NewMsgRender()
+	request.RecalculateSectionRRCount()
 	request.Rend(render)
 	if err := util.TCPWrite(render.Data(), conn); err != nil {
-		logger.GetLogger().Error("send ixfr quer to server %s failed: %s", master, err.Error())
-		return
+		return nil, err
 	}
 
-	answerBuffer, err := util.TCPRead(conn)
-	if err != nil {
-		logger.GetLogger().Error("connect to server %s failed: %s", master, err.Error())
-		return
+	var answers g53.Section
+	for {
+		answerBuffer, err := util.TCPRead(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := g53.MessageFromWire(util.NewInputBuffer(answerBuffer))
+		if err != nil {
+			return nil, err
+		} else if resp.Header.Rcode != g53.R_NOERROR {
+			return nil, fmt.Errorf("get rcode %s", resp.Header.Rcode.String())
+		}
+
+		rrsets := resp.Sections[g53.AnswerSection]
+		if len(rrsets) == 0 {
+			return nil, errEmptyXFRResponse
+		}
+		answers = append(answers, rrsets...)
+		if isXFRDone(typ, answers) {
+			return answers, nil
+		}
 	}
+}
 
-	resp, err := g53.MessageFromWire(util.NewInputBuffer(answerBuffer))
-	answers := resp.Sections[g53.AnswerSection]
-	if err != nil {
-		logger.GetLogger().Error("invalid %s response: %v", xfrType, err)
-	} else if len(answers) == 0 {
-		logger.GetLogger().Error("empty %s response", xfrType)
+func isXFRDone(typ xfrType, answers g53.Section) bool {
+	//soa serial of each rr, 0 for none soa rr
+	var serials []uint32
+	var isSOA []bool
+	for _, rrset := range answers {
+		for _, rdata := range rrset.Rdatas {
+			if rrset.Type == g53.RR_SOA {
+				serials = append(serials, rdata.(*g53.SOA).Serial)
+				isSOA = append(isSOA, true)
+			} else {
+				serials = append(serials, 0)
+				isSOA = append(isSOA, false)
+			}
+		}
+	}
+
+	last := len(serials) - 1
+	if isSOA[0] == false {
+		return true
+	} else if last == 0 {
+		//ixfr response which only has soa means zone is up to date
+		return typ == IXFR
+	} else if isSOA[last] == false || serials[last] != serials[0] {
+		return false
+	} else if typ == AXFR {
+		return true
 	}
 
-	h.updateZoneUseXFR(xfrType, z, currentSerial, latestSerial, answers)
+	latestCount := 0
+	for i, serial := range serials {
+		if isSOA[i] && serial == serials[0] {
+			latestCount += 1
+		}
+	}
+
+	//latest soa shows three times in incremental transfer, and twice in axfr
+	if isSOA[1] && serials[1] != serials[0] {
+		return latestCount >= 3
+	}
+	return latestCount >= 2
 }
 
-func (h *XFRRunner) updateZoneUseXFR(typ xfrType, z zone.Zone, currentSerial, latestSerial uint32, answers g53.Section) {
+func (h *XFRRunner) updateZoneUseXFR(typ xfrType, z zone.Zone, currentSerial, latestSerial uint32, answers g53.Section) error {
 	updator, _ := z.GetUpdator(nil, true)
 	tx, err := updator.Begin()
 	if err != nil {
 		logger.GetLogger().Error("get zone transaction failed: %s", err.Error())
-		return
+		return err
 	}
 
 	sm := newFSMGenerator(typ, currentSerial, latestSerial, updator, tx).GenStateMachine()
 	if err := sm.Run(answers); err == nil {
-		tx.Commit()
 		logger.GetLogger().Info("%s succeed", typ)
+		return tx.Commit()
 	} else {
 		tx.RollBack()
 		logger.GetLogger().Warn("%s failed: %s", typ, err.Error())
 		if typ == IXFR {
 			logger.GetLogger().Info("IXFR failed try AXFR")
-			h.updateZoneUseXFR(AXFR, z, currentSerial, latestSerial, answers)
+			return h.updateZoneUseXFR(AXFR, z, currentSerial, latestSerial, answers)
 		}
+		return err
 	}
 }
@@ -0,0 +1,183 @@
+package xfr
+
+import (
+	"net"
+
+	"github.com/ben-han-cn/cement/domaintree"
+	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/core"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+	"github.com/ben-han-cn/vanguard/viewselector"
+)
+
+const maxTransferMessageSize = 16384
+
+func (h *XFRHandler) HandleTransfer(ctx *core.Context) []*g53.Message {
+	client := &ctx.Client
+	request := client.Request
+	var clientIP net.IP
+	if request.Tsig != nil {
+		if h.viewSelector.SelectTSIGView(ctx) == false {
+			setRcode(client, g53.R_NOTAUTH)
+			return nil
+		}
+	} else {
+		h.viewSelector.SelectView(ctx)
+		clientIP = client.IP()
+	}
+
+	z, result := h.runner.auth.GetZone(client.View, request.Question.Name)
+	if result != domaintree.ExactMatch {
+		setRcode(client, g53.R_NOTAUTH)
+		return nil
+	}
+
+	if z.IsExpired() {
+		setRcode(client, g53.R_SERVFAIL)
+		return nil
+	}
+
+	if z.AllowTransfer(clientIP) == false {
+		logger.GetLogger().Warn("%s transfer zone %s in view %s is refused", client.IP().String(),
+			request.Question.Name.String(false), client.View)
+		setRcode(client, g53.R_REFUSED)
+		return nil
+	}
+
+	var rrsets []*g53.RRset
+	if request.Question.Type == g53.RR_IXFR {
+		var rcode g53.Rcode
+		if rrsets, rcode = ixfrRRsets(z, request, client.UsingTCP); rcode != g53.R_NOERROR {
+			setRcode(client, rcode)
+			return nil
+		}
+	} else if client.UsingTCP == false {
+		setRcode(client, g53.R_REFUSED)
+		return nil
+	} else {
+		var err error
+		if rrsets, err = axfrRRsets(z); err != nil {
+			logger.GetLogger().Error("dump zone %s failed: %s", request.Question.Name.String(false), err.Error())
+			setRcode(client, g53.R_SERVFAIL)
+			return nil
+		}
+	}
+
+	var key *viewselector.TSIGKey
+	if request.Tsig != nil {
+		key = h.viewSelector.GetKey(request.Tsig.Header.Name.String(true))
+	}
+	messages, err := makeTransferMessages(request, rrsets, key)
+	if err != nil {
+		logger.GetLogger().Error("create transfer message for zone %s failed: %s", request.Question.Name.String(false), err.Error())
+		setRcode(client, g53.R_SERVFAIL)
+		return nil
+	}
+	return messages
+}
+
+func setRcode(client *core.Client, rcode g53.Rcode) {
+	if client.Response == nil {
+		client.Response = client.Request.MakeResponse()
+	}
+	client.Response.Header.Rcode = rcode
+}
+
+func axfrRRsets(z zone.Zone) ([]*g53.RRset, error) {
+	rrsets, err := z.Dump()
+	if err != nil {
+		return nil, err
+	}
+	//dump begins with soa
+	return append(rrsets, rrsets[0]), nil
+}
+
+func ixfrRRsets(z zone.Zone, request *g53.Message, usingTCP bool) ([]*g53.RRset, g53.Rcode) {
+	auths := request.GetSection(g53.AuthSection)
+	if len(auths) != 1 || auths[0].Type != g53.RR_SOA || len(auths[0].Rdatas) != 1 {
+		return nil, g53.R_FORMERR
+	}
+	clientSerial := auths[0].Rdatas[0].(*g53.SOA).Serial
+
+	soa := z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+	if soa == nil {
+		return nil, g53.R_SERVFAIL
+	}
+
+	//client is up to date, or the reply doesn't fit in udp which
+	//tells client to retry with tcp
+	if g53.CompareSerial(clientSerial, soa.Rdatas[0].(*g53.SOA).Serial) != -1 || usingTCP == false {
+		return []*g53.RRset{soa}, g53.R_NOERROR
+	}
+
+	diffs, ok := z.Journal(clientSerial)
+	if ok == false {
+		logger.GetLogger().Info("no journal for zone %s from serial %d, fall back to axfr",
+			z.GetOrigin().String(false), clientSerial)
+		rrsets, err := axfrRRsets(z)
+		if err != nil {
+			return nil, g53.R_SERVFAIL
+		}
+		return rrsets, g53.R_NOERROR
+	}
+
+	latestSOA := diffs[len(diffs)-1].NewSOA
+	rrsets := []*g53.RRset{latestSOA}
+	for _, diff := range diffs {
+		rrsets = append(rrsets, diff.OldSOA)
+		rrsets = append(rrsets, diff.Deleted...)
+		rrsets = append(rrsets, diff.NewSOA)
+		rrsets = append(rrsets, diff.Added...)
+	}
+	return append(rrsets, latestSOA), g53.R_NOERROR
+}
+
+func makeTransferMessage(request *g53.Message, key *viewselector.TSIGKey, requestMac []byte) (*g53.Message, error) {
+	msg := request.MakeResponse()
+	msg.Header.SetFlag(g53.FLAG_AA, true)
+	if key != nil {
+		tsig, err := g53.NewTSIG(key.Name, key.Secret, key.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		//mac of following messages is chained when they are rendered
+		tsig.MAC = requestMac
+		msg.SetTSIG(tsig)
+	}
+	return msg, nil
+}
+
+func makeTransferMessages(request *g53.Message, rrsets []*g53.RRset, key *viewselector.TSIGKey) ([]*g53.Message, error) {
+	var requestMac []byte
+	if request.Tsig != nil {
+		requestMac = request.Tsig.MAC
+	}
+
+	msg, err := makeTransferMessage(request, key, requestMac)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*g53.Message{msg}
+	msgSize := uint(0)
+	buf := gutil.NewOutputBuffer(512)
+	for _, rrset := range rrsets {
+		buf.Clear()
+		rrset.ToWire(buf)
+		if msgSize > 0 && msgSize+buf.Len() > maxTransferMessageSize {
+			if msg, err = makeTransferMessage(request, key, nil); err != nil {
+				return nil, err
+			}
+			messages = append(messages, msg)
+			msgSize = 0
+		}
+		msg.AddRRset(g53.AnswerSection, rrset)
+		msgSize += buf.Len()
+	}
+
+	for _, msg := range messages {
+		msg.RecalculateSectionRRCount()
+	}
+	return messages, nil
+}
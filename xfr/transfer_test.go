@@ -0,0 +1,205 @@
+package xfr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	ut "github.com/ben-han-cn/cement/unittest"
+	"github.com/ben-han-cn/g53"
+	"github.com/ben-han-cn/g53/util"
+	"github.com/ben-han-cn/vanguard/logger"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone"
+	"github.com/ben-han-cn/vanguard/resolver/auth/zone/memoryzone"
+	"github.com/ben-han-cn/vanguard/viewselector"
+)
+
+var transferZoneData = []string{
+	"example.com. 86400 IN SOA ns1.example.com. hostmaster.example.com. 2002022401 10800 15 604800 10800",
+	"example.com. 86400 IN NS ns1.example.com.",
+	"ns1.example.com. 86400 IN A 192.168.0.1",
+	"www.example.com. 86400 IN A 192.168.0.2",
+	"www.example.com. 86400 IN A 192.168.0.3",
+}
+
+func createTransferZone(data []string) zone.Zone {
+	z := memoryzone.NewDynamicZone(g53.NameFromStringUnsafe("example.com."))
+	updator, _ := z.GetUpdator(nil, true)
+	tx, _ := updator.Begin()
+	for _, rr := range data {
+		updator.Add(tx, rrsetFromString(rr))
+	}
+	tx.Commit()
+	return z
+}
+
+func zoneSOA(z zone.Zone) *g53.RRset {
+	return z.Find(z.GetOrigin(), g53.RR_SOA, zone.DefaultFind).GetResult().RRset
+}
+
+func transferOverWire(messages []*g53.Message) g53.Section {
+	var answers g53.Section
+	for _, msg := range messages {
+		render := g53.NewMsgRender()
+		msg.Rend(render)
+		resp, _ := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+		answers = append(answers, resp.GetSection(g53.AnswerSection)...)
+	}
+	return answers
+}
+
+func TestIXFRFromJournal(t *testing.T) {
+	logger.UseDefaultLogger("debug")
+
+	master := createTransferZone(transferZoneData)
+	slave := createTransferZone(transferZoneData)
+	oldSOA := zoneSOA(slave)
+
+	updator, _ := master.GetUpdator(nil, true)
+	tx, _ := updator.Begin()
+	updator.Add(tx, rrsetFromString("ftp.example.com. 86400 IN A 192.168.0.4"))
+	updator.DeleteRr(tx, rrsetFromString("www.example.com. 86400 IN A 192.168.0.3"))
+	updator.IncreaseSerialNumber(tx)
+	tx.Commit()
+
+	request := g53.MakeIXFR(master.GetOrigin(), oldSOA, nil)
+	rrsets, rcode := ixfrRRsets(master, request, false)
+	ut.Equal(t, rcode, g53.R_NOERROR)
+	ut.Equal(t, len(rrsets), 1)
+
+	rrsets, rcode = ixfrRRsets(master, request, true)
+	ut.Equal(t, rcode, g53.R_NOERROR)
+	ut.Equal(t, len(rrsets), 6)
+
+	slaveUpdator, _ := slave.GetUpdator(nil, true)
+	slaveTx, _ := slaveUpdator.Begin()
+	sm := newFSMGenerator(IXFR, 2002022401, 2002022402, slaveUpdator, slaveTx).GenStateMachine()
+	messages, err := makeTransferMessages(request, rrsets, nil)
+	ut.Assert(t, err == nil, "make transfer messages failed %v", err)
+	err = sm.Run(transferOverWire(messages))
+	ut.Assert(t, err == nil, "ixfr should succeed but get %v", err)
+	slaveTx.Commit()
+
+	ut.Equal(t, zoneSOA(slave).Rdatas[0].(*g53.SOA).Serial, uint32(2002022402))
+	www := slave.Find(g53.NameFromStringUnsafe("www.example.com."), g53.RR_A, zone.DefaultFind).GetResult()
+	ut.Equal(t, www.RRset.RRCount(), 1)
+	ftp := slave.Find(g53.NameFromStringUnsafe("ftp.example.com."), g53.RR_A, zone.DefaultFind).GetResult()
+	ut.Equal(t, ftp.Type, zone.FRSuccess)
+
+	request = g53.MakeIXFR(master.GetOrigin(), zoneSOA(master), nil)
+	rrsets, _ = ixfrRRsets(master, request, true)
+	ut.Equal(t, len(rrsets), 1)
+}
+
+func TestAXFRMessages(t *testing.T) {
+	master := createTransferZone(transferZoneData)
+	rrsets, err := axfrRRsets(master)
+	ut.Assert(t, err == nil, "dump zone failed %v", err)
+	ut.Equal(t, rrsets[0].Type, g53.RR_SOA)
+	ut.Equal(t, rrsets[len(rrsets)-1].Type, g53.RR_SOA)
+
+	var large []*g53.RRset
+	for i := 0; i < 1000; i++ {
+		large = append(large, rrsets[1:len(rrsets)-1]...)
+	}
+	large = append(append([]*g53.RRset{rrsets[0]}, large...), rrsets[0])
+
+	request := g53.MakeAXFR(master.GetOrigin(), nil)
+	messages, err := makeTransferMessages(request, large, nil)
+	ut.Assert(t, err == nil, "make transfer messages failed %v", err)
+	ut.Assert(t, len(messages) > 1, "large zone should be split")
+	answers := transferOverWire(messages)
+	ut.Equal(t, answers[0].Type, g53.RR_SOA)
+	ut.Equal(t, answers[len(answers)-1].Type, g53.RR_SOA)
+}
+
+func TestTransferTSIG(t *testing.T) {
+	key := &viewselector.TSIGKey{
+		Name:      "key.",
+		Secret:    "emRucw==",
+		Algorithm: "hmac-sha256",
+	}
+	tsig, _ := g53.NewTSIG(key.Name, key.Secret, key.Algorithm)
+	request := g53.MakeAXFR(g53.NameFromStringUnsafe("example.com."), tsig)
+	render := g53.NewMsgRender()
+	request.Rend(render)
+	request, _ = g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+
+	rrset := rrsetFromString(transferZoneData[0])
+	messages, err := makeTransferMessages(request, []*g53.RRset{rrset, rrset}, key)
+	ut.Assert(t, err == nil, "make transfer messages failed %v", err)
+	for i := 0; i < 2; i++ {
+		msg, err := makeTransferMessage(request, key, nil)
+		ut.Assert(t, err == nil, "make transfer message failed %v", err)
+		messages = append(messages, msg)
+	}
+
+	secret, _ := base64.StdEncoding.DecodeString(key.Secret)
+	prevMac := request.Tsig.MAC
+	for i, msg := range messages {
+		render := g53.NewMsgRender()
+		msg.RecalculateSectionRRCount()
+		tsig := msg.Tsig
+		if i == 0 {
+			msg.Rend(render)
+		} else {
+			msg.Tsig = nil
+			msg.Rend(render)
+			ut.Assert(t, signTimersOnly(tsig, key.Secret, render, prevMac) == nil, "sign should succeed")
+		}
+		resp, err := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+		ut.Assert(t, err == nil, "parse response failed %v", err)
+		ut.Assert(t, resp.Tsig != nil, "response should be signed")
+		ut.Equal(t, resp.Header.ARCount, uint16(1))
+
+		if i == 0 {
+			err = resp.Tsig.VerifyTsig(resp, key.Secret, prevMac)
+			ut.Assert(t, err == nil, "verify tsig failed %v", err)
+		} else {
+			mac := resp.Tsig.MAC
+			resp.Tsig = nil
+			resp.RecalculateSectionRRCount()
+			unsigned := g53.NewMsgRender()
+			resp.Rend(unsigned)
+			h := hmac.New(sha256.New, secret)
+			h.Write([]byte{byte(len(prevMac) >> 8), byte(len(prevMac))})
+			h.Write(prevMac)
+			h.Write(unsigned.Data())
+			h.Write([]byte{0, 0, byte(tsig.TimeSigned >> 24), byte(tsig.TimeSigned >> 16), byte(tsig.TimeSigned >> 8), byte(tsig.TimeSigned)})
+			h.Write([]byte{byte(tsig.Fudge >> 8), byte(tsig.Fudge)})
+			ut.Equal(t, mac, h.Sum(nil))
+		}
+		prevMac = tsig.MAC
+	}
+
+	badKey := &viewselector.TSIGKey{
+		Name:      "key.",
+		Secret:    "emRucw==",
+		Algorithm: "hmac-unknown",
+	}
+	_, err = makeTransferMessages(request, []*g53.RRset{rrset}, badKey)
+	ut.Assert(t, err != nil, "invalid key should fail")
+}
+
+func TestSignTimersOnly(t *testing.T) {
+	request := g53.MakeAXFR(g53.NameFromStringUnsafe("example.com."), nil)
+	msg := request.MakeResponse()
+	msg.Header.Id = 0x1234
+	msg.AddRRset(g53.AnswerSection, rrsetFromString("www.example.com. 86400 IN A 192.168.0.2"))
+	msg.RecalculateSectionRRCount()
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+
+	tsig, _ := g53.NewTSIG("key.", "emRucw==", "hmac-sha256")
+	tsig.TimeSigned = 1600000000
+	tsig.OrigId = 0x1234
+	priorMac, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	ut.Assert(t, signTimersOnly(tsig, "emRucw==", render, priorMac) == nil, "sign should succeed")
+	ut.Equal(t, hex.EncodeToString(tsig.MAC), "46f5f049d0fa81f4bde79d33fb5255e405679ebd1c28dc1cb0ab7464a468cbe2")
+	ut.Equal(t, hex.EncodeToString(render.Data()),
+		"123480000001000100000001076578616d706c6503636f6d0000fc000103777777c00c00010001000151800004c0a80002"+
+			"036b65790000fa00ff00000000003d0b686d61632d7368613235360000005f5e1000012c0020"+
+			"46f5f049d0fa81f4bde79d33fb5255e405679ebd1c28dc1cb0ab7464a468cbe2123400000000")
+}
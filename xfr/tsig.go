@@ -0,0 +1,75 @@
+package xfr
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+
+	"github.com/ben-han-cn/g53"
+	gutil "github.com/ben-han-cn/g53/util"
+)
+
+var errUnknownTSIGKey = errors.New("tsig key is unknown")
+var errUnknownTSIGAlgorithm = errors.New("tsig algorithm is unknown")
+
+const arcountOffset = 10
+
+func (h *XFRHandler) SignTransferMessage(tsig *g53.TSIG, render *g53.MsgRender, priorMac []byte) error {
+	key := h.viewSelector.GetKey(tsig.Header.Name.String(true))
+	if key == nil {
+		return errUnknownTSIGKey
+	}
+	return signTimersOnly(tsig, key.Secret, render, priorMac)
+}
+
+func signTimersOnly(tsig *g53.TSIG, secret string, render *g53.MsgRender, priorMac []byte) error {
+	//rfc8945 5.3.1, message following the first one of a transfer digests
+	//the prior mac, the message without tsig and the timers only
+	mac, err := tsigHash(tsig.Algorithm, secret)
+	if err != nil {
+		return err
+	}
+
+	buf := gutil.NewOutputBuffer(512)
+	buf.WriteUint16(uint16(len(priorMac)))
+	buf.WriteData(priorMac)
+	buf.WriteData(render.Data())
+	buf.WriteUint16(uint16(tsig.TimeSigned >> 32))
+	buf.WriteUint32(uint32(tsig.TimeSigned))
+	buf.WriteUint16(tsig.Fudge)
+	mac.Write(buf.Data())
+	tsig.MAC = mac.Sum(nil)
+	tsig.MACSize = uint16(len(tsig.MAC))
+
+	data := render.Data()
+	arcount := uint16(data[arcountOffset])<<8 | uint16(data[arcountOffset+1])
+	buf.Clear()
+	tsig.ToWire(buf)
+	render.WriteData(buf.Data())
+	return render.WriteUint16At(arcount+1, arcountOffset)
+}
+
+func tsigHash(alg g53.TSIGAlgorithm, secret string) (hash.Hash, error) {
+	rawSecret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case g53.HmacMD5:
+		return hmac.New(md5.New, rawSecret), nil
+	case g53.HmacSHA1:
+		return hmac.New(sha1.New, rawSecret), nil
+	case g53.HmacSHA256:
+		return hmac.New(sha256.New, rawSecret), nil
+	case g53.HmacSHA512:
+		return hmac.New(sha512.New, rawSecret), nil
+	default:
+		return nil, errUnknownTSIGAlgorithm
+	}
+}
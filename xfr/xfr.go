@@ -14,9 +14,12 @@ type XFRHandler struct {
 }
 
 func NewXFRHandler(viewselector *viewselector.SelectorMgr, auth *auth.AuthDataSource) *XFRHandler {
+	auth.SetZoneCommitHandler(newNotifier(viewselector).zoneChanged)
+	runner := newXFRRunner(auth)
+	go runner.runRefresh()
 	return &XFRHandler{
 		viewSelector: viewselector,
-		runner:       newXFRRunner(auth),
+		runner:       runner,
 	}
 }
 